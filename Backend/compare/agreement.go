@@ -0,0 +1,98 @@
+package compare
+
+import "sort"
+
+// Counts are box totals for a set of compared results. Precision is the
+// share of candidate boxes that match a baseline box and Recall the share of
+// baseline boxes the candidate found, treating the baseline as ground truth.
+type Counts struct {
+	Baseline  int     `json:"baseline"`
+	Candidate int     `json:"candidate"`
+	Matched   int     `json:"matched"`
+	Shifted   int     `json:"shifted"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+type ClassCounts struct {
+	Class string `json:"class"`
+	Counts
+}
+
+// Agreement accumulates diffs over many images into overall and per-class
+// agreement between a baseline and a candidate.
+type Agreement struct {
+	Images   int           `json:"images"`
+	Overall  Counts        `json:"overall"`
+	PerClass []ClassCounts `json:"perClass"`
+
+	classes map[string]*Counts
+}
+
+func NewAgreement() *Agreement {
+	return &Agreement{classes: make(map[string]*Counts)}
+}
+
+// Add counts one image's diff.
+func (a *Agreement) Add(diff Diff) {
+	a.Images++
+	a.Overall.Baseline += diff.Summary.Baseline
+	a.Overall.Candidate += diff.Summary.Candidate
+	a.Overall.Matched += diff.Summary.Matched
+	a.Overall.Shifted += diff.Summary.Shifted
+
+	for _, match := range diff.Unchanged {
+		counts := a.class(match.Class)
+		counts.Baseline++
+		counts.Candidate++
+		counts.Matched++
+	}
+	for _, match := range diff.Shifted {
+		counts := a.class(match.Class)
+		counts.Baseline++
+		counts.Candidate++
+		counts.Matched++
+		counts.Shifted++
+	}
+	for _, box := range diff.Removed {
+		a.class(box.Class).Baseline++
+	}
+	for _, box := range diff.Added {
+		a.class(box.Class).Candidate++
+	}
+}
+
+// Finish computes the ratios and fills PerClass, sorted by class name.
+func (a *Agreement) Finish() *Agreement {
+	a.Overall.ratios()
+	a.PerClass = make([]ClassCounts, 0, len(a.classes))
+	for class, counts := range a.classes {
+		counts.ratios()
+		a.PerClass = append(a.PerClass, ClassCounts{Class: class, Counts: *counts})
+	}
+	sort.Slice(a.PerClass, func(i, j int) bool { return a.PerClass[i].Class < a.PerClass[j].Class })
+	return a
+}
+
+func (a *Agreement) class(name string) *Counts {
+	counts, ok := a.classes[name]
+	if !ok {
+		counts = &Counts{}
+		a.classes[name] = counts
+	}
+	return counts
+}
+
+func (c *Counts) ratios() {
+	c.Precision, c.Recall, c.F1 = 0, 0, 0
+	if c.Candidate > 0 {
+		c.Precision = float64(c.Matched) / float64(c.Candidate)
+	}
+	if c.Baseline > 0 {
+		c.Recall = float64(c.Matched) / float64(c.Baseline)
+	}
+	if c.Precision+c.Recall > 0 {
+		c.F1 = 2 * c.Precision * c.Recall / (c.Precision + c.Recall)
+	}
+}
@@ -0,0 +1,201 @@
+// Package compare matches the boxes of two detection results so runs and
+// models can be checked against each other.
+package compare
+
+import (
+	"math"
+	"sort"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+// Defaults used when Options leaves a threshold at zero.
+const (
+	DefaultMatchIoU = 0.5
+	DefaultShiftIoU = 0.9
+)
+
+// Options controls how boxes are paired. Two boxes of the same class match
+// when their IoU is at least MatchIoU; a matched pair whose IoU is below
+// ShiftIoU counts as shifted.
+type Options struct {
+	MatchIoU float64
+	ShiftIoU float64
+}
+
+type MatchedBox struct {
+	Class           string            `json:"class"`
+	Baseline        models.Coordinate `json:"baseline"`
+	Candidate       models.Coordinate `json:"candidate"`
+	IoU             float64           `json:"iou"`
+	ConfidenceDelta float32           `json:"confidenceDelta"`
+}
+
+type UnmatchedBox struct {
+	Class string            `json:"class"`
+	Box   models.Coordinate `json:"box"`
+}
+
+// Summary counts boxes by outcome. Matched includes the shifted boxes.
+type Summary struct {
+	Baseline            int     `json:"baseline"`
+	Candidate           int     `json:"candidate"`
+	Matched             int     `json:"matched"`
+	Shifted             int     `json:"shifted"`
+	Added               int     `json:"added"`
+	Removed             int     `json:"removed"`
+	MeanConfidenceDelta float32 `json:"meanConfidenceDelta"`
+}
+
+// Diff is the difference between a baseline and a candidate result. Added
+// boxes only appear in the candidate, removed boxes only in the baseline.
+type Diff struct {
+	MatchIoU  float64        `json:"matchIoU"`
+	ShiftIoU  float64        `json:"shiftIoU"`
+	Unchanged []MatchedBox   `json:"unchanged"`
+	Shifted   []MatchedBox   `json:"shifted"`
+	Added     []UnmatchedBox `json:"added"`
+	Removed   []UnmatchedBox `json:"removed"`
+	Summary   Summary        `json:"summary"`
+}
+
+func (o Options) withDefaults() Options {
+	if o.MatchIoU <= 0 {
+		o.MatchIoU = DefaultMatchIoU
+	}
+	if o.ShiftIoU <= 0 {
+		o.ShiftIoU = DefaultShiftIoU
+	}
+	return o
+}
+
+// Results pairs the boxes of baseline and candidate class by class. Pairs are
+// taken greedily from the highest IoU down, so each box matches at most once.
+func Results(baseline []models.DetectedObject, candidate []models.DetectedObject, options Options) Diff {
+	options = options.withDefaults()
+	diff := Diff{
+		MatchIoU:  options.MatchIoU,
+		ShiftIoU:  options.ShiftIoU,
+		Unchanged: []MatchedBox{},
+		Shifted:   []MatchedBox{},
+		Added:     []UnmatchedBox{},
+		Removed:   []UnmatchedBox{},
+	}
+
+	baselineBoxes := boxesByClass(baseline)
+	candidateBoxes := boxesByClass(candidate)
+
+	var confidenceDeltaSum float32
+	for _, class := range classNames(baselineBoxes, candidateBoxes) {
+		matches, removed, added := matchBoxes(baselineBoxes[class], candidateBoxes[class], options.MatchIoU)
+		for _, match := range matches {
+			match.Class = class
+			confidenceDeltaSum += match.ConfidenceDelta
+			if match.IoU < options.ShiftIoU {
+				diff.Shifted = append(diff.Shifted, match)
+			} else {
+				diff.Unchanged = append(diff.Unchanged, match)
+			}
+		}
+		for _, box := range removed {
+			diff.Removed = append(diff.Removed, UnmatchedBox{Class: class, Box: box})
+		}
+		for _, box := range added {
+			diff.Added = append(diff.Added, UnmatchedBox{Class: class, Box: box})
+		}
+		diff.Summary.Baseline += len(baselineBoxes[class])
+		diff.Summary.Candidate += len(candidateBoxes[class])
+	}
+
+	diff.Summary.Matched = len(diff.Unchanged) + len(diff.Shifted)
+	diff.Summary.Shifted = len(diff.Shifted)
+	diff.Summary.Added = len(diff.Added)
+	diff.Summary.Removed = len(diff.Removed)
+	if diff.Summary.Matched > 0 {
+		diff.Summary.MeanConfidenceDelta = confidenceDeltaSum / float32(diff.Summary.Matched)
+	}
+	return diff
+}
+
+// matchBoxes pairs boxes of a single class and returns the pairs together
+// with the baseline and candidate boxes left over.
+func matchBoxes(baseline []models.Coordinate, candidate []models.Coordinate, matchIoU float64) ([]MatchedBox, []models.Coordinate, []models.Coordinate) {
+	type pair struct {
+		b, c int
+		iou  float64
+	}
+	var pairs []pair
+	for b := range baseline {
+		for c := range candidate {
+			if iou := IoU(baseline[b], candidate[c]); iou >= matchIoU {
+				pairs = append(pairs, pair{b, c, iou})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].iou > pairs[j].iou })
+
+	baselineUsed := make([]bool, len(baseline))
+	candidateUsed := make([]bool, len(candidate))
+	var matches []MatchedBox
+	for _, p := range pairs {
+		if baselineUsed[p.b] || candidateUsed[p.c] {
+			continue
+		}
+		baselineUsed[p.b] = true
+		candidateUsed[p.c] = true
+		matches = append(matches, MatchedBox{
+			Baseline:        baseline[p.b],
+			Candidate:       candidate[p.c],
+			IoU:             math.Round(p.iou*1000) / 1000,
+			ConfidenceDelta: candidate[p.c].Confidence - baseline[p.b].Confidence,
+		})
+	}
+
+	var removed, added []models.Coordinate
+	for b, used := range baselineUsed {
+		if !used {
+			removed = append(removed, baseline[b])
+		}
+	}
+	for c, used := range candidateUsed {
+		if !used {
+			added = append(added, candidate[c])
+		}
+	}
+	return matches, removed, added
+}
+
+// IoU returns the intersection over union of two boxes.
+func IoU(a models.Coordinate, b models.Coordinate) float64 {
+	interW := min(a.X_max, b.X_max) - max(a.X_min, b.X_min)
+	interH := min(a.Y_max, b.Y_max) - max(a.Y_min, b.Y_min)
+	if interW <= 0 || interH <= 0 {
+		return 0
+	}
+	inter := float64(interW * interH)
+	union := float64((a.X_max-a.X_min)*(a.Y_max-a.Y_min)+(b.X_max-b.X_min)*(b.Y_max-b.Y_min)) - inter
+	return inter / union
+}
+
+func boxesByClass(objects []models.DetectedObject) map[string][]models.Coordinate {
+	boxes := make(map[string][]models.Coordinate)
+	for _, object := range objects {
+		boxes[object.Name] = append(boxes[object.Name], object.Coordinates...)
+	}
+	return boxes
+}
+
+func classNames(maps ...map[string][]models.Coordinate) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range maps {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,154 @@
+package compare
+
+import (
+	"math"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+func box(xMin, yMin, xMax, yMax int, confidence float32) models.Coordinate {
+	return models.Coordinate{X_min: xMin, Y_min: yMin, X_max: xMax, Y_max: yMax, Confidence: confidence}
+}
+
+func objects(class string, boxes ...models.Coordinate) []models.DetectedObject {
+	return []models.DetectedObject{{Name: class, Coordinates: boxes}}
+}
+
+func TestResults(t *testing.T) {
+	square := box(0, 0, 100, 100, 0.9)
+	// IoU with square is 0.8: above the match threshold, below the shift one.
+	nudged := box(0, 0, 100, 80, 0.7)
+	// IoU with square is 1/3, below the match threshold.
+	far := box(50, 0, 150, 100, 0.8)
+
+	for _, test := range []struct {
+		name                string
+		baseline, candidate []models.DetectedObject
+		options             Options
+		want                Summary
+		wantIoU             float64
+	}{
+		{
+			name:     "match",
+			baseline: objects("cat", square), candidate: objects("cat", square),
+			want:    Summary{Baseline: 1, Candidate: 1, Matched: 1},
+			wantIoU: 1,
+		},
+		{
+			name:     "shifted",
+			baseline: objects("cat", square), candidate: objects("cat", nudged),
+			want:    Summary{Baseline: 1, Candidate: 1, Matched: 1, Shifted: 1, MeanConfidenceDelta: -0.2},
+			wantIoU: 0.8,
+		},
+		{
+			name:     "shift threshold lowered",
+			baseline: objects("cat", square), candidate: objects("cat", nudged),
+			options: Options{ShiftIoU: 0.75},
+			want:    Summary{Baseline: 1, Candidate: 1, Matched: 1, MeanConfidenceDelta: -0.2},
+			wantIoU: 0.8,
+		},
+		{
+			name:     "below match threshold",
+			baseline: objects("cat", square), candidate: objects("cat", far),
+			want: Summary{Baseline: 1, Candidate: 1, Added: 1, Removed: 1},
+		},
+		{
+			name:     "match threshold lowered",
+			baseline: objects("cat", square), candidate: objects("cat", far),
+			options: Options{MatchIoU: 0.3},
+			want:    Summary{Baseline: 1, Candidate: 1, Matched: 1, Shifted: 1, MeanConfidenceDelta: -0.1},
+			wantIoU: 0.333,
+		},
+		{
+			name:     "other class",
+			baseline: objects("cat", square), candidate: objects("dog", square),
+			want: Summary{Baseline: 1, Candidate: 1, Added: 1, Removed: 1},
+		},
+		{
+			// Each box pairs once, the closest pair first.
+			name:     "best pair",
+			baseline: objects("cat", square), candidate: objects("cat", nudged, square),
+			want:    Summary{Baseline: 1, Candidate: 2, Matched: 1, Added: 1},
+			wantIoU: 1,
+		},
+		{name: "empty baseline", candidate: objects("cat", square), want: Summary{Candidate: 1, Added: 1}},
+		{name: "empty candidate", baseline: objects("cat", square), want: Summary{Baseline: 1, Removed: 1}},
+		{name: "both empty"},
+	} {
+		diff := Results(test.baseline, test.candidate, test.options)
+		got := diff.Summary
+		if math.Abs(float64(got.MeanConfidenceDelta-test.want.MeanConfidenceDelta)) < 1e-6 {
+			got.MeanConfidenceDelta = test.want.MeanConfidenceDelta
+		}
+		if got != test.want {
+			t.Errorf("%s: summary %+v, want %+v", test.name, got, test.want)
+		}
+		if len(diff.Unchanged)+len(diff.Shifted) != got.Matched || len(diff.Added) != got.Added || len(diff.Removed) != got.Removed {
+			t.Errorf("%s: %d unchanged, %d shifted, %d added, %d removed do not add up to %+v",
+				test.name, len(diff.Unchanged), len(diff.Shifted), len(diff.Added), len(diff.Removed), got)
+		}
+		for _, match := range append(diff.Unchanged, diff.Shifted...) {
+			if match.IoU != test.wantIoU {
+				t.Errorf("%s: IoU %v, want %v", test.name, match.IoU, test.wantIoU)
+			}
+		}
+		wantOptions := Options{MatchIoU: DefaultMatchIoU, ShiftIoU: DefaultShiftIoU}
+		if test.options.MatchIoU > 0 {
+			wantOptions.MatchIoU = test.options.MatchIoU
+		}
+		if test.options.ShiftIoU > 0 {
+			wantOptions.ShiftIoU = test.options.ShiftIoU
+		}
+		if diff.MatchIoU != wantOptions.MatchIoU || diff.ShiftIoU != wantOptions.ShiftIoU {
+			t.Errorf("%s: thresholds %v and %v, want %+v", test.name, diff.MatchIoU, diff.ShiftIoU, wantOptions)
+		}
+	}
+}
+
+func TestAgreement(t *testing.T) {
+	square := box(0, 0, 100, 100, 0.9)
+	other := box(200, 200, 300, 300, 0.5)
+
+	agreement := NewAgreement()
+	// cat: one of two baseline boxes found; dog: one box found out of
+	// nothing; bird: nothing on either side.
+	agreement.Add(Results(objects("cat", square, other), objects("cat", square), Options{}))
+	agreement.Add(Results(nil, objects("dog", square), Options{}))
+	agreement.Add(Results(nil, nil, Options{}))
+	agreement.Finish()
+
+	if agreement.Images != 3 {
+		t.Errorf("images %d, want 3", agreement.Images)
+	}
+	want := Counts{Baseline: 2, Candidate: 2, Matched: 1, Precision: 0.5, Recall: 0.5, F1: 0.5}
+	if agreement.Overall != want {
+		t.Errorf("overall %+v, want %+v", agreement.Overall, want)
+	}
+
+	wantClasses := []ClassCounts{
+		{Class: "cat", Counts: Counts{Baseline: 2, Candidate: 1, Matched: 1, Precision: 1, Recall: 0.5, F1: 2.0 / 3}},
+		// No baseline boxes and no matches: the ratios stay zero.
+		{Class: "dog", Counts: Counts{Candidate: 1}},
+	}
+	if len(agreement.PerClass) != len(wantClasses) {
+		t.Fatalf("per class %+v, want %+v", agreement.PerClass, wantClasses)
+	}
+	for i, got := range agreement.PerClass {
+		if got != wantClasses[i] {
+			t.Errorf("class %d: %+v, want %+v", i, got, wantClasses[i])
+		}
+	}
+}
+
+func TestAgreementEmpty(t *testing.T) {
+	agreement := NewAgreement().Finish()
+	if agreement.Overall != (Counts{}) || len(agreement.PerClass) != 0 {
+		t.Errorf("empty agreement %+v", agreement)
+	}
+	for _, ratio := range []float64{agreement.Overall.Precision, agreement.Overall.Recall, agreement.Overall.F1} {
+		if math.IsNaN(ratio) {
+			t.Error("empty agreement has a NaN ratio")
+		}
+	}
+}
@@ -1,36 +1,46 @@
 package configs
 
 import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/hkdf"
 )
 
-func EnvMongoURI() string {
-	err := godotenv.Load()
-	if err != nil {
+// loadEnv reads .env into the environment. The file is optional: without
+// it, settings come from the process environment alone, as in tests.
+func loadEnv() {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
+}
+
+func EnvMongoURI() string {
+	loadEnv()
 
 	return os.Getenv("MONGOURI")
 }
 
+// MongoDatabase is the database the collections live in.
+func MongoDatabase() string {
+	return envStringOrDefault("MONGO_DATABASE", "ImageDetection")
+}
+
 func JWTSecret() string {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	loadEnv()
 
 	return os.Getenv("JWT_SECRET")
 }
 
 func JWTCookieExpire() int {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	loadEnv()
 	expireStr := os.Getenv("JWT_COOKIE_EXPIRE")
 	expireInt, err := strconv.Atoi(expireStr)
 	if err != nil {
@@ -38,3 +48,253 @@ func JWTCookieExpire() int {
 	}
 	return expireInt
 }
+
+func DetectionWorkers() int {
+	return envIntOrDefault("DETECTION_WORKERS", 2)
+}
+
+func DetectionQueueSize() int {
+	return envIntOrDefault("DETECTION_QUEUE_SIZE", 100)
+}
+
+// DetectionTimeout is the number of seconds a single detection run may take
+// before it is killed and the image is marked as failed.
+func DetectionTimeout() int {
+	return envIntOrDefault("DETECTION_TIMEOUT", 300)
+}
+
+// InferenceMaxImageSize is the largest input size an upload may request.
+func InferenceMaxImageSize() int {
+	return envIntOrDefault("INFERENCE_MAX_IMAGE_SIZE", 1280)
+}
+
+// InferenceMaxDetections is the largest max detections an upload may request.
+func InferenceMaxDetections() int {
+	return envIntOrDefault("INFERENCE_MAX_DETECTIONS", 1000)
+}
+
+// BatchMaxFiles is the most images a single batch upload may contain,
+// counting the files inside zip archives.
+func BatchMaxFiles() int {
+	return envIntOrDefault("BATCH_MAX_FILES", 500)
+}
+
+// StorageBackend selects where image files are kept: "local" keeps them
+// under StorageLocalRoot, "s3" in an S3-compatible bucket such as MinIO.
+func StorageBackend() string {
+	return envStringOrDefault("STORAGE_BACKEND", "local")
+}
+
+func StorageLocalRoot() string {
+	return envStringOrDefault("STORAGE_LOCAL_ROOT", ".")
+}
+
+func S3Endpoint() string {
+	return envStringOrDefault("S3_ENDPOINT", "localhost:9000")
+}
+
+func S3AccessKey() string {
+	return envStringOrDefault("S3_ACCESS_KEY", "")
+}
+
+func S3SecretKey() string {
+	return envStringOrDefault("S3_SECRET_KEY", "")
+}
+
+func S3Bucket() string {
+	return envStringOrDefault("S3_BUCKET", "images")
+}
+
+func S3Region() string {
+	return envStringOrDefault("S3_REGION", "")
+}
+
+func S3UseSSL() bool {
+	return envStringOrDefault("S3_USE_SSL", "false") == "true"
+}
+
+// UploadMaxBytes is the largest image file accepted, in bytes.
+func UploadMaxBytes() int64 {
+	return int64(envIntOrDefault("UPLOAD_MAX_BYTES", 50<<20))
+}
+
+// BatchMaxBytes is the largest batch upload request accepted, in bytes.
+func BatchMaxBytes() int64 {
+	return int64(envIntOrDefault("BATCH_MAX_BYTES", 1<<30))
+}
+
+func UploadMaxWidth() int {
+	return envIntOrDefault("UPLOAD_MAX_WIDTH", 12000)
+}
+
+func UploadMaxHeight() int {
+	return envIntOrDefault("UPLOAD_MAX_HEIGHT", 12000)
+}
+
+// UploadMaxPixels caps width times height, which bounds the memory needed to
+// decode an upload however well it compresses.
+func UploadMaxPixels() int64 {
+	return int64(envIntOrDefault("UPLOAD_MAX_PIXELS", 50_000_000))
+}
+
+// URLSigningKey is the secret file links are signed with, set by
+// URL_SIGNING_KEY. Without it the key is derived from the JWT secret with
+// HKDF, so existing deployments keep working but a leaked link signature
+// says nothing about the key session tokens are signed with. It is nil when
+// neither is set.
+func URLSigningKey() []byte {
+	if key := envStringOrDefault("URL_SIGNING_KEY", ""); key != "" {
+		return []byte(key)
+	}
+	secret := JWTSecret()
+	if secret == "" {
+		return nil
+	}
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(urlSigningKeyInfo)), key); err != nil {
+		log.Fatal("Error deriving the URL signing key")
+	}
+	return key
+}
+
+// urlSigningKeyInfo is the HKDF label the URL signing key is derived from
+// the JWT secret under. Changing it invalidates every link handed out.
+const urlSigningKeyInfo = "ImageDetection file URL signing key v1"
+
+// SignedURLExpiry is how long a file link handed out by the API stays
+// valid, in seconds.
+func SignedURLExpiry() int {
+	return envIntOrDefault("SIGNED_URL_EXPIRY", 3600)
+}
+
+// ResumableUploadDir is where the parts of resumable uploads are collected
+// until they are complete.
+func ResumableUploadDir() string {
+	return envStringOrDefault("RESUMABLE_UPLOAD_DIR", "tmp/uploads")
+}
+
+// ResumableUploadExpiry is how many hours an unfinished resumable upload is
+// kept after its last part arrived.
+func ResumableUploadExpiry() int {
+	return envIntOrDefault("RESUMABLE_UPLOAD_EXPIRY", 24)
+}
+
+// ReconcileInterval is how many minutes apart the background reconciler
+// runs; 0 turns it off.
+func ReconcileInterval() int {
+	return envIntOrDefault("RECONCILE_INTERVAL", 60)
+}
+
+// ReconcileApply lets the background reconciler repair what it finds
+// instead of only logging it.
+func ReconcileApply() bool {
+	return envStringOrDefault("RECONCILE_APPLY", "false") == "true"
+}
+
+// ReconcileGrace is how many minutes old an unreferenced file must be
+// before the reconciler treats it as orphaned.
+func ReconcileGrace() int {
+	return envIntOrDefault("RECONCILE_GRACE", 60)
+}
+
+// QuotaBytes is how many bytes of uploads users with role may keep, set by
+// QUOTA_<ROLE>_BYTES. Zero means no limit, the default for admins.
+func QuotaBytes(role string) int64 {
+	fallback := 1 << 30
+	if role == "admin" {
+		fallback = 0
+	}
+	return int64(envIntOrDefault("QUOTA_"+strings.ToUpper(role)+"_BYTES", fallback))
+}
+
+// QuotaImages is how many images users with role may keep, set by
+// QUOTA_<ROLE>_IMAGES. Zero means no limit, the default for admins.
+func QuotaImages(role string) int64 {
+	fallback := 1000
+	if role == "admin" {
+		fallback = 0
+	}
+	return int64(envIntOrDefault("QUOTA_"+strings.ToUpper(role)+"_IMAGES", fallback))
+}
+
+func envIntOrDefault(key string, fallback int) int {
+	loadEnv()
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		log.Fatalf("Error converting %s to integer", key)
+	}
+	return value
+}
+
+// DetectorBackend selects the detector implementation: "yolov5" runs
+// detect.py per image, "sidecar" keeps a python worker with the model loaded,
+// "onnx" runs an exported model in process and "fake" returns deterministic
+// results without python.
+func DetectorBackend() string {
+	return envStringOrDefault("DETECTOR", "yolov5")
+}
+
+func YoloV5PythonPath() string {
+	return envStringOrDefault("YOLOV5_PYTHON", "imagedetection/yolov5/yolov5_venv/bin/python")
+}
+
+func YoloV5ScriptPath() string {
+	return envStringOrDefault("YOLOV5_DETECT_SCRIPT", "imagedetection/yolov5/detect.py")
+}
+
+func YoloV5ServerScriptPath() string {
+	return envStringOrDefault("YOLOV5_SERVER_SCRIPT", "imagedetection/inference_server.py")
+}
+
+// SidecarMaxPending bounds how many detection requests may be in flight to
+// the inference sidecar at once.
+func SidecarMaxPending() int {
+	return envIntOrDefault("SIDECAR_MAX_PENDING", 4)
+}
+
+// SidecarMaxModels bounds how many models the inference sidecar keeps
+// loaded; the least recently used one is dropped to load another.
+func SidecarMaxModels() int {
+	return envIntOrDefault("SIDECAR_MAX_MODELS", 4)
+}
+
+func YoloV5WeightsPath() string {
+	return envStringOrDefault("YOLOV5_WEIGHTS", "imagedetection/yolov5s-cat-dog.pt")
+}
+
+func YoloV5OutputDir() string {
+	return envStringOrDefault("YOLOV5_OUTPUT_DIR", "imagedetection/yolov5/runs/detect")
+}
+
+func ONNXModelPath() string {
+	return envStringOrDefault("ONNX_MODEL", "imagedetection/yolov5s-cat-dog.onnx")
+}
+
+// ONNXRuntimePath is the onnxruntime shared library to load. When empty the
+// library is looked up by its default name.
+func ONNXRuntimePath() string {
+	return envStringOrDefault("ONNXRUNTIME_LIB", "")
+}
+
+// ONNXClassNames overrides the class names stored in the ONNX model, given
+// as a comma separated list in class index order.
+func ONNXClassNames() []string {
+	names := envStringOrDefault("ONNX_CLASSES", "")
+	if names == "" {
+		return nil
+	}
+	return strings.Split(names, ",")
+}
+
+func envStringOrDefault(key string, fallback string) string {
+	loadEnv()
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
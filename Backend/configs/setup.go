@@ -27,6 +27,6 @@ var DB *mongo.Client = Connect_to_mongodb()
 
 // getting database collections
 func GetCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	collection := client.Database("ImageDetection").Collection(collectionName)
+	collection := client.Database(MongoDatabase()).Collection(collectionName)
 	return collection
 }
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/render"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// boxRequest is a box to add, or the parts of a box to change. Coordinates
+// are in pixels of the original image.
+type boxRequest struct {
+	Name *string `json:"name"`
+	XMin *int    `json:"x_min"`
+	YMin *int    `json:"y_min"`
+	XMax *int    `json:"x_max"`
+	YMax *int    `json:"y_max"`
+}
+
+// apply returns name and box with the fields the request sets replaced.
+func (r boxRequest) apply(name string, box models.Coordinate) (string, models.Coordinate) {
+	if r.Name != nil {
+		name = strings.TrimSpace(*r.Name)
+	}
+	for _, field := range []struct {
+		value *int
+		dst   *int
+	}{
+		{r.XMin, &box.X_min}, {r.YMin, &box.Y_min}, {r.XMax, &box.X_max}, {r.YMax, &box.Y_max},
+	} {
+		if field.value != nil {
+			*field.dst = *field.value
+		}
+	}
+	return name, box
+}
+
+func (r boxRequest) complete() bool {
+	return r.Name != nil && r.XMin != nil && r.YMin != nil && r.XMax != nil && r.YMax != nil
+}
+
+// checkBox returns why a box cannot be placed in an image of the given
+// bounds, or "" when it can.
+func checkBox(name string, box models.Coordinate, bounds image.Rectangle) string {
+	switch {
+	case name == "":
+		return "Box name is required"
+	case box.X_min >= box.X_max || box.Y_min >= box.Y_max:
+		return "Box must have x_min < x_max and y_min < y_max"
+	case box.X_min < 0 || box.Y_min < 0 || box.X_max > bounds.Dx() || box.Y_max > bounds.Dy():
+		return "Box must lie within the image"
+	}
+	return ""
+}
+
+// cloneResult copies result deeply enough to edit the copy.
+func cloneResult(result []models.DetectedObject) []models.DetectedObject {
+	cloned := make([]models.DetectedObject, 0, len(result))
+	for _, object := range result {
+		cloned = append(cloned, models.DetectedObject{Name: object.Name, Coordinates: slices.Clone(object.Coordinates)})
+	}
+	return cloned
+}
+
+// findBox returns the class name and box with the given ID.
+func findBox(result []models.DetectedObject, id primitive.ObjectID) (string, models.Coordinate, bool) {
+	for _, object := range result {
+		for _, box := range object.Coordinates {
+			if box.Bounding_id == id {
+				return object.Name, box, true
+			}
+		}
+	}
+	return "", models.Coordinate{}, false
+}
+
+// withoutBox removes the box with the given ID, dropping its class when it
+// was the last box of it.
+func withoutBox(result []models.DetectedObject, id primitive.ObjectID) []models.DetectedObject {
+	kept := make([]models.DetectedObject, 0, len(result))
+	for _, object := range result {
+		object.Coordinates = slices.DeleteFunc(object.Coordinates, func(box models.Coordinate) bool {
+			return box.Bounding_id == id
+		})
+		if len(object.Coordinates) > 0 {
+			kept = append(kept, object)
+		}
+	}
+	return kept
+}
+
+// withBox adds box to the class name, keeping classes sorted by name as
+// detection reports them.
+func withBox(result []models.DetectedObject, name string, box models.Coordinate) []models.DetectedObject {
+	for i := range result {
+		if result[i].Name == name {
+			result[i].Coordinates = append(result[i].Coordinates, box)
+			return result
+		}
+	}
+	result = append(result, models.DetectedObject{Name: name, Coordinates: []models.Coordinate{box}})
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// boxEdit changes a copy of an image's result. It answers for the caller and
+// returns false when the edit cannot be made.
+type boxEdit func(c *gin.Context, result []models.DetectedObject, bounds image.Rectangle) ([]models.DetectedObject, bool)
+
+// editBoxes applies edit to the result of the image in the path, draws the
+// annotated image again from the edited boxes and records both as a run of
+// origin human, which becomes the image's current run. Earlier runs keep
+// their own result and files, so the edit can be compared with them and
+// survives setting another run current or detecting again. The update only
+// goes through if the result is still the one edited, so concurrent edits
+// and detections cannot overwrite each other.
+func editBoxes(c *gin.Context, status int, message string, edit boxEdit) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	image, ok := findOwnedImage(c, ctx, "edit")
+	if !ok {
+		return
+	}
+	if image.Status == models.StatusPending || image.Status == models.StatusProcessing {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Detection is in progress"})
+		return
+	}
+
+	original, err := loadImage(ctx, image.ImagePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error reading image file"})
+		return
+	}
+
+	result, ok := edit(c, cloneResult(image.Result), original.Bounds())
+	if !ok {
+		return
+	}
+
+	detectedImagePath, thumbnails, err := storeRendered(ctx, original, result)
+	if err != nil {
+		log.Printf("failed to store annotated image of %s: %v", image.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving annotated image"})
+		return
+	}
+
+	now := time.Now()
+	run := models.DetectionRun{
+		ID:                    primitive.NewObjectID(),
+		Image:                 image.ID,
+		User:                  image.User,
+		ModelID:               image.ModelID,
+		Model:                 image.Model,
+		ModelVersion:          image.ModelVersion,
+		Params:                image.Params,
+		Status:                models.StatusSuccess,
+		Result:                result,
+		DetectedImagePath:     detectedImagePath,
+		DetectedThumbnailPath: thumbnails.Thumbnail,
+		DetectedPreviewPath:   thumbnails.Preview,
+		Origin:                models.OriginHuman,
+		EditedFrom:            image.CurrentRun,
+		StartedAt:             now,
+		FinishedAt:            now,
+	}
+	if _, err := detectionRunCollection.InsertOne(ctx, run); err != nil {
+		removeCopiedImage(ctx, detectedImagePath)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error recording edit"})
+		return
+	}
+
+	update, err := imageCollection.UpdateOne(ctx,
+		bson.M{"_id": image.ID, "result": image.Result, "detectedImagePath": image.DetectedImagePath},
+		bson.M{"$set": bson.M{
+			"result":                result,
+			"detectedImagePath":     detectedImagePath,
+			"detectedThumbnailPath": thumbnails.Thumbnail,
+			"detectedPreviewPath":   thumbnails.Preview,
+			"currentRun":            run.ID,
+			"editedAt":              now,
+		}})
+	if err != nil || update.MatchedCount == 0 {
+		if _, err := detectionRunCollection.DeleteOne(ctx, bson.M{"_id": run.ID}); err != nil {
+			log.Printf("failed to remove edit run %s: %v", run.ID.Hex(), err)
+		}
+		removeCopiedImage(ctx, detectedImagePath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating image"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Image changed while it was being edited"})
+		return
+	}
+
+	image.Result = result
+	image.DetectedImagePath = detectedImagePath
+	image.DetectedThumbnailPath = thumbnails.Thumbnail
+	image.DetectedPreviewPath = thumbnails.Preview
+	image.CurrentRun = run.ID
+	image.EditedAt = now
+	events.PublishImageStatus(image)
+	withURLs(&image)
+	c.JSON(status, gin.H{"success": true, "message": message, "data": image})
+}
+
+func loadImage(ctx context.Context, key string) (image.Image, error) {
+	object, _, err := storage.Default.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// storeRendered draws result onto original and stores it with its
+// thumbnails as a new annotated image.
+func storeRendered(ctx context.Context, original image.Image, result []models.DetectedObject) (string, thumbnail.Paths, error) {
+	annotated := render.Draw(original, result, render.Default)
+	data, err := render.Encode(annotated, render.FormatJPEG)
+	if err != nil {
+		return "", thumbnail.Paths{}, err
+	}
+
+	key := generateImagePath("detected.jpg")
+	if err := storage.Default.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		return "", thumbnail.Paths{}, err
+	}
+	thumbnails, err := thumbnail.Generate(ctx, storage.Default, key, annotated)
+	if err != nil {
+		log.Printf("failed to generate thumbnails of %s: %v", key, err)
+	}
+	return key, thumbnails, nil
+}
+
+func parseBoxID(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("boxId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid box ID"})
+		return id, false
+	}
+	return id, true
+}
+
+// AddBox adds a box drawn by the user to an image's result.
+func AddBox(c *gin.Context) {
+	var request boxRequest
+	if err := c.ShouldBindJSON(&request); err != nil || !request.complete() {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "name, x_min, y_min, x_max and y_max are required"})
+		return
+	}
+
+	editBoxes(c, http.StatusCreated, "Box added successfully", func(c *gin.Context, result []models.DetectedObject, bounds image.Rectangle) ([]models.DetectedObject, bool) {
+		name, box := request.apply("", models.Coordinate{Bounding_id: primitive.NewObjectID(), Origin: models.OriginHuman})
+		if problem := checkBox(name, box, bounds); problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": problem})
+			return nil, false
+		}
+		return withBox(result, name, box), true
+	})
+}
+
+// UpdateBox moves, resizes or relabels a box. The box becomes the user's:
+// its origin turns human and the model's confidence no longer applies.
+func UpdateBox(c *gin.Context) {
+	boxID, ok := parseBoxID(c)
+	if !ok {
+		return
+	}
+	var request boxRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
+		return
+	}
+
+	editBoxes(c, http.StatusOK, "Box updated successfully", func(c *gin.Context, result []models.DetectedObject, bounds image.Rectangle) ([]models.DetectedObject, bool) {
+		name, box, found := findBox(result, boxID)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Box not found"})
+			return nil, false
+		}
+		name, box = request.apply(name, box)
+		box.Origin, box.Confidence = models.OriginHuman, 0
+		if problem := checkBox(name, box, bounds); problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": problem})
+			return nil, false
+		}
+		return withBox(withoutBox(result, boxID), name, box), true
+	})
+}
+
+// DeleteBox removes a box from an image's result.
+func DeleteBox(c *gin.Context) {
+	boxID, ok := parseBoxID(c)
+	if !ok {
+		return
+	}
+
+	editBoxes(c, http.StatusOK, "Box deleted successfully", func(c *gin.Context, result []models.DetectedObject, bounds image.Rectangle) ([]models.DetectedObject, bool) {
+		if _, _, found := findBox(result, boxID); !found {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Box not found"})
+			return nil, false
+		}
+		return withoutBox(result, boxID), true
+	})
+}
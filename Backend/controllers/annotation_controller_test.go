@@ -0,0 +1,91 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func findTestImage(t *testing.T, image models.Image) models.Image {
+	t.Helper()
+	var found models.Image
+	if err := imageCollection.FindOne(context.Background(), bson.M{"_id": image.ID}).Decode(&found); err != nil {
+		t.Fatalf("find image: %v", err)
+	}
+	return found
+}
+
+func hasBox(result []models.DetectedObject, name string) bool {
+	for _, object := range result {
+		if object.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAddBoxRecordsRun(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+	image := createTestImage(t, router)
+
+	body := `{"name":"drawn","x_min":1,"y_min":1,"x_max":10,"y_max":10}`
+	req := httptest.NewRequest(http.MethodPost, "/images/"+image.ID.Hex()+"/boxes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := serve(router, req)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("add box: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	edited := findTestImage(t, image)
+	if edited.CurrentRun == image.CurrentRun {
+		t.Fatal("edit did not become the current run")
+	}
+	var run models.DetectionRun
+	if err := detectionRunCollection.FindOne(context.Background(), bson.M{"_id": edited.CurrentRun}).Decode(&run); err != nil {
+		t.Fatalf("find edit run: %v", err)
+	}
+	if run.Origin != models.OriginHuman || run.EditedFrom != image.CurrentRun {
+		t.Errorf("edit run has origin %q and was edited from %s, want human from %s", run.Origin, run.EditedFrom.Hex(), image.CurrentRun.Hex())
+	}
+	if !hasBox(run.Result, "drawn") || run.DetectedImagePath != edited.DetectedImagePath {
+		t.Errorf("edit run does not hold the edit: %+v", run)
+	}
+	if !stored(image.DetectedImagePath) {
+		t.Error("annotated image of the detection run deleted by the edit")
+	}
+
+	// Detecting again replaces the result, but the edit can be brought back.
+	serve(router, httptest.NewRequest(http.MethodPost, "/images/"+image.ID.Hex()+"/detect", nil))
+	if redetected := waitForDetection(t, image.ID); hasBox(redetected.Result, "drawn") {
+		t.Fatal("redetection kept the edit as its result")
+	}
+	recorder = serve(router, httptest.NewRequest(http.MethodPut, "/images/"+image.ID.Hex()+"/runs/"+run.ID.Hex()+"/current", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("set current run: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Data models.Image `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if !hasBox(response.Data.Result, "drawn") || response.Data.DetectedImagePath != run.DetectedImagePath {
+		t.Errorf("edit not restored: %+v", response.Data)
+	}
+
+	// Deleting the image deletes the edit's files with its runs.
+	recorder = serve(router, httptest.NewRequest(http.MethodDelete, "/images/"+image.ID.Hex(), nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	if stored(run.DetectedImagePath) || stored(image.DetectedImagePath) {
+		t.Error("annotated images not deleted with the image")
+	}
+}
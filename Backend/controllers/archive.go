@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// zipStream writes a zip archive straight to the response, so an archive
+// never has to fit in memory. Once the first entry is written the status is
+// sent; later failures can only cut the archive short.
+type zipStream struct {
+	ctx    context.Context
+	writer *zip.Writer
+}
+
+// newZipStream starts a zip download named filename.
+func newZipStream(c *gin.Context, filename string) *zipStream {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", attachmentDisposition(filename))
+	return &zipStream{ctx: c.Request.Context(), writer: zip.NewWriter(c.Writer)}
+}
+
+// addStored copies the file stored under key into the archive as name.
+// Images are already compressed, so they are stored rather than deflated.
+func (z *zipStream) addStored(name string, key string) error {
+	file, info, err := storage.Default.Get(z.ctx, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := z.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: info.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// create starts a compressed entry called name.
+func (z *zipStream) create(name string) (io.Writer, error) {
+	return z.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+// addJSON writes value into the archive as an indented JSON file.
+func (z *zipStream) addJSON(name string, value any) error {
+	writer, err := z.create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// addBytes writes data into the archive as name.
+func (z *zipStream) addBytes(name string, data []byte) error {
+	writer, err := z.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+func (z *zipStream) Close() error {
+	return z.writer.Close()
+}
+
+// entryNames hands out the names images go by inside an archive. Names are
+// made safe to extract and numbered when repeated, ignoring case so they
+// also stay apart on case-insensitive file systems.
+type entryNames map[string]bool
+
+// unique returns a name for an image called name that no earlier image in
+// the archive got.
+func (n entryNames) unique(name string) string {
+	name = safeEntryName(name)
+	candidate := name
+	for i := 2; n[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+	n[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// safeEntryName keeps a user-chosen name from escaping the directory the
+// archive is extracted to.
+func safeEntryName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
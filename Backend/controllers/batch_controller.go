@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/jobs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var batchCollection *mongo.Collection = configs.GetCollection(configs.DB, "batches")
+
+// batchUpload collects the outcome of every file in a batch upload.
+type batchUpload struct {
+	ctx          context.Context
+	user         models.User
+	batch        *models.Batch
+	model        *models.DetectionModel
+	reuse        bool
+	keepLocation bool
+	maxFiles     int
+}
+
+// CreateBatch accepts many images in one multipart request, sent as
+// repeated "images" files, zip archives of images, or both. Every accepted
+// file becomes its own image, tied to a batch whose progress can be
+// followed; rejected files are reported without failing the others.
+func CreateBatch(c *gin.Context) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	if err := parseUploadForm(c, configs.BatchMaxBytes()); err != nil {
+		c.JSON(err.Status, gin.H{"success": false, "message": err.Message, "code": err.Code, "details": err.Details})
+		return
+	}
+	var files []*multipart.FileHeader
+	files = append(files, c.Request.MultipartForm.File["images"]...)
+	files = append(files, c.Request.MultipartForm.File["archive"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "No images provided"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	detectionModel, err := resolveDetectionModel(ctx, c.PostForm("model"))
+	if errors.Is(err, errModelNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown model"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to find model"})
+		return
+	}
+
+	params, err := parseInferenceParams(c, defaultInferenceParams(detectionModel), detectionModel)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	name := c.PostForm("name")
+	if name == "" {
+		name = "untitled"
+	}
+
+	var modelInfo models.Image
+	applyDetectionModel(&modelInfo, detectionModel)
+	batch := models.Batch{
+		ID:           primitive.NewObjectID(),
+		User:         userData.ID,
+		Name:         name,
+		ModelID:      modelInfo.ModelID,
+		Model:        modelInfo.Model,
+		ModelVersion: modelInfo.ModelVersion,
+		Params:       params,
+		Files:        []models.BatchFile{},
+		CreatedAt:    time.Now(),
+	}
+
+	// The batch is stored before any of its images, so an image never names
+	// a batch that does not exist; its files and counts are filled in once
+	// every file has been handled.
+	if _, err := batchCollection.InsertOne(ctx, batch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to insert batch into database"})
+		return
+	}
+
+	upload := batchUpload{
+		ctx:          c.Request.Context(),
+		user:         userData,
+		batch:        &batch,
+		model:        detectionModel,
+		reuse:        c.PostForm("reuse") == "true",
+		keepLocation: c.PostForm("keepLocation") == "true",
+		maxFiles:     configs.BatchMaxFiles(),
+	}
+	for _, file := range files {
+		if strings.EqualFold(filepath.Ext(file.Filename), ".zip") {
+			upload.addArchive(file)
+		} else {
+			upload.addFile(file)
+		}
+	}
+
+	updateCtx, updateCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer updateCancel()
+
+	if batch.Accepted == 0 {
+		if _, err := batchCollection.DeleteOne(updateCtx, bson.M{"_id": batch.ID}); err != nil {
+			log.Printf("failed to delete empty batch %s: %v", batch.ID.Hex(), err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "No images were accepted", "data": batch})
+		return
+	}
+
+	_, err = batchCollection.UpdateOne(updateCtx, bson.M{"_id": batch.ID}, bson.M{"$set": bson.M{
+		"files":    batch.Files,
+		"accepted": batch.Accepted,
+		"rejected": batch.Rejected,
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to record batch files"})
+		return
+	}
+
+	progress, err := jobs.BatchProgress(updateCtx, batch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting batch progress"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Batch accepted for detection", "data": batch, "progress": progress})
+}
+
+func (u *batchUpload) addFile(header *multipart.FileHeader) {
+	if !u.admit(header.Filename) {
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		u.reject(header.Filename, &uploadError{http.StatusBadRequest, uploadReadFailed, "Failed to read file", nil})
+		return
+	}
+	defer file.Close()
+
+	u.create(header.Filename, header.Filename, file)
+}
+
+// addArchive adds every image in a zip archive. Directories and the hidden
+// files some archivers add are skipped without being reported.
+func (u *batchUpload) addArchive(header *multipart.FileHeader) {
+	file, err := header.Open()
+	if err != nil {
+		u.reject(header.Filename, &uploadError{http.StatusBadRequest, uploadReadFailed, "Failed to read file", nil})
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		u.reject(header.Filename, &uploadError{http.StatusBadRequest, uploadInvalidArchive, "Invalid zip archive", nil})
+		return
+	}
+
+	for _, entry := range archive.File {
+		base := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		filename := header.Filename + "/" + entry.Name
+		if !u.admit(filename) {
+			continue
+		}
+		if maxBytes := configs.UploadMaxBytes(); entry.UncompressedSize64 > uint64(maxBytes) {
+			u.reject(filename, &uploadError{http.StatusRequestEntityTooLarge, uploadTooLarge, "Image file is too large", gin.H{"maxBytes": maxBytes}})
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			u.reject(filename, &uploadError{http.StatusBadRequest, uploadReadFailed, "Failed to read file from archive", nil})
+			continue
+		}
+		u.create(filename, base, rc)
+		rc.Close()
+	}
+}
+
+// admit checks that another file fits in the batch, recording the rejection
+// when it does not. Whether the file is an image is checked on upload.
+func (u *batchUpload) admit(filename string) bool {
+	if len(u.batch.Files) >= u.maxFiles {
+		u.reject(filename, &uploadError{http.StatusBadRequest, uploadTooManyFiles, fmt.Sprintf("Batch is limited to %d files", u.maxFiles),
+			gin.H{"maxFiles": u.maxFiles}})
+		return false
+	}
+	return true
+}
+
+func (u *batchUpload) create(filename string, base string, src io.Reader) {
+	ctx, cancel := context.WithTimeout(u.ctx, 10*time.Second)
+	defer cancel()
+
+	created, err := createImage(ctx, u.user, imageUpload{
+		Name:         strings.TrimSuffix(base, filepath.Ext(base)),
+		Filename:     base,
+		Batch:        u.batch.ID,
+		Model:        u.model,
+		Params:       u.batch.Params,
+		Reuse:        u.reuse,
+		KeepLocation: u.keepLocation,
+	}, src)
+	if err != nil {
+		u.reject(filename, err)
+		return
+	}
+
+	file := models.BatchFile{Filename: filename, Accepted: true, Image: created.Image.ID, Reused: created.Reused}
+	if created.Duplicate != nil {
+		file.DuplicateOf = created.Duplicate.ID
+	}
+	u.batch.Files = append(u.batch.Files, file)
+	u.batch.Accepted++
+}
+
+func (u *batchUpload) reject(filename string, err error) {
+	file := models.BatchFile{Filename: filename, Error: err.Error()}
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		file.Code = uploadErr.Code
+		file.Details = uploadErr.Details
+	}
+	u.batch.Files = append(u.batch.Files, file)
+	u.batch.Rejected++
+}
+
+func GetAllBatches(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetProjection(bson.M{"files": 0})
+	cursor, err := batchCollection.Find(ctx, bson.M{"user": userData.ID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	batches := []models.Batch{}
+	if err := cursor.All(ctx, &batches); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "counts": len(batches), "data": batches})
+}
+
+// GetBatchByID returns a batch with its per-file results and the current
+// detection progress of its images.
+func GetBatchByID(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	batchID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid batch ID"})
+		return
+	}
+
+	var batch models.Batch
+	err = batchCollection.FindOne(ctx, bson.M{"_id": batchID}).Decode(&batch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Batch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding batch"})
+		return
+	}
+
+	if userData.ID != batch.User {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to access this batch"})
+		return
+	}
+
+	progress, err := jobs.BatchProgress(ctx, batch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting batch progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": batch, "progress": progress})
+}
@@ -0,0 +1,85 @@
+//go:build integration
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func batchRequest(t *testing.T, files map[string][]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, err := form.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/images/batches", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestCreateBatch(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+	t.Cleanup(func() { batchCollection.DeleteMany(context.Background(), bson.M{"user": user.ID}) })
+
+	recorder := serve(router, batchRequest(t, map[string][]byte{
+		"a.png":   testPNG(t),
+		"b.png":   testPNG(t),
+		"bad.txt": []byte("not an image"),
+	}))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Data models.Batch `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	var batch models.Batch
+	if err := batchCollection.FindOne(context.Background(), bson.M{"_id": response.Data.ID}).Decode(&batch); err != nil {
+		t.Fatalf("find batch: %v", err)
+	}
+	if batch.Accepted != 2 || batch.Rejected != 1 || len(batch.Files) != 3 {
+		t.Errorf("batch stored %d accepted, %d rejected, %d files", batch.Accepted, batch.Rejected, len(batch.Files))
+	}
+	for _, file := range batch.Files {
+		if !file.Accepted {
+			continue
+		}
+		image := waitForDetection(t, file.Image)
+		if image.Batch != batch.ID {
+			t.Errorf("image %s in batch %s", image.ID.Hex(), image.Batch.Hex())
+		}
+	}
+}
+
+func TestCreateBatchWithoutImages(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+
+	recorder := serve(router, batchRequest(t, map[string][]byte{"bad.txt": []byte("not an image")}))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body)
+	}
+	if count, _ := batchCollection.CountDocuments(context.Background(), bson.M{"user": user.ID}); count != 0 {
+		t.Errorf("%d empty batches left behind", count)
+	}
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var blobCollection *mongo.Collection = configs.GetCollection(configs.DB, "blobs")
+
+// How often an upload checks whether a blob it waits for is deleted.
+const blobDeletionPoll = 50 * time.Millisecond
+
+// blobKey is where the file with the given digest is stored.
+func blobKey(digest string, ext string) string {
+	return fmt.Sprintf("public/images/%s%s", digest, ext)
+}
+
+// storeBlob stores a validated upload under its digest unless a file with
+// the same bytes is already stored, taking a reference on the blob either
+// way. Every image holding a reference must release it with releaseBlob.
+func storeBlob(ctx context.Context, upload *spooledUpload) (models.Blob, error) {
+	key := blobKey(upload.digest, upload.ext())
+
+	// Taking the reference first keeps a concurrent release from deleting
+	// the file between the check below and the insert.
+	blob, err := takeBlob(ctx, upload.digest, key, upload.size)
+	if err != nil {
+		return models.Blob{}, err
+	}
+
+	if _, err := storage.Default.Stat(ctx, blob.Key); err == nil {
+		return blob, nil
+	}
+
+	if _, err := upload.file.Seek(0, io.SeekStart); err != nil {
+		releaseBlob(ctx, blob.Digest, blob.Key)
+		return models.Blob{}, err
+	}
+	if err := storage.Default.Put(ctx, blob.Key, upload.file, upload.size, blob.ContentType); err != nil {
+		releaseBlob(ctx, blob.Digest, blob.Key)
+		return models.Blob{}, err
+	}
+	return blob, nil
+}
+
+// takeBlob adds a reference to the blob with digest, creating it when
+// there is none. A blob being deleted cannot be taken: the upsert then
+// collides with it and is retried until its deletion has finished.
+func takeBlob(ctx context.Context, digest string, key string, size int64) (models.Blob, error) {
+	for {
+		var blob models.Blob
+		err := blobCollection.FindOneAndUpdate(ctx, bson.M{"_id": digest, "deleting": bson.M{"$ne": true}}, bson.M{
+			"$inc": bson.M{"refCount": 1},
+			"$setOnInsert": bson.M{
+				"key":         key,
+				"size":        size,
+				"contentType": storage.ContentType(key),
+				"createdAt":   time.Now(),
+			},
+		}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&blob)
+		if !mongo.IsDuplicateKeyError(err) {
+			return blob, err
+		}
+
+		select {
+		case <-time.After(blobDeletionPoll):
+		case <-ctx.Done():
+			return models.Blob{}, fmt.Errorf("blob %s is still being deleted: %w", digest, ctx.Err())
+		}
+	}
+}
+
+// blobThumbnails returns the thumbnail and preview of an uploaded file,
+// generating them unless an earlier upload of the same bytes already did.
+func blobThumbnails(ctx context.Context, blob models.Blob, upload *spooledUpload) (thumbnail.Paths, error) {
+	paths := thumbnail.KeysFor(blob.Key)
+	_, thumbnailErr := storage.Default.Stat(ctx, paths.Thumbnail)
+	_, previewErr := storage.Default.Stat(ctx, paths.Preview)
+	if thumbnailErr == nil && previewErr == nil {
+		return paths, nil
+	}
+
+	if _, err := upload.file.Seek(0, io.SeekStart); err != nil {
+		return thumbnail.Paths{}, err
+	}
+	return thumbnail.GenerateFrom(ctx, storage.Default, blob.Key, upload.file)
+}
+
+// releaseImageFile drops image's reference to its uploaded file, deleting
+// the file once no image uses it. Images stored before files were
+// deduplicated own their file outright.
+func releaseImageFile(ctx context.Context, image models.Image) error {
+	if image.Digest == "" {
+		return storage.Default.Delete(ctx, image.ImagePath)
+	}
+	return releaseBlob(ctx, image.Digest, image.ImagePath)
+}
+
+func releaseBlob(ctx context.Context, digest string, key string) error {
+	var blob models.Blob
+	err := blobCollection.FindOneAndUpdate(ctx, bson.M{"_id": digest}, bson.M{"$inc": bson.M{"refCount": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&blob)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return storage.Default.Delete(ctx, key)
+	}
+	if err != nil {
+		return err
+	}
+	if blob.RefCount > 0 {
+		return nil
+	}
+	return deleteBlob(ctx, digest)
+}
+
+// deleteBlob deletes a blob no image references and its files. The blob
+// is marked as being deleted first, so an upload of the same bytes cannot
+// take it and find the file still there just before it is removed; the
+// mark is only lifted with the blob itself. A deletion cut short leaves
+// the mark for the reconciler to finish.
+func deleteBlob(ctx context.Context, digest string) error {
+	var blob models.Blob
+	err := blobCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": digest, "refCount": bson.M{"$lte": 0}, "deleting": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"deleting": true, "deletingAt": time.Now()}},
+	).Decode(&blob)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Taken again by an upload, or already being deleted.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := thumbnail.Remove(ctx, storage.Default, blob.Key); err != nil {
+		return err
+	}
+	if err := storage.Default.Delete(ctx, blob.Key); err != nil {
+		return err
+	}
+	_, err = blobCollection.DeleteOne(ctx, bson.M{"_id": digest, "deleting": true})
+	return err
+}
+
+// findDuplicates returns the user's earlier images with the given digest,
+// newest first.
+func findDuplicates(ctx context.Context, user models.User, digest string) ([]models.Image, error) {
+	cursor, err := imageCollection.Find(ctx, bson.M{"user": user.ID, "digest": digest},
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var images []models.Image
+	err = cursor.All(ctx, &images)
+	return images, err
+}
+
+// reusableResult picks the newest duplicate that was detected successfully
+// with the same model and parameters as image.
+func reusableResult(image models.Image, duplicates []models.Image) *models.Image {
+	for i, duplicate := range duplicates {
+		if duplicate.Status == models.StatusSuccess &&
+			duplicate.ModelID == image.ModelID &&
+			duplicate.Model == image.Model &&
+			duplicate.ModelVersion == image.ModelVersion &&
+			sameParams(duplicate.Params, image.Params) {
+			return &duplicates[i]
+		}
+	}
+	return nil
+}
+
+func sameParams(a models.InferenceParams, b models.InferenceParams) bool {
+	return a.ConfThreshold == b.ConfThreshold &&
+		a.IoUThreshold == b.IoUThreshold &&
+		a.ImageSize == b.ImageSize &&
+		a.MaxDetections == b.MaxDetections &&
+		slices.Equal(a.Classes, b.Classes)
+}
+
+// reuseResult gives image a copy of source's current result, recorded as a
+// run of its own, instead of running detection again.
+func reuseResult(ctx context.Context, image *models.Image, source models.Image) error {
+	detectedImagePath := bson.TypeNull.String()
+	var thumbnails thumbnail.Paths
+	if source.DetectedImagePath != "" && source.DetectedImagePath != bson.TypeNull.String() {
+		object, info, err := storage.Default.Get(ctx, source.DetectedImagePath)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+
+		detectedImagePath = generateImagePath(source.DetectedImagePath)
+		err = storage.Default.Put(ctx, detectedImagePath, bytes.NewReader(data), info.Size, info.ContentType)
+		if err != nil {
+			return err
+		}
+		thumbnails, err = thumbnail.GenerateFrom(ctx, storage.Default, detectedImagePath, bytes.NewReader(data))
+		if err != nil {
+			log.Printf("failed to generate thumbnails of %s: %v", detectedImagePath, err)
+		}
+	}
+
+	result := make([]models.DetectedObject, 0, len(source.Result))
+	for _, object := range source.Result {
+		coordinates := slices.Clone(object.Coordinates)
+		for i := range coordinates {
+			coordinates[i].Bounding_id = primitive.NewObjectID()
+		}
+		result = append(result, models.DetectedObject{Name: object.Name, Coordinates: coordinates})
+	}
+
+	now := time.Now()
+	run := models.DetectionRun{
+		ID:                    primitive.NewObjectID(),
+		Image:                 image.ID,
+		User:                  image.User,
+		ModelID:               image.ModelID,
+		Model:                 image.Model,
+		ModelVersion:          image.ModelVersion,
+		Params:                image.Params,
+		Status:                models.StatusSuccess,
+		Result:                result,
+		DetectedImagePath:     detectedImagePath,
+		DetectedThumbnailPath: thumbnails.Thumbnail,
+		DetectedPreviewPath:   thumbnails.Preview,
+		ReusedFrom:            source.CurrentRun,
+		StartedAt:             now,
+		FinishedAt:            now,
+	}
+	if _, err := detectionRunCollection.InsertOne(ctx, run); err != nil {
+		removeCopiedImage(ctx, detectedImagePath)
+		return err
+	}
+
+	image.Status = models.StatusSuccess
+	image.Result = result
+	image.DetectedImagePath = detectedImagePath
+	image.DetectedThumbnailPath = thumbnails.Thumbnail
+	image.DetectedPreviewPath = thumbnails.Preview
+	image.DetectedAt = now
+	image.CurrentRun = run.ID
+	return nil
+}
+
+func removeCopiedImage(ctx context.Context, path string) {
+	if path == bson.TypeNull.String() {
+		return
+	}
+	if err := storage.Default.Delete(ctx, path); err != nil {
+		log.Printf("failed to remove copied detected image %s: %v", path, err)
+	}
+	if err := thumbnail.Remove(ctx, storage.Default, path); err != nil {
+		log.Printf("failed to remove thumbnails of %s: %v", path, err)
+	}
+}
@@ -0,0 +1,77 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTakeBlobWaitsForDeletion(t *testing.T) {
+	ctx := context.Background()
+	digest := primitive.NewObjectID().Hex()
+	key := blobKey(digest, ".png")
+	t.Cleanup(func() { blobCollection.DeleteOne(ctx, bson.M{"_id": digest}) })
+
+	_, err := blobCollection.InsertOne(ctx, models.Blob{Digest: digest, Key: key, Deleting: true, DeletingAt: time.Now()})
+	if err != nil {
+		t.Fatalf("insert blob: %v", err)
+	}
+
+	taken := make(chan models.Blob, 1)
+	go func() {
+		blob, err := takeBlob(ctx, digest, key, 1)
+		if err != nil {
+			t.Errorf("takeBlob: %v", err)
+		}
+		taken <- blob
+	}()
+
+	select {
+	case <-taken:
+		t.Fatal("took a blob being deleted")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := blobCollection.DeleteOne(ctx, bson.M{"_id": digest}); err != nil {
+		t.Fatalf("delete blob: %v", err)
+	}
+	select {
+	case blob := <-taken:
+		if blob.RefCount != 1 || blob.Deleting {
+			t.Errorf("took %+v, want a new blob with one reference", blob)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("takeBlob still waiting after the deletion finished")
+	}
+}
+
+func TestDeleteBlob(t *testing.T) {
+	ctx := context.Background()
+	digest := primitive.NewObjectID().Hex()
+	key := blobKey(digest, ".png")
+	t.Cleanup(func() { blobCollection.DeleteOne(ctx, bson.M{"_id": digest}) })
+
+	if err := testStore.Put(ctx, key, strings.NewReader("data"), 4, "image/png"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := takeBlob(ctx, digest, key, 4); err != nil {
+		t.Fatalf("takeBlob: %v", err)
+	}
+
+	if err := releaseBlob(ctx, digest, key); err != nil {
+		t.Fatalf("releaseBlob: %v", err)
+	}
+	if count, _ := blobCollection.CountDocuments(ctx, bson.M{"_id": digest}); count != 0 {
+		t.Error("blob not deleted")
+	}
+	if stored(key) {
+		t.Error("file not deleted")
+	}
+}
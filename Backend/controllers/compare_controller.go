@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/compare"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// parseCompareOptions reads the optional IoU thresholds used to pair boxes.
+func parseCompareOptions(matchIoU string, shiftIoU string) (compare.Options, error) {
+	var opts compare.Options
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *float64
+	}{{"iou", matchIoU, &opts.MatchIoU}, {"shiftIou", shiftIoU, &opts.ShiftIoU}} {
+		if field.value == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return opts, compareThresholdError(field.name)
+		}
+		if err := setCompareThreshold(field.dst, field.name, &value); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// setCompareThreshold stores value in dst when it is set. Zero is refused
+// rather than read as the default, so a threshold means the same whether
+// it came in a query or a JSON body.
+func setCompareThreshold(dst *float64, name string, value *float64) error {
+	if value == nil {
+		return nil
+	}
+	if *value <= 0 || *value > 1 {
+		return compareThresholdError(name)
+	}
+	*dst = *value
+	return nil
+}
+
+func compareThresholdError(name string) error {
+	return errors.New(name + " must be a number above 0 and at most 1")
+}
+
+// CompareRuns matches the boxes of two runs of the same image. The
+// candidate defaults to the image's current run.
+func CompareRuns(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	image, ok := findOwnedImage(c, ctx, "access")
+	if !ok {
+		return
+	}
+
+	compareOptions, err := parseCompareOptions(c.Query("iou"), c.Query("shiftIou"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	baselineID := c.Query("baseline")
+	if baselineID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Baseline run is required"})
+		return
+	}
+	candidateID := c.Query("candidate")
+	if candidateID == "" {
+		if image.CurrentRun.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Candidate run is required"})
+			return
+		}
+		candidateID = image.CurrentRun.Hex()
+	}
+
+	baseline, ok := findImageRun(c, ctx, image, baselineID)
+	if !ok {
+		return
+	}
+	candidate, ok := findImageRun(c, ctx, image, candidateID)
+	if !ok {
+		return
+	}
+
+	if baseline.Status != models.StatusSuccess || candidate.Status != models.StatusSuccess {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Only successful runs can be compared"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"baseline":  runSummary(baseline),
+		"candidate": runSummary(candidate),
+		"diff":      compare.Results(baseline.Result, candidate.Result, compareOptions),
+	}})
+}
+
+// CompareModels measures how well a candidate model agrees with a baseline
+// model over the user's images, using the latest successful run of each
+// model on every image both have detected. The baseline is treated as
+// ground truth for precision and recall.
+func CompareModels(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	var requestData struct {
+		Baseline  string   `json:"baseline" binding:"required"`
+		Candidate string   `json:"candidate" binding:"required"`
+		IDs       []string `json:"ids"`
+		IoU       *float64 `json:"iou"`
+		ShiftIoU  *float64 `json:"shiftIou"`
+	}
+	if err := c.ShouldBindJSON(&requestData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
+		return
+	}
+	var compareOptions compare.Options
+	for _, err := range []error{
+		setCompareThreshold(&compareOptions.MatchIoU, "iou", requestData.IoU),
+		setCompareThreshold(&compareOptions.ShiftIoU, "shiftIou", requestData.ShiftIoU),
+	} {
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+	}
+
+	filter := bson.M{"user": userData.ID, "status": models.StatusSuccess}
+	if len(requestData.IDs) > 0 {
+		var objectIDs []primitive.ObjectID
+		for _, id := range requestData.IDs {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid image ID"})
+				return
+			}
+			objectIDs = append(objectIDs, objID)
+		}
+		filter["image"] = bson.M{"$in": objectIDs}
+	}
+
+	var runs [2]map[primitive.ObjectID]models.DetectionRun
+	for i, ref := range []string{requestData.Baseline, requestData.Candidate} {
+		var err error
+		runs[i], err = latestRunsByModel(ctx, filter, ref)
+		if errors.Is(err, errModelNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown model " + ref})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding runs"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": modelAgreement(runs[0], runs[1], compareOptions)})
+}
+
+// imageComparison is one image's entry in a model comparison.
+type imageComparison struct {
+	Image     primitive.ObjectID `json:"image"`
+	Baseline  primitive.ObjectID `json:"baselineRun"`
+	Candidate primitive.ObjectID `json:"candidateRun"`
+	Summary   compare.Summary    `json:"summary"`
+}
+
+func modelAgreement(baselineRuns map[primitive.ObjectID]models.DetectionRun, candidateRuns map[primitive.ObjectID]models.DetectionRun, compareOptions compare.Options) gin.H {
+	agreement := compare.NewAgreement()
+	images := []imageComparison{}
+	for imageID, baseline := range baselineRuns {
+		candidate, ok := candidateRuns[imageID]
+		if !ok {
+			continue
+		}
+		diff := compare.Results(baseline.Result, candidate.Result, compareOptions)
+		agreement.Add(diff)
+		images = append(images, imageComparison{
+			Image:     imageID,
+			Baseline:  baseline.ID,
+			Candidate: candidate.ID,
+			Summary:   diff.Summary,
+		})
+	}
+
+	return gin.H{
+		"agreement": agreement.Finish(),
+		"images":    images,
+		// Images only one of the two models has detected are left out.
+		"skipped": len(baselineRuns) + len(candidateRuns) - 2*len(images),
+	}
+}
+
+// latestRunsByModel returns the newest run matching filter for each image
+// detected with the model ref names. ref follows the upload's model field;
+// "default" names the server's configured weights when no registered model
+// uses that name.
+func latestRunsByModel(ctx context.Context, filter bson.M, ref string) (map[primitive.ObjectID]models.DetectionRun, error) {
+	runFilter := bson.M{}
+	for key, value := range filter {
+		runFilter[key] = value
+	}
+
+	model, err := resolveDetectionModel(ctx, ref)
+	if errors.Is(err, errModelNotFound) {
+		name, version, hasVersion := strings.Cut(ref, ":")
+		if name != builtinModelName {
+			return nil, err
+		}
+		runFilter["modelId"] = bson.M{"$exists": false}
+		if hasVersion {
+			runFilter["modelVersion"] = version
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		runFilter["modelId"] = model.ID
+	}
+
+	// Edited runs carry the model they started from but are not its result.
+	runFilter["origin"] = bson.M{"$ne": models.OriginHuman}
+
+	opts := options.Find().SetSort(bson.M{"startedAt": -1}).SetProjection(bson.M{"rawOutput": 0})
+	cursor, err := detectionRunCollection.Find(ctx, runFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := make(map[primitive.ObjectID]models.DetectionRun)
+	for cursor.Next(ctx) {
+		var run models.DetectionRun
+		if err := cursor.Decode(&run); err != nil {
+			return nil, err
+		}
+		if _, ok := runs[run.Image]; !ok {
+			runs[run.Image] = run
+		}
+	}
+	return runs, cursor.Err()
+}
+
+// runSummary describes a run without its boxes or raw output.
+func runSummary(run models.DetectionRun) gin.H {
+	return gin.H{
+		"_id":          run.ID,
+		"model":        run.Model,
+		"modelVersion": run.ModelVersion,
+		"params":       run.Params,
+		"startedAt":    run.StartedAt,
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/signedurl"
+	"github.com/gin-gonic/gin"
+)
+
+// EventTokenPurpose is what event stream tokens are signed for, so they
+// cannot be used as file links or the other way round.
+const EventTokenPurpose = "events"
+
+// Event stream tokens only need to last until the stream is opened.
+const eventTokenTTL = 5 * time.Minute
+
+// How often a comment is written to idle streams so proxies keep them open.
+const eventKeepAliveInterval = 15 * time.Second
+
+// CreateEventToken issues a short-lived token the user can open the event
+// stream with as ?token=, since EventSource cannot send the Authorization
+// header.
+func CreateEventToken(c *gin.Context) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	token, expires := signedurl.Default.For(EventTokenPurpose, eventTokenTTL).Token(userData.ID.Hex())
+	c.JSON(http.StatusOK, gin.H{"success": true, "token": token, "expires": expires})
+}
+
+// StreamImageEvents streams the user's image status changes as Server-Sent
+// Events. A client that reconnects with the Last-Event-ID header (or the
+// lastEventId query parameter) first receives the events it missed; when
+// those are no longer available it receives a "reset" event and should
+// reload its images.
+func StreamImageEvents(c *gin.Context) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	sub, missed, complete := events.Subscribe(userData.ID, lastEventID)
+	defer events.Unsubscribe(sub)
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(c.Writer, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		if err := writeEvent(c, event); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with its
+				// last event ID and catches up.
+				return
+			}
+			if err := writeEvent(c, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeEvent(c *gin.Context, event events.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.IDString(), event.Type, data)
+	return err
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/TenJit/SE/Backend/export"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// imageConfig reads the size and colour channels of the image stored under
+// key from its header.
+func imageConfig(ctx context.Context, key string) (width, height, depth int, err error) {
+	object, _, err := storage.Default.Get(ctx, key)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer object.Close()
+
+	config, _, err := image.DecodeConfig(object)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	depth = 3
+	if config.ColorModel == color.GrayModel || config.ColorModel == color.Gray16Model {
+		depth = 1
+	}
+	return config.Width, config.Height, depth, nil
+}
+
+// exportable tells whether an image has boxes worth exporting: a finished
+// detection, or boxes someone drew by hand.
+func exportable(image models.Image) bool {
+	return image.Status == models.StatusSuccess || !image.EditedAt.IsZero()
+}
+
+// ExportAnnotations streams a zip of the originals of the given images under
+// images/ together with their boxes in the requested format: COCO as
+// annotations.json, YOLO as labels/*.txt with classes.txt, Pascal VOC as
+// annotations/*.xml or CSV as annotations.csv. Images without a finished
+// detection are left out, as are images whose file cannot be read.
+func ExportAnnotations(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	var requestData struct {
+		IDs    []string `json:"ids" binding:"required"`
+		Format string   `json:"format" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
+		return
+	}
+	format := requestData.Format
+	if format != export.FormatCOCO && format != export.FormatYOLO && format != export.FormatVOC && format != export.FormatCSV {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "format must be coco, yolo, voc or csv"})
+		return
+	}
+
+	found, ok := findOwnedImages(c, ctx, userData, requestData.IDs)
+	if !ok {
+		return
+	}
+	var images []models.Image
+	var results [][]models.DetectedObject
+	for _, image := range found {
+		if exportable(image) {
+			images = append(images, image)
+			results = append(results, image.Result)
+		}
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "None of the images have detections to export"})
+		return
+	}
+	// Classes are numbered before any label file is written, so they
+	// include those of images that turn out to be unreadable.
+	classes := export.Classes(results...)
+
+	timestamp := time.Now().Format("20060102_150405")
+	archive := newZipStream(c, fmt.Sprintf("annotations_%s_%s.zip", format, timestamp))
+	names := entryNames{}
+	exported := []export.Image{}
+
+	for _, image := range images {
+		width, height, depth, err := imageConfig(c.Request.Context(), image.ImagePath)
+		if err != nil {
+			log.Printf("failed to read size of %s for export: %v", image.ImagePath, err)
+			continue
+		}
+		name := names.unique(image.ImageName)
+		entry := export.Image{
+			ID:     image.ID.Hex(),
+			File:   name + filepath.Ext(image.ImagePath),
+			Width:  width,
+			Height: height,
+			Depth:  depth,
+			Result: image.Result,
+		}
+		if err := archive.addStored(export.ImagesDir+"/"+entry.File, image.ImagePath); err != nil {
+			if c.Request.Context().Err() != nil {
+				return
+			}
+			log.Printf("failed to add %s to export: %v", image.ImagePath, err)
+			continue
+		}
+
+		switch format {
+		case export.FormatYOLO:
+			err = archive.addBytes("labels/"+name+".txt", export.YOLO(entry, classes))
+		case export.FormatVOC:
+			var data []byte
+			if data, err = export.VOC(entry); err == nil {
+				err = archive.addBytes("annotations/"+name+".xml", data)
+			}
+		}
+		if err != nil {
+			log.Printf("failed to write annotations of %s: %v", image.ID.Hex(), err)
+			return
+		}
+		exported = append(exported, entry)
+	}
+
+	var err error
+	switch format {
+	case export.FormatCOCO:
+		err = archive.addJSON("annotations.json", export.COCO(exported, classes))
+	case export.FormatYOLO:
+		err = archive.addBytes("classes.txt", export.ClassNames(classes))
+	case export.FormatCSV:
+		var data []byte
+		if data, err = export.CSV(exported); err == nil {
+			err = archive.addBytes("annotations.csv", data)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to write annotations: %v", err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("failed to finish export: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/signedurl"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ServeSignedFile serves a stored file to anyone holding a valid signed link
+// to it. The links are only handed out in responses to the file's owner, so
+// they can be used where no Authorization header can be sent, like <img>.
+func ServeSignedFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	expires := c.Query("expires")
+
+	err := signedurl.Default.Verify(key, expires, c.Query("signature"))
+	if errors.Is(err, signedurl.ErrExpired) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Link has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Invalid link"})
+		return
+	}
+
+	maxAge := max(int(time.Until(signedurl.Expires(expires))/time.Second), 0)
+	serveStoredFile(c, key, map[string]string{
+		"Cache-Control": fmt.Sprintf("private, max-age=%d", maxAge),
+	})
+}
+
+// imageFileKey is the key of the image's file a variant names.
+func imageFileKey(image models.Image, variant string) (string, bool) {
+	switch variant {
+	case "original":
+		return image.ImagePath, true
+	case "detected":
+		return image.DetectedImagePath, true
+	case "thumbnail":
+		return image.ThumbnailPath, true
+	case "preview":
+		return image.PreviewPath, true
+	case "detectedThumbnail":
+		return image.DetectedThumbnailPath, true
+	case "detectedPreview":
+		return image.DetectedPreviewPath, true
+	}
+	return "", false
+}
+
+// StreamImageFile serves one of an image's files to its owner. The variant
+// query picks which: original (the default), detected, thumbnail, preview,
+// detectedThumbnail or detectedPreview.
+func StreamImageFile(c *gin.Context) {
+	image, ok := findOwnedImage(c, c.Request.Context(), "access")
+	if !ok {
+		return
+	}
+
+	variant := c.DefaultQuery("variant", "original")
+	key, ok := imageFileKey(image, variant)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown variant " + variant})
+		return
+	}
+	if key == "" || key == bson.TypeNull.String() {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Image has no " + variant + " file"})
+		return
+	}
+
+	serveStoredFile(c, key, map[string]string{"Cache-Control": "private, no-cache"})
+}
+
+// serveStoredFile streams the file stored under key. The storage read is
+// tied to the request rather than a fixed timeout so large files are not
+// cut off.
+func serveStoredFile(c *gin.Context, key string, headers map[string]string) {
+	object, info, err := storage.Default.Get(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error opening file"})
+		return
+	}
+	defer object.Close()
+
+	headers["X-Content-Type-Options"] = "nosniff"
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, object, headers)
+}
@@ -1,23 +1,26 @@
 package controllers
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"os/exec"
+	"net/url"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/jobs"
 	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/signedurl"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -32,229 +35,198 @@ func generateImagePath(originalFilename string) string {
 	return fmt.Sprintf("public/images/%d%s", timestamp, ext)
 }
 
-func findLatestExpFolder(outputDir string) (string, error) {
-	dirs, err := os.ReadDir(outputDir)
-	if err != nil {
-		return "", err
-	}
-
-	var expDirs []os.DirEntry
-	expDirPattern := regexp.MustCompile(`^exp(\d+)$`)
-
-	for _, dir := range dirs {
-		if dir.IsDir() && expDirPattern.MatchString(dir.Name()) {
-			expDirs = append(expDirs, dir)
-		}
-	}
-
-	if len(expDirs) == 0 {
-		return "", os.ErrNotExist
-	}
-
-	sort.Slice(expDirs, func(i, j int) bool {
-		iNum, _ := strconv.Atoi(expDirPattern.FindStringSubmatch(expDirs[i].Name())[1])
-		jNum, _ := strconv.Atoi(expDirPattern.FindStringSubmatch(expDirs[j].Name())[1])
-		return iNum > jNum
-	})
-
-	return filepath.Join(outputDir, expDirs[0].Name()), nil
+// imageUpload describes an image being added, whether on its own or as part
+// of a batch. With Reuse set, an earlier upload of the same bytes detected
+// with the same model and parameters provides the result instead of a new
+// detection. KeepLocation keeps the GPS position in the stored file.
+type imageUpload struct {
+	Name         string
+	Filename     string
+	Batch        primitive.ObjectID
+	Model        *models.DetectionModel
+	Params       models.InferenceParams
+	Reuse        bool
+	KeepLocation bool
 }
 
-func CreateImage(c *gin.Context) {
-	user, _ := c.Get("user")
-	userData, _ := user.(models.User)
-
-	if err := c.Request.ParseMultipartForm(50 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form data"})
-		return
-	}
+// uploadResult is the image created for an upload. Duplicate is the newest
+// earlier image of the user with the same bytes, and Reused reports whether
+// the image's result was copied from one instead of detected.
+type uploadResult struct {
+	Image     models.Image
+	Duplicate *models.Image
+	Reused    bool
+}
 
-	file, handler, err := c.Request.FormFile("image")
+// createImage stores the uploaded file, inserts its image and, unless an
+// earlier result could be reused, queues it for detection. Every upload path
+// goes through here.
+func createImage(ctx context.Context, user models.User, upload imageUpload, src io.Reader) (uploadResult, error) {
+	spooled, err := spoolUpload(src)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get image from form data"})
-		return
+		return uploadResult{}, err
 	}
-	defer file.Close()
+	defer spooled.Close()
 
-	name := c.PostForm("name")
-	if name == "" {
-		name = "untitled"
+	if err := spooled.validate(); err != nil {
+		return uploadResult{}, err
 	}
-
-	imagePath := generateImagePath(handler.Filename)
-
-	if err := os.MkdirAll(filepath.Dir(imagePath), os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory for image"})
-		return
-	}
-
-	out, err := os.Create(imagePath)
+	metadata, err := spooled.normalize(upload.KeepLocation)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create image file"})
-		return
+		return uploadResult{}, err
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(out, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image file"})
-		return
+	if err := reserveUsage(ctx, user, spooled.size); err != nil {
+		return uploadResult{}, err
+	}
+	blob, err := storeBlob(ctx, spooled)
+	if err != nil {
+		if err := releaseUsage(ctx, user.ID, spooled.size, 1); err != nil {
+			log.Printf("failed to release storage usage of user %s: %v", user.ID.Hex(), err)
+		}
+		return uploadResult{}, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to save image file", nil}
 	}
 
 	image := models.Image{
 		ID:                primitive.NewObjectID(),
-		ImageName:         name,
-		ImagePath:         imagePath,
-		User:              userData.ID,
-		Status:            "pending",
+		ImageName:         upload.Name,
+		ImagePath:         blob.Key,
+		Digest:            blob.Digest,
+		Size:              spooled.size,
+		Metadata:          metadata,
+		User:              user.ID,
+		Batch:             upload.Batch,
+		Status:            models.StatusPending,
 		CreatedAt:         time.Now(),
 		DetectedImagePath: bson.TypeNull.String(),
 		Result:            []models.DetectedObject{},
+		Params:            upload.Params,
 	}
+	applyDetectionModel(&image, upload.Model)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	_, err = imageCollection.InsertOne(ctx, image)
+	thumbnails, err := blobThumbnails(ctx, blob, spooled)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert image into database"})
-		return
+		log.Printf("failed to generate thumbnails of %s: %v", blob.Key, err)
 	}
+	image.ThumbnailPath = thumbnails.Thumbnail
+	image.PreviewPath = thumbnails.Preview
 
-	baseDir, err := filepath.Abs(".")
+	var created uploadResult
+	duplicates, err := findDuplicates(ctx, user, blob.Digest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get absolute path"})
-		return
+		log.Printf("failed to look up duplicates of image %s: %v", image.ID.Hex(), err)
 	}
-	detectScriptPath := filepath.Join(baseDir, "imagedetection/yolov5/detect.py")
-	weightsPath := filepath.Join(baseDir, "imagedetection/yolov5s-cat-dog.pt")
-	outputFolder := filepath.Join(baseDir, "imagedetection/yolov5/runs/detect")
-	pythonPath := filepath.Join(baseDir, "imagedetection/yolov5/yolov5_venv/bin/python")
-
-	cmd := exec.Command(pythonPath, detectScriptPath, "--weights", weightsPath, "--img", "640", "--conf", "0.25", "--source", imagePath)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		_, newerr := imageCollection.UpdateOne(ctx, bson.M{"_id": image.ID}, bson.M{
-			"$set": bson.M{
-				"status": "fail",
-			},
-		})
-		if newerr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image in database , run detection script", "details": string(output) + "\n" + err.Error()})
-			return
+	if len(duplicates) > 0 {
+		created.Duplicate = &duplicates[0]
+	}
+	if source := reusableResult(image, duplicates); upload.Reuse && source != nil {
+		if err := reuseResult(ctx, &image, *source); err != nil {
+			log.Printf("failed to reuse result of image %s: %v", source.ID.Hex(), err)
+		} else {
+			created.Reused = true
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run detection script", "details": string(output) + "\n" + err.Error()})
-		return
 	}
 
-	latestExpFolder, err := findLatestExpFolder(outputFolder)
+	_, err = imageCollection.InsertOne(ctx, image)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to locate the latest exp folder"})
-		return
+		if err := releaseBlob(ctx, blob.Digest, blob.Key); err != nil {
+			log.Printf("failed to release image file %s: %v", blob.Key, err)
+		}
+		if created.Reused {
+			deleteDetectionRuns(ctx, []primitive.ObjectID{image.ID})
+		}
+		if err := releaseUsage(ctx, user.ID, spooled.size, 1); err != nil {
+			log.Printf("failed to release storage usage of user %s: %v", user.ID.Hex(), err)
+		}
+		return uploadResult{}, &uploadError{http.StatusInternalServerError, uploadDatabaseFailed, "Failed to insert image into database", nil}
 	}
 
-	jsonFilePath := filepath.Join(latestExpFolder, "detections.json")
-
-	jsonFile, err := os.Open(jsonFilePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open JSON output file"})
-		return
+	events.PublishImageStatus(image)
+	if !created.Reused {
+		if err := jobs.EnqueueDetection(image.ID); err != nil {
+			log.Printf("image %s left pending: %v", image.ID.Hex(), err)
+		}
 	}
-	defer jsonFile.Close()
+	created.Image = image
+	return created, nil
+}
+
+func CreateImage(c *gin.Context) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
 
-	var detectOutput map[string]interface{}
-	if err := json.NewDecoder(jsonFile).Decode(&detectOutput); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode JSON output file"})
+	if err := parseUploadForm(c, configs.UploadMaxBytes()); err != nil {
+		c.JSON(err.Status, err.response())
 		return
 	}
 
-	results, ok := detectOutput["results"].([]interface{})
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid JSON structure"})
+	file, handler, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get image from form data"})
 		return
 	}
+	defer file.Close()
 
-	detectedObjectsMap := make(map[string][]models.Coordinate)
-
-	for _, result := range results {
-		resultMap := result.(map[string]interface{})
-		detections := resultMap["detections"].([]interface{})
-		for _, detection := range detections {
-			detectionMap := detection.(map[string]interface{})
-			className := detectionMap["class_name"].(string)
-			confidence := float32(detectionMap["confidence"].(float64))
-			boundingBox := detectionMap["bounding_box"].(map[string]interface{})
-
-			coordinate := models.Coordinate{
-				Bounding_id: primitive.NewObjectID(),
-				Confidence:  confidence,
-				X_min:       int(boundingBox["x_min"].(float64)),
-				X_max:       int(boundingBox["x_max"].(float64)),
-				Y_min:       int(boundingBox["y_min"].(float64)),
-				Y_max:       int(boundingBox["y_max"].(float64)),
-			}
-			detectedObjectsMap[className] = append(detectedObjectsMap[className], coordinate)
-		}
+	name := c.PostForm("name")
+	if name == "" {
+		name = "untitled"
 	}
 
-	var detectedObjects []models.DetectedObject
-	for name, coordinates := range detectedObjectsMap {
-		detectedObjects = append(detectedObjects, models.DetectedObject{
-			Name:        name,
-			Coordinates: coordinates,
-		})
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	detectedImageFiles, err := filepath.Glob(filepath.Join(latestExpFolder, "*.*"))
-	if err != nil || len(detectedImageFiles) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find detected image file"})
+	detectionModel, err := resolveDetectionModel(ctx, c.PostForm("model"))
+	if errors.Is(err, errModelNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown model"})
 		return
 	}
-	detectedImagePath := detectedImageFiles[0]
-
-	detectedImageSavePath := generateImagePath("detected_" + handler.Filename)
-
-	if err := os.MkdirAll(filepath.Dir(detectedImageSavePath), os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory for detected image"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find model"})
 		return
 	}
 
-	srcFile, err := os.Open(detectedImagePath)
+	params, err := parseInferenceParams(c, defaultInferenceParams(detectionModel), detectionModel)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open detected image file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer srcFile.Close()
 
-	destFile, err := os.Create(detectedImageSavePath)
+	created, err := createImage(ctx, userData, imageUpload{
+		Name:         name,
+		Filename:     handler.Filename,
+		Model:        detectionModel,
+		Params:       params,
+		Reuse:        c.PostForm("reuse") == "true",
+		KeepLocation: c.PostForm("keepLocation") == "true",
+	}, file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create detected image file"})
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) {
+			c.JSON(uploadErr.Status, uploadErr.response())
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save detected image file"})
+	image := created.Image
+	withURLs(&image)
+	if created.Duplicate == nil {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Image accepted for detection", "imagePath": image.ImagePath, "image": image})
 		return
 	}
 
-	image.DetectedImagePath = detectedImageSavePath
-	image.Result = detectedObjects
-	image.Status = "success"
-
-	_, err = imageCollection.UpdateOne(ctx, bson.M{"_id": image.ID}, bson.M{
-		"$set": bson.M{
-			"result":            image.Result,
-			"status":            image.Status,
-			"detectedImagePath": image.DetectedImagePath,
-		},
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image in database"})
+	duplicate := gin.H{
+		"message":   "This image was already uploaded",
+		"image":     created.Duplicate.ID,
+		"imageName": created.Duplicate.ImageName,
+		"reused":    created.Reused,
+	}
+	if created.Reused {
+		c.JSON(http.StatusCreated, gin.H{"message": "Image uploaded with an earlier detection result", "imagePath": image.ImagePath, "image": image, "duplicate": duplicate})
 		return
 	}
-
-	c.JSON(http.StatusCreated, gin.H{"message": "Image created successfully", "imagePath": image.ImagePath, "image": image})
+	c.JSON(http.StatusAccepted, gin.H{"message": "Image accepted for detection", "imagePath": image.ImagePath, "image": image, "duplicate": duplicate})
 }
 
 func GetAllImages(c *gin.Context) {
@@ -275,21 +247,21 @@ func GetAllImages(c *gin.Context) {
 	}
 
 	matchStage := bson.D{
-		{"$match", bson.D{
-			{"user", userData.ID},
+		{Key: "$match", Value: bson.D{
+			{Key: "user", Value: userData.ID},
 		}},
 	}
 
 	if search != "" {
-		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{"imageName", bson.M{"$regex": search, "$options": "i"}})
+		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{Key: "imageName", Value: bson.M{"$regex": search, "$options": "i"}})
 	}
 
 	if status != "" {
-		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{"status", status})
+		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{Key: "status", Value: status})
 	}
 
 	if recent == "true" {
-		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{"createdAt", bson.M{"$gte": time.Now().Add(-24 * time.Hour)}})
+		matchStage[0].Value = append(matchStage[0].Value.(bson.D), bson.E{Key: "createdAt", Value: bson.M{"$gte": time.Now().Add(-24 * time.Hour)}})
 	}
 
 	sortField := "createdAt"
@@ -298,8 +270,8 @@ func GetAllImages(c *gin.Context) {
 	}
 
 	sortStage := bson.D{
-		{"$sort", bson.D{
-			{sortField, order},
+		{Key: "$sort", Value: bson.D{
+			{Key: sortField, Value: order},
 		}},
 	}
 
@@ -321,10 +293,32 @@ func GetAllImages(c *gin.Context) {
 		return
 	}
 
+	for i := range images {
+		withURLs(&images[i])
+	}
+
 	counts := len(images)
 	c.JSON(http.StatusOK, gin.H{"success": true, "counts": counts, "data": images})
 }
 
+// withURLs fills in the signed links image's files are served at.
+func withURLs(image *models.Image) {
+	image.ImageURL = fileURL(image.ImagePath)
+	image.DetectedImageURL = fileURL(image.DetectedImagePath)
+	image.ThumbnailURL = fileURL(image.ThumbnailPath)
+	image.PreviewURL = fileURL(image.PreviewPath)
+	image.DetectedThumbnailURL = fileURL(image.DetectedThumbnailPath)
+	image.DetectedPreviewURL = fileURL(image.DetectedPreviewPath)
+}
+
+// fileURL is the signed link a stored file is served at, or "" for no file.
+func fileURL(key string) string {
+	if key == "" || key == bson.TypeNull.String() {
+		return ""
+	}
+	return signedurl.Default.URL(key)
+}
+
 func GetImageByID(c *gin.Context) {
 	context, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -347,6 +341,7 @@ func GetImageByID(c *gin.Context) {
 	}
 
 	if userData.ID == image.User {
+		withURLs(&image)
 		c.JSON(http.StatusOK, gin.H{"success": true, "data": image})
 		return
 	}
@@ -395,6 +390,102 @@ func RenameImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image renamed successfully"})
 }
 
+// RedetectImage runs detection again on a stored image, optionally with a
+// different model or parameters. Earlier runs are kept, and the new run
+// becomes the image's current run once it finishes.
+func RedetectImage(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	id := c.Param("id")
+	ImageID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid image ID"})
+		return
+	}
+
+	var image models.Image
+	err = imageCollection.FindOne(ctx, bson.M{"_id": ImageID}).Decode(&image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding image"})
+		return
+	}
+
+	if userData.ID != image.User {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to detect this image"})
+		return
+	}
+
+	if image.Status == models.StatusPending || image.Status == models.StatusProcessing {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Detection is already in progress"})
+		return
+	}
+
+	var detectionModel *models.DetectionModel
+	baseParams := image.Params
+	if modelRef := c.PostForm("model"); modelRef != "" {
+		detectionModel, err = resolveDetectionModel(ctx, modelRef)
+		if err == nil && (detectionModel == nil || detectionModel.ID != image.ModelID) {
+			baseParams = defaultInferenceParams(detectionModel)
+		}
+	} else {
+		detectionModel, err = detectionModelOf(ctx, image)
+	}
+	if errors.Is(err, errModelNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown model"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding model"})
+		return
+	}
+
+	params, err := parseInferenceParams(c, baseParams, detectionModel)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	previousStatus := image.Status
+	applyDetectionModel(&image, detectionModel)
+	image.Params = params
+	image.Status = models.StatusPending
+	image.Result = []models.DetectedObject{}
+	image.Error = ""
+
+	// Matching on the status read above keeps two concurrent requests from
+	// both queueing a run.
+	result, err := imageCollection.UpdateOne(ctx, bson.M{"_id": ImageID, "status": previousStatus}, bson.M{
+		"$set": bson.M{
+			"modelId":      image.ModelID,
+			"model":        image.Model,
+			"modelVersion": image.ModelVersion,
+			"params":       image.Params,
+			"status":       image.Status,
+			"result":       image.Result,
+		},
+		"$unset": bson.M{"error": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating image"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Detection is already in progress"})
+		return
+	}
+
+	events.PublishImageStatus(image)
+	if err := jobs.EnqueueDetection(image.ID); err != nil {
+		log.Printf("image %s left pending: %v", image.ID.Hex(), err)
+	}
+
+	withURLs(&image)
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Image queued for detection", "data": image})
+}
+
 func DeleteImage(c *gin.Context) {
 	context, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -420,18 +511,27 @@ func DeleteImage(c *gin.Context) {
 		return
 	}
 
-	err = os.Remove(image.ImagePath)
+	err = releaseImageFile(context, image)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting image file"})
 		return
 	}
 
 	if image.DetectedImagePath != "null" {
-		err = os.Remove(image.DetectedImagePath)
+		err = storage.Default.Delete(context, image.DetectedImagePath)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting detected image file"})
 			return
 		}
+		if err := thumbnail.Remove(context, storage.Default, image.DetectedImagePath); err != nil {
+			log.Printf("failed to remove thumbnails of %s: %v", image.DetectedImagePath, err)
+		}
+	}
+
+	err = deleteDetectionRuns(context, []primitive.ObjectID{ImageID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting detection runs"})
+		return
 	}
 
 	_, err = imageCollection.DeleteOne(context, bson.M{"_id": ImageID})
@@ -439,6 +539,9 @@ func DeleteImage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting image from database"})
 		return
 	}
+	if err := releaseUsage(context, image.User, image.Size, 1); err != nil {
+		log.Printf("failed to release storage usage of image %s: %v", image.ID.Hex(), err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image deleted successfully"})
 }
@@ -486,27 +589,48 @@ func DeleteManyImages(c *gin.Context) {
 	}
 
 	for _, image := range images {
-		err = os.Remove(image.ImagePath)
+		err = releaseImageFile(context, image)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": fmt.Sprintf("Error deleting image file: %s", image.ImagePath)})
 			return
 		}
 
 		if image.DetectedImagePath != "null" {
-			err = os.Remove(image.DetectedImagePath)
+			err = storage.Default.Delete(context, image.DetectedImagePath)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting detected image file"})
 				return
 			}
+			if err := thumbnail.Remove(context, storage.Default, image.DetectedImagePath); err != nil {
+				log.Printf("failed to remove thumbnails of %s: %v", image.DetectedImagePath, err)
+			}
 		}
 	}
 
+	imageIDs := make([]primitive.ObjectID, 0, len(images))
+	for _, image := range images {
+		imageIDs = append(imageIDs, image.ID)
+	}
+	err = deleteDetectionRuns(context, imageIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting detection runs"})
+		return
+	}
+
 	result, err := imageCollection.DeleteMany(context, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting images from database"})
 		return
 	}
 
+	var releasedBytes int64
+	for _, image := range images {
+		releasedBytes += image.Size
+	}
+	if err := releaseUsage(context, userData.ID, releasedBytes, result.DeletedCount); err != nil {
+		log.Printf("failed to release storage usage of user %s: %v", userData.ID.Hex(), err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": fmt.Sprintf("%d images deleted successfully", result.DeletedCount)})
 }
 
@@ -535,13 +659,70 @@ func DownloadImage(c *gin.Context) {
 		return
 	}
 
+	key, filename := image.ImagePath, image.ImageName
 	if image.DetectedImagePath != "null" {
-		c.FileAttachment(image.DetectedImagePath, "detected_"+image.ImageName)
-	} else {
-		c.FileAttachment(image.ImagePath, image.ImageName)
+		key, filename = image.DetectedImagePath, "detected_"+image.ImageName
+	}
+
+	// The lookup's timeout would cut off a slow download, so the file is
+	// read for as long as the client stays connected.
+	object, info, err := storage.Default.Get(c.Request.Context(), key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error opening image file"})
+		return
+	}
+	defer object.Close()
+
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, object, map[string]string{
+		"Content-Disposition": attachmentDisposition(filename),
+	})
+}
+
+// attachmentDisposition builds the Content-Disposition header gin's
+// FileAttachment would send for filename.
+func attachmentDisposition(filename string) string {
+	for _, r := range filename {
+		if r > unicode.MaxASCII {
+			return "attachment; filename*=UTF-8''" + url.QueryEscape(filename)
+		}
 	}
+	return `attachment; filename="` + strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(filename) + `"`
 }
 
+// Which files DownloadManyImages puts in the archive. Detected falls back to
+// the original for images without an annotated copy.
+const (
+	downloadOriginal = "original"
+	downloadDetected = "detected"
+	downloadBoth     = "both"
+)
+
+// downloadManifest is the manifest.json of a download, recording each
+// image's detection result and where its files are in the archive.
+type downloadManifest struct {
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Include     string          `json:"include"`
+	Images      []manifestImage `json:"images"`
+}
+
+type manifestImage struct {
+	ID           primitive.ObjectID      `json:"id"`
+	ImageName    string                  `json:"imageName"`
+	Status       string                  `json:"status"`
+	Model        string                  `json:"model,omitempty"`
+	ModelVersion string                  `json:"modelVersion,omitempty"`
+	Params       models.InferenceParams  `json:"params"`
+	Result       []models.DetectedObject `json:"result"`
+	CreatedAt    time.Time               `json:"createdAt"`
+	DetectedAt   time.Time               `json:"detectedAt,omitempty"`
+	Files        map[string]string       `json:"files"`
+	Missing      []string                `json:"missing,omitempty"`
+}
+
+// DownloadManyImages streams a zip of the chosen images. include picks the
+// originals, the annotated images or both; with both they go into
+// originals/ and detected/ under the same name. A manifest.json with the
+// detection results comes last.
 func DownloadManyImages(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -549,94 +730,131 @@ func DownloadManyImages(c *gin.Context) {
 	userData, _ := user.(models.User)
 
 	var requestData struct {
-		IDs []string `json:"ids" binding:"required"`
+		IDs     []string `json:"ids" binding:"required"`
+		Include string   `json:"include"`
 	}
 	if err := c.ShouldBindJSON(&requestData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
 		return
 	}
-
-	var objectIDs []primitive.ObjectID
-	for _, id := range requestData.IDs {
-		objID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid image ID"})
-			return
-		}
-		objectIDs = append(objectIDs, objID)
+	include := requestData.Include
+	if include == "" {
+		include = downloadDetected
 	}
-
-	var images []models.Image
-	filter := bson.M{"_id": bson.M{"$in": objectIDs}, "user": userData.ID}
-	cursor, err := imageCollection.Find(ctx, filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding images"})
+	if include != downloadOriginal && include != downloadDetected && include != downloadBoth {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "include must be original, detected or both"})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	if err := cursor.All(ctx, &images); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error reading images"})
+	images, ok := findOwnedImages(c, ctx, userData, requestData.IDs)
+	if !ok {
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
+	timestamp := time.Now().Format("20060102_150405")
+	archive := newZipStream(c, fmt.Sprintf("images_%s.zip", timestamp))
+	manifest := downloadManifest{GeneratedAt: time.Now(), Include: include, Images: []manifestImage{}}
+	names := entryNames{}
 
 	for _, image := range images {
-		file, err := os.Open(image.ImagePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error opening image file"})
-			return
+		entry := manifestImage{
+			ID:           image.ID,
+			ImageName:    image.ImageName,
+			Status:       image.Status,
+			Model:        image.Model,
+			ModelVersion: image.ModelVersion,
+			Params:       image.Params,
+			Result:       image.Result,
+			CreatedAt:    image.CreatedAt,
+			DetectedAt:   image.DetectedAt,
+			Files:        map[string]string{},
 		}
-
-		if image.DetectedImagePath != "null" {
-			file, err = os.Open(image.DetectedImagePath)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error opening image file"})
-				return
+		name := names.unique(image.ImageName)
+		hasDetected := image.DetectedImagePath != bson.TypeNull.String() && image.DetectedImagePath != ""
+
+		files := map[string]string{}
+		switch {
+		case include == downloadBoth:
+			files[downloadOriginal] = "originals/" + name + filepath.Ext(image.ImagePath)
+			if hasDetected {
+				files[downloadDetected] = "detected/" + name + filepath.Ext(image.DetectedImagePath)
 			}
+		case include == downloadDetected && hasDetected:
+			files[downloadDetected] = name + filepath.Ext(image.DetectedImagePath)
+		default:
+			files[downloadOriginal] = name + filepath.Ext(image.ImagePath)
 		}
-		defer file.Close()
 
-		info, err := file.Stat()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error getting image file info"})
-			return
+		for _, variant := range []string{downloadOriginal, downloadDetected} {
+			entryName, ok := files[variant]
+			if !ok {
+				continue
+			}
+			key := image.ImagePath
+			if variant == downloadDetected {
+				key = image.DetectedImagePath
+			}
+			if err := archive.addStored(entryName, key); err != nil {
+				if c.Request.Context().Err() != nil {
+					return
+				}
+				log.Printf("failed to add %s to download: %v", key, err)
+				entry.Missing = append(entry.Missing, variant)
+				continue
+			}
+			entry.Files[variant] = entryName
 		}
+		manifest.Images = append(manifest.Images, entry)
+	}
 
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating zip header"})
-			return
-		}
-		header.Name = image.ImageName + filepath.Ext(image.ImagePath) // Use image name with the correct extension
+	if err := archive.addJSON("manifest.json", manifest); err != nil {
+		log.Printf("failed to write download manifest: %v", err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("failed to finish download: %v", err)
+	}
+}
 
-		writer, err := zipWriter.CreateHeader(header)
+// findOwnedImages loads the user's images with the given hex IDs, in the
+// order they were asked for. IDs of other users' images are left out.
+func findOwnedImages(c *gin.Context, ctx context.Context, user models.User, ids []string) ([]models.Image, bool) {
+	var objectIDs []primitive.ObjectID
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating zip writer"})
-			return
-		}
-
-		if _, err := io.Copy(writer, file); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error writing to zip"})
-			return
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid image ID"})
+			return nil, false
 		}
+		objectIDs = append(objectIDs, objID)
 	}
 
-	if err := zipWriter.Close(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error closing zip writer"})
-		return
+	var images []models.Image
+	filter := bson.M{"_id": bson.M{"$in": objectIDs}, "user": user.ID}
+	cursor, err := imageCollection.Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding images"})
+		return nil, false
 	}
+	defer cursor.Close(ctx)
 
-	timestamp := time.Now().Format("20060102_150405")
-	zipFilename := fmt.Sprintf("images_%s.zip", timestamp)
-
-	c.Writer.Header().Set("Content-Type", "application/zip")
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", zipFilename))
+	if err := cursor.All(ctx, &images); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error reading images"})
+		return nil, false
+	}
+	sortByIDs(images, objectIDs)
+	return images, true
+}
 
-	if _, err := io.Copy(c.Writer, buf); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error writing zip to response"})
-		return
+// sortByIDs puts images in the order their IDs were asked for.
+func sortByIDs(images []models.Image, ids []primitive.ObjectID) {
+	order := make(map[primitive.ObjectID]int, len(ids))
+	for i, id := range ids {
+		if _, seen := order[id]; !seen {
+			order[id] = i
+		}
 	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return order[images[i].ID] < order[images[j].ID]
+	})
 }
@@ -0,0 +1,286 @@
+//go:build integration
+
+// These tests drive the image handlers against a real MongoDB with the fake
+// detector standing in for python. Point MONGOURI and MONGO_DATABASE at a
+// throwaway database and run them with:
+//
+//	go test -tags integration ./controllers/
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/jobs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testStore storage.BlobStore
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	root, err := os.MkdirTemp("", "image-controller-test")
+	if err != nil {
+		panic(err)
+	}
+	testStore = storage.NewLocal(root)
+	storage.Default = testStore
+	jobs.StartDetectionWorkers(&detector.Fake{}, 2, 16)
+
+	code := m.Run()
+	os.RemoveAll(root)
+	os.Exit(code)
+}
+
+// newTestUser stores a user and removes it, with its images, runs and
+// blobs, when the test ends.
+func newTestUser(t *testing.T) models.User {
+	t.Helper()
+	ctx := context.Background()
+	user := models.User{
+		ID:    primitive.NewObjectID(),
+		Name:  "test",
+		Email: primitive.NewObjectID().Hex() + "@example.com",
+		Role:  "user",
+	}
+	if _, err := userCollection.InsertOne(ctx, user); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	t.Cleanup(func() {
+		var images []models.Image
+		if cursor, err := imageCollection.Find(ctx, bson.M{"user": user.ID}); err == nil {
+			cursor.All(ctx, &images)
+		}
+		for _, image := range images {
+			blobCollection.DeleteOne(ctx, bson.M{"_id": image.Digest})
+		}
+		imageCollection.DeleteMany(ctx, bson.M{"user": user.ID})
+		detectionRunCollection.DeleteMany(ctx, bson.M{"user": user.ID})
+		userCollection.DeleteOne(ctx, bson.M{"_id": user.ID})
+	})
+	return user
+}
+
+// newTestRouter serves the image routes as user, in place of middleware.Protect.
+func newTestRouter(user models.User) *gin.Engine {
+	router := gin.New()
+	images := router.Group("/images", func(c *gin.Context) { c.Set("user", user) })
+	images.POST("", CreateImage)
+	images.POST("/batches", CreateBatch)
+	images.POST("/:id/detect", RedetectImage)
+	images.POST("/:id/boxes", AddBox)
+	images.PUT("/:id/runs/:runId/current", SetCurrentRun)
+	images.DELETE("/:id", DeleteImage)
+	images.POST("/uploads", CreateResumableUpload)
+	images.HEAD("/uploads/:uploadId", GetResumableUploadOffset)
+	images.GET("/uploads/:uploadId", GetResumableUpload)
+	images.PATCH("/uploads/:uploadId", PatchResumableUpload)
+	return router
+}
+
+// testPNG is a small PNG of random noise, so every upload is a new blob.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rand.Intn(256))
+	}
+	img.Set(0, 0, color.RGBA{A: 0xFF})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func uploadRequest(t *testing.T, name string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("name", name)
+	part, err := form.CreateFormFile("image", name+".png")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/images", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// createTestImage uploads a new image and waits for its detection.
+func createTestImage(t *testing.T, router *gin.Engine) models.Image {
+	t.Helper()
+	recorder := serve(router, uploadRequest(t, "test", testPNG(t)))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("create: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Image models.Image `json:"image"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode create response: %v", err)
+	}
+	return waitForDetection(t, response.Image.ID)
+}
+
+// waitForDetection polls the image until its detection has finished.
+func waitForDetection(t *testing.T, id primitive.ObjectID) models.Image {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var image models.Image
+		if err := imageCollection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&image); err != nil {
+			t.Fatalf("find image: %v", err)
+		}
+		if image.Status == models.StatusSuccess || image.Status == models.StatusFail {
+			return image
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("image %s still %s", id.Hex(), image.Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func countRuns(t *testing.T, id primitive.ObjectID) int64 {
+	t.Helper()
+	count, err := detectionRunCollection.CountDocuments(context.Background(), bson.M{"image": id})
+	if err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	return count
+}
+
+func stored(key string) bool {
+	_, err := testStore.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestCreateImage(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+
+	image := createTestImage(t, router)
+	if image.Status != models.StatusSuccess {
+		t.Fatalf("status %s, error %q", image.Status, image.Error)
+	}
+	if image.User != user.ID || image.ImageName != "test" {
+		t.Errorf("image stored for user %s as %q", image.User.Hex(), image.ImageName)
+	}
+	if !stored(image.ImagePath) {
+		t.Errorf("original %s not stored", image.ImagePath)
+	}
+	if !stored(image.DetectedImagePath) {
+		t.Errorf("annotated image %s not stored", image.DetectedImagePath)
+	}
+	if image.CurrentRun.IsZero() || countRuns(t, image.ID) != 1 {
+		t.Errorf("expected one current run, got %d", countRuns(t, image.ID))
+	}
+
+	var updated models.User
+	userCollection.FindOne(context.Background(), bson.M{"_id": user.ID}).Decode(&updated)
+	if updated.Usage.Images != 1 || updated.Usage.Bytes != image.Size {
+		t.Errorf("usage %+v, want 1 image of %d bytes", updated.Usage, image.Size)
+	}
+}
+
+func TestCreateImageRejectsNonImage(t *testing.T) {
+	router := newTestRouter(newTestUser(t))
+
+	recorder := serve(router, uploadRequest(t, "text", []byte("not an image")))
+	if recorder.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRedetectImage(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+	image := createTestImage(t, router)
+
+	recorder := serve(router, httptest.NewRequest(http.MethodPost, "/images/"+image.ID.Hex()+"/detect", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("redetect: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	redetected := waitForDetection(t, image.ID)
+	if redetected.Status != models.StatusSuccess {
+		t.Fatalf("status %s, error %q", redetected.Status, redetected.Error)
+	}
+	if redetected.CurrentRun == image.CurrentRun {
+		t.Error("current run not replaced")
+	}
+	if countRuns(t, image.ID) != 2 {
+		t.Errorf("expected two runs, got %d", countRuns(t, image.ID))
+	}
+	// The fake detector is deterministic, so the result must not change.
+	if len(redetected.Result) != len(image.Result) {
+		t.Errorf("result changed from %v to %v", image.Result, redetected.Result)
+	}
+}
+
+func TestRedetectImageOfOtherUser(t *testing.T) {
+	image := createTestImage(t, newTestRouter(newTestUser(t)))
+
+	recorder := serve(newTestRouter(newTestUser(t)), httptest.NewRequest(http.MethodPost, "/images/"+image.ID.Hex()+"/detect", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	user := newTestUser(t)
+	router := newTestRouter(user)
+	image := createTestImage(t, router)
+
+	recorder := serve(router, httptest.NewRequest(http.MethodDelete, "/images/"+image.ID.Hex(), nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	ctx := context.Background()
+	if count, _ := imageCollection.CountDocuments(ctx, bson.M{"_id": image.ID}); count != 0 {
+		t.Error("image document not deleted")
+	}
+	if countRuns(t, image.ID) != 0 {
+		t.Error("detection runs not deleted")
+	}
+	if count, _ := blobCollection.CountDocuments(ctx, bson.M{"_id": image.Digest}); count != 0 {
+		t.Error("blob not released")
+	}
+	if stored(image.ImagePath) || stored(image.DetectedImagePath) {
+		t.Error("image files not deleted")
+	}
+
+	var updated models.User
+	userCollection.FindOne(ctx, bson.M{"_id": user.ID}).Decode(&updated)
+	if updated.Usage != (models.StorageUsage{}) {
+		t.Errorf("usage %+v not released", updated.Usage)
+	}
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+const imageSizeStride = 32
+
+// defaultInferenceParams returns the parameters used when an upload sets
+// none: detect.py's defaults, with the thresholds registered with model
+// when it has them.
+func defaultInferenceParams(model *models.DetectionModel) models.InferenceParams {
+	params := models.InferenceParams{
+		ConfThreshold: detector.DefaultConfThreshold,
+		IoUThreshold:  detector.DefaultIoUThreshold,
+		ImageSize:     detector.DefaultImageSize,
+		MaxDetections: min(detector.DefaultMaxDetections, configs.InferenceMaxDetections()),
+	}
+	if model != nil && model.ConfThreshold > 0 {
+		params.ConfThreshold = model.ConfThreshold
+	}
+	if model != nil && model.IoUThreshold > 0 {
+		params.IoUThreshold = model.IoUThreshold
+	}
+	return params
+}
+
+// parseInferenceParams reads the optional conf, iou, imgSize, maxDet and
+// classes form fields on top of base and checks them against the server
+// limits and, for classes, against the classes model was registered with.
+func parseInferenceParams(c *gin.Context, base models.InferenceParams, model *models.DetectionModel) (models.InferenceParams, error) {
+	return parseInferenceFields(c.PostForm, base, model)
+}
+
+// parseInferenceFields is parseInferenceParams for values looked up by
+// field, wherever the request carries them.
+func parseInferenceFields(field func(string) string, base models.InferenceParams, model *models.DetectionModel) (models.InferenceParams, error) {
+	params := base
+
+	if value := field("conf"); value != "" {
+		conf, err := strconv.ParseFloat(value, 32)
+		if err != nil || conf <= 0 || conf > 1 {
+			return params, fmt.Errorf("conf must be a number above 0 and at most 1")
+		}
+		params.ConfThreshold = float32(conf)
+	}
+
+	if value := field("iou"); value != "" {
+		iou, err := strconv.ParseFloat(value, 32)
+		if err != nil || iou <= 0 || iou > 1 {
+			return params, fmt.Errorf("iou must be a number above 0 and at most 1")
+		}
+		params.IoUThreshold = float32(iou)
+	}
+
+	if value := field("imgSize"); value != "" {
+		maxImageSize := configs.InferenceMaxImageSize()
+		imageSize, err := strconv.Atoi(value)
+		if err != nil || imageSize < imageSizeStride || imageSize > maxImageSize || imageSize%imageSizeStride != 0 {
+			return params, fmt.Errorf("imgSize must be a multiple of %d between %d and %d", imageSizeStride, imageSizeStride, maxImageSize)
+		}
+		params.ImageSize = imageSize
+	}
+
+	if value := field("maxDet"); value != "" {
+		maxDetections := configs.InferenceMaxDetections()
+		maxDet, err := strconv.Atoi(value)
+		if err != nil || maxDet < 1 || maxDet > maxDetections {
+			return params, fmt.Errorf("maxDet must be between 1 and %d", maxDetections)
+		}
+		params.MaxDetections = maxDet
+	}
+
+	if value := field("classes"); value != "" {
+		params.Classes = nil
+		for _, class := range strings.Split(value, ",") {
+			class = strings.TrimSpace(class)
+			if class == "" {
+				continue
+			}
+			if model != nil && len(model.Classes) > 0 && !slices.Contains(model.Classes, class) {
+				return params, fmt.Errorf("class %q is not detected by model %s:%s", class, model.Name, model.Version)
+			}
+			params.Classes = append(params.Classes, class)
+		}
+	}
+
+	return params, nil
+}
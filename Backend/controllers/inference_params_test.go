@@ -0,0 +1,58 @@
+//go:build integration
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/models"
+)
+
+func TestParseInferenceFields(t *testing.T) {
+	model := &models.DetectionModel{Name: "pets", Version: "1", Classes: []string{"cat", "dog"}, ConfThreshold: 0.4}
+	base := defaultInferenceParams(model)
+	if base.ConfThreshold != 0.4 || base.IoUThreshold != detector.DefaultIoUThreshold || base.ImageSize != detector.DefaultImageSize {
+		t.Fatalf("defaults %+v", base)
+	}
+
+	for _, test := range []struct {
+		name    string
+		fields  map[string]string
+		want    models.InferenceParams
+		wantErr bool
+	}{
+		{name: "none", want: base},
+		{
+			name:   "all",
+			fields: map[string]string{"conf": "0.5", "iou": "0.6", "imgSize": "320", "maxDet": "10", "classes": "cat, dog,"},
+			want:   models.InferenceParams{ConfThreshold: 0.5, IoUThreshold: 0.6, ImageSize: 320, MaxDetections: 10, Classes: []string{"cat", "dog"}},
+		},
+		{name: "conf one", fields: map[string]string{"conf": "1"}, want: models.InferenceParams{ConfThreshold: 1, IoUThreshold: base.IoUThreshold, ImageSize: base.ImageSize, MaxDetections: base.MaxDetections}},
+		// The detector reads zero thresholds as unset.
+		{name: "conf zero", fields: map[string]string{"conf": "0"}, wantErr: true},
+		{name: "iou zero", fields: map[string]string{"iou": "0"}, wantErr: true},
+		{name: "conf above one", fields: map[string]string{"conf": "1.5"}, wantErr: true},
+		{name: "iou negative", fields: map[string]string{"iou": "-0.1"}, wantErr: true},
+		{name: "conf not a number", fields: map[string]string{"conf": "high"}, wantErr: true},
+		{name: "imgSize off stride", fields: map[string]string{"imgSize": "100"}, wantErr: true},
+		{name: "maxDet zero", fields: map[string]string{"maxDet": "0"}, wantErr: true},
+		{name: "unknown class", fields: map[string]string{"classes": "bird"}, wantErr: true},
+	} {
+		got, err := parseInferenceFields(func(key string) string { return test.fields[key] }, base, model)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed %+v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
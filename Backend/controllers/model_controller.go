@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var detectionModelCollection *mongo.Collection = configs.GetCollection(configs.DB, "models")
+
+var errModelNotFound = errors.New("model not found")
+
+// Name recorded on images detected with the server's configured weights
+// rather than a registered model.
+const builtinModelName = "default"
+
+// resolveDetectionModel finds the model an upload asked for. ref is either
+// "name", which picks the newest version, or "name:version". An empty ref
+// picks the model flagged as default, or nil when none is, meaning the
+// server's configured weights.
+func resolveDetectionModel(ctx context.Context, ref string) (*models.DetectionModel, error) {
+	filter := bson.M{"isDefault": true}
+	if ref != "" {
+		name, version, hasVersion := strings.Cut(ref, ":")
+		filter = bson.M{"name": name}
+		if hasVersion {
+			filter["version"] = version
+		}
+	}
+
+	var model models.DetectionModel
+	opts := options.FindOne().SetSort(bson.M{"createdAt": -1})
+	err := detectionModelCollection.FindOne(ctx, filter, opts).Decode(&model)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if ref == "" {
+			return nil, nil
+		}
+		return nil, errModelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// detectionModelOf returns the registered model image was last detected
+// with, or nil when it used the server's configured weights.
+func detectionModelOf(ctx context.Context, image models.Image) (*models.DetectionModel, error) {
+	if image.ModelID.IsZero() {
+		return nil, nil
+	}
+
+	var model models.DetectionModel
+	err := detectionModelCollection.FindOne(ctx, bson.M{"_id": image.ModelID}).Decode(&model)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errModelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// applyDetectionModel records on image which model its result will come from.
+func applyDetectionModel(image *models.Image, model *models.DetectionModel) {
+	if model == nil {
+		image.ModelID = primitive.NilObjectID
+		image.Model = builtinModelName
+		image.ModelVersion = filepath.Base(configs.YoloV5WeightsPath())
+		return
+	}
+	image.ModelID = model.ID
+	image.Model = model.Name
+	image.ModelVersion = model.Version
+}
+
+func CreateModel(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var model models.DetectionModel
+	if err := c.ShouldBindJSON(&model); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": err.Error()})
+		return
+	}
+
+	if validationErr := validateUser.Struct(&model); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": validationErr.Error()})
+		return
+	}
+
+	if strings.Contains(model.Name, ":") {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Model name must not contain ':'"})
+		return
+	}
+
+	if _, err := os.Stat(model.WeightsPath); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Weights file not found"})
+		return
+	}
+
+	count, err := detectionModelCollection.CountDocuments(ctx, bson.M{"name": model.Name, "version": model.Version})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding model"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Model version already registered"})
+		return
+	}
+
+	if model.Classes == nil {
+		model.Classes = []string{}
+	}
+	model.ID = primitive.NewObjectID()
+	model.CreatedAt = time.Now()
+
+	if model.IsDefault {
+		if _, err := detectionModelCollection.UpdateMany(ctx, bson.M{"isDefault": true}, bson.M{"$set": bson.M{"isDefault": false}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating default model"})
+			return
+		}
+	}
+
+	if _, err := detectionModelCollection.InsertOne(ctx, model); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error registering model"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Model registered successfully", "data": model})
+}
+
+func GetAllModels(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if name := c.Query("name"); name != "" {
+		filter["name"] = name
+	}
+
+	cursor, err := detectionModelCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "createdAt", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	detectionModels := []models.DetectionModel{}
+	if err := cursor.All(ctx, &detectionModels); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	if !isAdmin(c) {
+		for i := range detectionModels {
+			hideWeightsPath(&detectionModels[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "counts": len(detectionModels), "data": detectionModels})
+}
+
+func GetModelByID(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	modelID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid model ID"})
+		return
+	}
+
+	var model models.DetectionModel
+	if err := detectionModelCollection.FindOne(ctx, bson.M{"_id": modelID}).Decode(&model); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Model not found"})
+		return
+	}
+
+	if !isAdmin(c) {
+		hideWeightsPath(&model)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": model})
+}
+
+// isAdmin reports whether the request was made by an admin.
+func isAdmin(c *gin.Context) bool {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+	return userData.Role == "admin"
+}
+
+// hideWeightsPath leaves a model's weights path out of its JSON. It is a path
+// on the server's disk, which only admins registering models need to see.
+func hideWeightsPath(model *models.DetectionModel) {
+	model.WeightsPath = ""
+}
+
+// UpdateModel changes a model's classes, thresholds or default flag. The
+// weights of a registered version never change; register a new version.
+func UpdateModel(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	modelID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid model ID"})
+		return
+	}
+
+	var updateReq models.DetectionModelUpdate
+	if err := c.ShouldBindJSON(&updateReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": err.Error()})
+		return
+	}
+
+	if validationErr := validateUser.Struct(&updateReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": validationErr.Error()})
+		return
+	}
+
+	if updateReq.IsDefault != nil && *updateReq.IsDefault {
+		if _, err := detectionModelCollection.UpdateMany(ctx, bson.M{"isDefault": true, "_id": bson.M{"$ne": modelID}}, bson.M{"$set": bson.M{"isDefault": false}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating default model"})
+			return
+		}
+	}
+
+	var model models.DetectionModel
+	err = detectionModelCollection.FindOneAndUpdate(ctx, bson.M{"_id": modelID}, bson.M{"$set": updateReq},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&model)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Model not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Model updated successfully", "data": model})
+}
+
+func DeleteModel(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	modelID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid model ID"})
+		return
+	}
+
+	pending, err := imageCollection.CountDocuments(ctx, bson.M{
+		"modelId": modelID,
+		"status":  bson.M{"$in": []string{models.StatusPending, models.StatusProcessing}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding images"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Model is still in use by pending detections"})
+		return
+	}
+
+	result, err := detectionModelCollection.DeleteOne(ctx, bson.M{"_id": modelID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting model"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Model not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Model deleted successfully"})
+}
@@ -0,0 +1,67 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetModelsHidesWeightsPath(t *testing.T) {
+	ctx := context.Background()
+	model := models.DetectionModel{
+		ID:          primitive.NewObjectID(),
+		Name:        "weights-" + primitive.NewObjectID().Hex(),
+		Version:     "1",
+		WeightsPath: "/srv/weights/best.pt",
+		Classes:     []string{"cat"},
+		CreatedAt:   time.Now(),
+	}
+	if _, err := detectionModelCollection.InsertOne(ctx, model); err != nil {
+		t.Fatalf("insert model: %v", err)
+	}
+	t.Cleanup(func() { detectionModelCollection.DeleteOne(ctx, bson.M{"_id": model.ID}) })
+
+	for _, role := range []string{"user", "admin"} {
+		router := gin.New()
+		routes := router.Group("/models", func(c *gin.Context) { c.Set("user", models.User{ID: primitive.NewObjectID(), Role: role}) })
+		routes.GET("", GetAllModels)
+		routes.GET("/:id", GetModelByID)
+
+		want := ""
+		if role == "admin" {
+			want = model.WeightsPath
+		}
+
+		recorder := serve(router, httptest.NewRequest(http.MethodGet, "/models?name="+model.Name, nil))
+		var list struct {
+			Data []models.DetectionModel `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil || len(list.Data) != 1 {
+			t.Fatalf("%s: list: status %d, body %s", role, recorder.Code, recorder.Body)
+		}
+		if got := list.Data[0].WeightsPath; got != want {
+			t.Errorf("%s: listed weights path %q, want %q", role, got, want)
+		}
+
+		recorder = serve(router, httptest.NewRequest(http.MethodGet, "/models/"+model.ID.Hex(), nil))
+		var one struct {
+			Data models.DetectionModel `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &one); err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("%s: get: status %d, body %s", role, recorder.Code, recorder.Body)
+		}
+		if got := one.Data.WeightsPath; got != want {
+			t.Errorf("%s: weights path %q, want %q", role, got, want)
+		}
+	}
+}
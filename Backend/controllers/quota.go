@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// userQuota is the quota that applies to user: the override an admin set,
+// or else the default for their role.
+func userQuota(user models.User) models.Quota {
+	if user.QuotaOverride != nil {
+		return *user.QuotaOverride
+	}
+	return models.Quota{
+		MaxBytes:  configs.QuotaBytes(user.Role),
+		MaxImages: configs.QuotaImages(user.Role),
+	}
+}
+
+// quotaFilter matches the user when adding bytes and images to their usage
+// keeps it within quota.
+func quotaFilter(userID primitive.ObjectID, quota models.Quota, bytes, images int64) bson.M {
+	filter := bson.M{"_id": userID}
+	if quota.MaxBytes > 0 {
+		filter["usage.bytes"] = bson.M{"$not": bson.M{"$gt": quota.MaxBytes - bytes}}
+	}
+	if quota.MaxImages > 0 {
+		filter["usage.images"] = bson.M{"$not": bson.M{"$gt": quota.MaxImages - images}}
+	}
+	return filter
+}
+
+// checkQuota tells whether the user has room for an image of size bytes
+// without taking it, for uploads that only arrive later.
+func checkQuota(ctx context.Context, user models.User, size int64) error {
+	quota := userQuota(user)
+	err := userCollection.FindOne(ctx, quotaFilter(user.ID, quota, size, 1)).Err()
+	if err == mongo.ErrNoDocuments {
+		return quotaExceeded(ctx, user.ID, quota, size)
+	}
+	return err
+}
+
+// reserveUsage adds an image of size bytes to the user's usage, failing
+// with a quota error when that would go over their quota. The check and
+// the update are one operation, so concurrent uploads cannot overshoot.
+func reserveUsage(ctx context.Context, user models.User, size int64) error {
+	quota := userQuota(user)
+	result, err := userCollection.UpdateOne(ctx, quotaFilter(user.ID, quota, size, 1),
+		bson.M{"$inc": bson.M{"usage.bytes": size, "usage.images": 1}})
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, uploadDatabaseFailed, "Failed to update storage usage", nil}
+	}
+	if result.MatchedCount == 0 {
+		return quotaExceeded(ctx, user.ID, quota, size)
+	}
+	return nil
+}
+
+// releaseUsage takes deleted images off the user's usage. Images uploaded
+// before usage was counted were never added, so it stops at zero.
+func releaseUsage(ctx context.Context, userID primitive.ObjectID, bytes, images int64) error {
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"usage.bytes":  bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$usage.bytes", 0}}, bytes}}}},
+			"usage.images": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$usage.images", 0}}, images}}}},
+		}}},
+	})
+	return err
+}
+
+func quotaExceeded(ctx context.Context, userID primitive.ObjectID, quota models.Quota, size int64) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return &uploadError{http.StatusInternalServerError, uploadDatabaseFailed, "Failed to read storage usage", nil}
+	}
+	details := gin.H{"usage": user.Usage, "quota": quota, "size": size}
+	if quota.MaxImages > 0 && user.Usage.Images+1 > quota.MaxImages {
+		return &uploadError{http.StatusForbidden, uploadQuotaExceeded, "Image count quota exceeded", details}
+	}
+	return &uploadError{http.StatusForbidden, uploadQuotaExceeded, "Storage quota exceeded", details}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/render"
+	"github.com/gin-gonic/gin"
+)
+
+const maxRenderLineWidth = 50
+
+// parseRenderOptions reads the drawing options of a render request:
+// classes as a comma separated list, minConfidence between 0 and 1,
+// lineWidth in pixels, labels=false to leave out captions and format jpeg
+// or png.
+func parseRenderOptions(c *gin.Context) (render.Options, string, bool) {
+	options := render.Default
+	for _, class := range strings.Split(c.Query("classes"), ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			options.Classes = append(options.Classes, class)
+		}
+	}
+
+	if value := c.Query("minConfidence"); value != "" {
+		confidence, err := strconv.ParseFloat(value, 32)
+		if err != nil || confidence < 0 || confidence > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "minConfidence must be between 0 and 1"})
+			return options, "", false
+		}
+		options.MinConfidence = float32(confidence)
+	}
+
+	if value := c.Query("lineWidth"); value != "" {
+		lineWidth, err := strconv.Atoi(value)
+		if err != nil || lineWidth < 1 || lineWidth > maxRenderLineWidth {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "lineWidth must be between 1 and " + strconv.Itoa(maxRenderLineWidth)})
+			return options, "", false
+		}
+		options.LineWidth = lineWidth
+	}
+
+	if value := c.Query("labels"); value != "" {
+		labels, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "labels must be true or false"})
+			return options, "", false
+		}
+		options.Labels = labels
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", render.FormatJPEG))
+	if format == "jpg" {
+		format = render.FormatJPEG
+	}
+	if format != render.FormatJPEG && format != render.FormatPNG {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "format must be jpeg or png"})
+		return options, "", false
+	}
+	return options, format, true
+}
+
+// RenderImage draws the image's current result onto its original as the
+// query asks, without storing anything.
+func RenderImage(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	options, format, ok := parseRenderOptions(c)
+	if !ok {
+		return
+	}
+	image, ok := findOwnedImage(c, ctx, "access")
+	if !ok {
+		return
+	}
+
+	original, err := loadImage(ctx, image.ImagePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error reading image file"})
+		return
+	}
+	data, err := render.Encode(render.Draw(original, image.Result, options), format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error rendering image"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, render.ContentType(format), data)
+}
@@ -0,0 +1,58 @@
+//go:build integration
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/render"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRenderOptions(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		want    render.Options
+		format  string
+		wantErr bool
+	}{
+		{query: "", want: render.Default, format: render.FormatJPEG},
+		{
+			query:  "classes=cat,%20dog,&minConfidence=0.5&lineWidth=4&labels=false&format=PNG",
+			want:   render.Options{Classes: []string{"cat", "dog"}, MinConfidence: 0.5, LineWidth: 4},
+			format: render.FormatPNG,
+		},
+		{query: "format=jpg", want: render.Default, format: render.FormatJPEG},
+		{query: "minConfidence=0", want: render.Default, format: render.FormatJPEG},
+		{query: "minConfidence=1.5", wantErr: true},
+		{query: "minConfidence=-0.1", wantErr: true},
+		{query: "minConfidence=high", wantErr: true},
+		{query: "lineWidth=0", wantErr: true},
+		{query: "lineWidth=1000", wantErr: true},
+		{query: "lineWidth=thin", wantErr: true},
+		{query: "labels=maybe", wantErr: true},
+		{query: "format=gif", wantErr: true},
+	} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/images/id/render?"+test.query, nil)
+
+		options, format, ok := parseRenderOptions(c)
+		if test.wantErr {
+			if ok || recorder.Code != http.StatusBadRequest {
+				t.Errorf("%q: ok %v, status %d, want a 400", test.query, ok, recorder.Code)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: rejected with %s", test.query, recorder.Body)
+			continue
+		}
+		if !reflect.DeepEqual(options, test.want) || format != test.format {
+			t.Errorf("%q: %+v as %s, want %+v as %s", test.query, options, format, test.want, test.format)
+		}
+	}
+}
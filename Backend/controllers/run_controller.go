@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var detectionRunCollection *mongo.Collection = configs.GetCollection(configs.DB, "detection_runs")
+
+// findOwnedImage loads the image named by the :id parameter and checks that
+// it belongs to the requesting user, writing the error response otherwise.
+func findOwnedImage(c *gin.Context, ctx context.Context, action string) (models.Image, bool) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	var image models.Image
+	ImageID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid image ID"})
+		return image, false
+	}
+
+	err = imageCollection.FindOne(ctx, bson.M{"_id": ImageID}).Decode(&image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding image"})
+		return image, false
+	}
+
+	if userData.ID != image.User {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to " + action + " this image"})
+		return image, false
+	}
+	return image, true
+}
+
+// findImageRun loads the run of image with the given hex ID.
+func findImageRun(c *gin.Context, ctx context.Context, image models.Image, id string) (models.DetectionRun, bool) {
+	var run models.DetectionRun
+	runID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid run ID"})
+		return run, false
+	}
+
+	err = detectionRunCollection.FindOne(ctx, bson.M{"_id": runID, "image": image.ID}).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Run not found"})
+		return run, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding run"})
+		return run, false
+	}
+	return run, true
+}
+
+// deleteDetectionRuns removes every run of the given images together with
+// the annotated images they produced.
+func deleteDetectionRuns(ctx context.Context, imageIDs []primitive.ObjectID) error {
+	filter := bson.M{"image": bson.M{"$in": imageIDs}}
+	cursor, err := detectionRunCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"detectedImagePath": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []models.DetectionRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if run.DetectedImagePath == "" || run.DetectedImagePath == bson.TypeNull.String() {
+			continue
+		}
+		if err := storage.Default.Delete(ctx, run.DetectedImagePath); err != nil {
+			return err
+		}
+		if err := thumbnail.Remove(ctx, storage.Default, run.DetectedImagePath); err != nil {
+			return err
+		}
+	}
+
+	_, err = detectionRunCollection.DeleteMany(ctx, filter)
+	return err
+}
+
+// GetImageRuns lists every detection run of an image, newest first. The raw
+// detector output is left out; fetch a single run to see it.
+func GetImageRuns(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	image, ok := findOwnedImage(c, ctx, "access")
+	if !ok {
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"startedAt": -1}).SetProjection(bson.M{"rawOutput": 0})
+	cursor, err := detectionRunCollection.Find(ctx, bson.M{"image": image.ID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.DetectionRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	for i := range runs {
+		withRunURLs(&runs[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "counts": len(runs), "currentRun": image.CurrentRun, "data": runs})
+}
+
+func GetImageRun(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	image, ok := findOwnedImage(c, ctx, "access")
+	if !ok {
+		return
+	}
+
+	run, ok := findImageRun(c, ctx, image, c.Param("runId"))
+	if !ok {
+		return
+	}
+
+	withRunURLs(&run)
+	c.JSON(http.StatusOK, gin.H{"success": true, "current": run.ID == image.CurrentRun, "data": run})
+}
+
+// withRunURLs fills in the signed links run's files are served at.
+func withRunURLs(run *models.DetectionRun) {
+	run.DetectedImageURL = fileURL(run.DetectedImagePath)
+	run.DetectedThumbnailURL = fileURL(run.DetectedThumbnailPath)
+	run.DetectedPreviewURL = fileURL(run.DetectedPreviewPath)
+}
+
+// SetCurrentRun makes a finished run the result shown for its image.
+func SetCurrentRun(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	image, ok := findOwnedImage(c, ctx, "update")
+	if !ok {
+		return
+	}
+
+	run, ok := findImageRun(c, ctx, image, c.Param("runId"))
+	if !ok {
+		return
+	}
+
+	if run.Status != models.StatusSuccess && run.Status != models.StatusFail {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Run has not finished"})
+		return
+	}
+
+	set := bson.M{
+		"currentRun":            run.ID,
+		"modelId":               run.ModelID,
+		"model":                 run.Model,
+		"modelVersion":          run.ModelVersion,
+		"params":                run.Params,
+		"status":                run.Status,
+		"result":                run.Result,
+		"detectedImagePath":     run.DetectedImagePath,
+		"detectedThumbnailPath": run.DetectedThumbnailPath,
+		"detectedPreviewPath":   run.DetectedPreviewPath,
+		"detectedAt":            run.FinishedAt,
+	}
+	update := bson.M{"$set": set}
+	if run.Error != "" {
+		set["error"] = run.Error
+	} else {
+		update["$unset"] = bson.M{"error": ""}
+	}
+
+	// A run being detected will set its own result when it finishes, so the
+	// current run cannot change underneath it.
+	var updated models.Image
+	err := imageCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": image.ID, "status": bson.M{"$nin": []string{models.StatusPending, models.StatusProcessing}}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Detection is in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating image"})
+		return
+	}
+
+	events.PublishImageStatus(updated)
+	withURLs(&updated)
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Current run updated successfully", "data": updated})
+}
@@ -0,0 +1,463 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Resumable uploads implement the core tus 1.0.0 protocol with the creation
+// and termination extensions. A client creates an upload with POST, sends
+// the file with PATCH requests from the offset HEAD reports, and the
+// finished file goes through createImage like any other upload. Should that
+// fail, the file is kept and an empty PATCH at its length tries again.
+const (
+	tusVersion      = "1.0.0"
+	tusExtensions   = "creation,termination"
+	tusContentType  = "application/offset+octet-stream"
+	uploadRoutePath = "/images/uploads/"
+
+	// Completing an upload stores the whole file, which takes far longer
+	// than the database calls around it.
+	resumableCompleteTimeout = 2 * time.Minute
+)
+
+var resumableUploadCollection *mongo.Collection = configs.GetCollection(configs.DB, "uploads")
+
+// resumableLocks holds a mutex per upload so two PATCH requests can never
+// write the same file at once.
+var resumableLocks sync.Map
+
+func resumableUploadFile(id primitive.ObjectID) string {
+	return filepath.Join(configs.ResumableUploadDir(), id.Hex())
+}
+
+// checkTusVersion rejects requests from clients speaking another version of
+// the protocol.
+func checkTusVersion(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"success": false, "message": "Unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header, a comma separated
+// list of keys each followed by an optional base64 value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("metadata value of " + key + " is not base64")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// resumableImageUpload turns an upload's metadata into the image it
+// creates: filename, name, model, reuse, keepLocation and the inference
+// fields CreateImage reads from its form.
+func resumableImageUpload(ctx context.Context, metadata map[string]string) (imageUpload, error) {
+	field := func(key string) string { return metadata[key] }
+
+	detectionModel, err := resolveDetectionModel(ctx, field("model"))
+	if err != nil {
+		return imageUpload{}, err
+	}
+	params, err := parseInferenceFields(field, defaultInferenceParams(detectionModel), detectionModel)
+	if err != nil {
+		return imageUpload{}, err
+	}
+
+	name := field("name")
+	if name == "" {
+		name = strings.TrimSuffix(field("filename"), filepath.Ext(field("filename")))
+	}
+	if name == "" {
+		name = "untitled"
+	}
+	return imageUpload{
+		Name:         name,
+		Filename:     field("filename"),
+		Model:        detectionModel,
+		Params:       params,
+		Reuse:        field("reuse") == "true",
+		KeepLocation: field("keepLocation") == "true",
+	}, nil
+}
+
+// removeExpiredUploads deletes the parts of resumable uploads nobody
+// finished in time.
+func removeExpiredUploads(ctx context.Context) {
+	cursor, err := resumableUploadCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		log.Printf("failed to find expired uploads: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var expired []models.ResumableUpload
+	if err := cursor.All(ctx, &expired); err != nil {
+		log.Printf("failed to read expired uploads: %v", err)
+		return
+	}
+	for _, upload := range expired {
+		removeResumableUpload(ctx, upload.ID)
+	}
+}
+
+func removeResumableUpload(ctx context.Context, id primitive.ObjectID) {
+	if err := os.Remove(resumableUploadFile(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to remove upload %s: %v", id.Hex(), err)
+	}
+	if _, err := resumableUploadCollection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		log.Printf("failed to delete upload %s: %v", id.Hex(), err)
+	}
+	resumableLocks.Delete(id)
+}
+
+// findResumableUpload loads the upload named in the path, answering for
+// the caller when it does not exist, belongs to someone else or expired.
+func findResumableUpload(c *gin.Context, ctx context.Context) (models.ResumableUpload, bool) {
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	var upload models.ResumableUpload
+	uploadID, err := primitive.ObjectIDFromHex(c.Param("uploadId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Upload not found"})
+		return upload, false
+	}
+
+	err = resumableUploadCollection.FindOne(ctx, bson.M{"_id": uploadID}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Upload not found"})
+		return upload, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error finding upload"})
+		return upload, false
+	}
+
+	if userData.ID != upload.User {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to access this upload"})
+		return upload, false
+	}
+	if upload.ExpiresAt.Before(time.Now()) {
+		removeResumableUpload(ctx, upload.ID)
+		c.JSON(http.StatusGone, gin.H{"success": false, "message": "Upload has expired"})
+		return upload, false
+	}
+	return upload, true
+}
+
+// ResumableUploadOptions tells tus clients what the server supports.
+func ResumableUploadOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(configs.UploadMaxBytes(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateResumableUpload starts an upload of Upload-Length bytes. The image
+// settings come from Upload-Metadata and are checked now, so a bad model
+// or parameter fails before any of the file is sent.
+func CreateResumableUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Upload-Length must be given"})
+		return
+	}
+	if maxBytes := configs.UploadMaxBytes(); length > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "message": "Upload is too large", "code": uploadTooLarge,
+			"details": gin.H{"maxBytes": maxBytes}})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid Upload-Metadata", "error": err.Error()})
+		return
+	}
+	if _, err := resumableImageUpload(ctx, metadata); errors.Is(err, errModelNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown model"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if err := checkQuota(ctx, userData, length); err != nil {
+		var uploadErr *uploadError
+		if !errors.As(err, &uploadErr) {
+			uploadErr = &uploadError{http.StatusInternalServerError, uploadDatabaseFailed, "Failed to read storage usage", nil}
+		}
+		c.JSON(uploadErr.Status, uploadErr.response())
+		return
+	}
+
+	removeExpiredUploads(ctx)
+
+	upload := models.ResumableUpload{
+		ID:        primitive.NewObjectID(),
+		User:      userData.ID,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Duration(configs.ResumableUploadExpiry()) * time.Hour),
+	}
+	if err := os.MkdirAll(configs.ResumableUploadDir(), 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating upload"})
+		return
+	}
+	file, err := os.OpenFile(resumableUploadFile(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating upload"})
+		return
+	}
+	file.Close()
+
+	if _, err := resumableUploadCollection.InsertOne(ctx, upload); err != nil {
+		os.Remove(resumableUploadFile(upload.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating upload"})
+		return
+	}
+
+	c.Header("Location", uploadRoutePath+upload.ID.Hex())
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if length == 0 {
+		completeResumableUpload(c, userData, upload)
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+// GetResumableUploadOffset answers a tus HEAD request with how much of the
+// upload has arrived, which is where the client resumes.
+func GetResumableUploadOffset(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, ok := findResumableUpload(c, ctx)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// GetResumableUpload reports an upload as JSON, including the image it
+// became once complete or why it was rejected.
+func GetResumableUpload(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, ok := findResumableUpload(c, ctx)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": upload})
+}
+
+// PatchResumableUpload appends the request body to the upload at
+// Upload-Offset. Whatever arrives is kept even if the connection drops, so
+// the client can resume from there. The request that completes the file
+// also creates its image.
+func PatchResumableUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	user, _ := c.Get("user")
+	userData, _ := user.(models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"success": false, "message": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Upload-Offset must be given"})
+		return
+	}
+
+	upload, ok := findResumableUpload(c, ctx)
+	if !ok {
+		return
+	}
+
+	lock, _ := resumableLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		c.JSON(http.StatusLocked, gin.H{"success": false, "message": "Upload is already being written"})
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Reload under the lock, a request that just finished may have moved the
+	// offset.
+	if err := resumableUploadCollection.FindOne(ctx, bson.M{"_id": upload.ID}).Decode(&upload); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Upload not found"})
+		return
+	}
+	if offset != upload.Offset || !upload.Image.IsZero() {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Upload-Offset does not match the upload"})
+		return
+	}
+
+	file, err := os.OpenFile(resumableUploadFile(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error opening upload"})
+		return
+	}
+	written, copyErr := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(c.Request.Body, upload.Length-offset))
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	// The body is read until the client stops sending, which can take much
+	// longer than the timeout above.
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+	upload.Offset = offset + written
+	upload.ExpiresAt = time.Now().Add(time.Duration(configs.ResumableUploadExpiry()) * time.Hour)
+	_, err = resumableUploadCollection.UpdateOne(saveCtx, bson.M{"_id": upload.ID}, bson.M{"$set": bson.M{
+		"offset":    upload.Offset,
+		"expiresAt": upload.ExpiresAt,
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving upload"})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if copyErr != nil {
+		log.Printf("upload %s interrupted at %d bytes: %v", upload.ID.Hex(), upload.Offset, copyErr)
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Upload was interrupted"})
+		return
+	}
+	if upload.Offset == upload.Length {
+		completeResumableUpload(c, userData, upload)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// completeResumableUpload creates the image of a fully received upload and
+// records the outcome on the upload. The file is only removed once its image
+// exists, so a failed attempt can be retried.
+func completeResumableUpload(c *gin.Context, user models.User, upload models.ResumableUpload) {
+	ctx, cancel := context.WithTimeout(context.Background(), resumableCompleteTimeout)
+	defer cancel()
+	path := resumableUploadFile(upload.ID)
+
+	var created uploadResult
+	settings, err := resumableImageUpload(ctx, upload.Metadata)
+	if err == nil {
+		var src *os.File
+		src, err = os.Open(path)
+		if err == nil {
+			created, err = createImage(ctx, user, settings, src)
+			src.Close()
+		}
+	}
+
+	var update bson.M
+	var uploadErr *uploadError
+	switch {
+	case err == nil:
+		update = bson.M{"$set": bson.M{"image": created.Image.ID}, "$unset": bson.M{"error": "", "code": ""}}
+	case errors.As(err, &uploadErr):
+		update = bson.M{"$set": bson.M{"error": uploadErr.Message, "code": uploadErr.Code}}
+	default:
+		uploadErr = &uploadError{http.StatusBadRequest, uploadInvalidForm, err.Error(), nil}
+		update = bson.M{"$set": bson.M{"error": uploadErr.Message, "code": uploadErr.Code}}
+	}
+	if _, err := resumableUploadCollection.UpdateOne(ctx, bson.M{"_id": upload.ID}, update); err != nil {
+		log.Printf("failed to record outcome of upload %s: %v", upload.ID.Hex(), err)
+	}
+
+	if uploadErr != nil {
+		c.JSON(uploadErr.Status, uploadErr.response())
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to remove upload %s: %v", upload.ID.Hex(), err)
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Length, 10))
+	if c.Request.Method == http.MethodPost {
+		c.Status(http.StatusCreated)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteResumableUpload abandons an upload, the tus termination extension.
+func DeleteResumableUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, ok := findResumableUpload(c, ctx)
+	if !ok {
+		return
+	}
+
+	lock, _ := resumableLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		c.JSON(http.StatusLocked, gin.H{"success": false, "message": "Upload is already being written"})
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	removeResumableUpload(ctx, upload.ID)
+	c.Status(http.StatusNoContent)
+}
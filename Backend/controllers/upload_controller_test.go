@@ -0,0 +1,242 @@
+//go:build integration
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	for _, test := range []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{header: "", want: map[string]string{}},
+		{header: "  ", want: map[string]string{}},
+		{header: "filename dGVzdC5wbmc=", want: map[string]string{"filename": "test.png"}},
+		{header: "reuse", want: map[string]string{"reuse": ""}},
+		{header: "filename dGVzdC5wbmc=, reuse,name ", want: map[string]string{"filename": "test.png", "reuse": "", "name": ""}},
+		{header: "filename test.png", wantErr: true},
+		{header: "filename dGVzdC5wbmc=,,reuse", wantErr: true},
+		{header: ",", wantErr: true},
+	} {
+		got, err := parseUploadMetadata(test.header)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: parsed %v, want an error", test.header, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+// newUploadTest serves the upload routes as a new user, keeping the parts
+// of their uploads in a directory of the test's own.
+func newUploadTest(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Setenv("RESUMABLE_UPLOAD_DIR", t.TempDir())
+	user := newTestUser(t)
+	t.Cleanup(func() { resumableUploadCollection.DeleteMany(context.Background(), bson.M{"user": user.ID}) })
+	return newTestRouter(user)
+}
+
+func tusRequest(method string, path string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", tusContentType)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+// createUpload starts an upload of length bytes named tus and returns its
+// path along with the response.
+func createUpload(t *testing.T, router *gin.Engine, length int) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("tus.png")) +
+		",name " + base64.StdEncoding.EncodeToString([]byte("tus"))
+	recorder := serve(router, tusRequest(http.MethodPost, "/images/uploads", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": metadata,
+	}))
+	location := recorder.Header().Get("Location")
+	if !strings.HasPrefix(location, uploadRoutePath) {
+		t.Fatalf("create: status %d, location %q, body %s", recorder.Code, location, recorder.Body)
+	}
+	return location, recorder
+}
+
+func patchUpload(router *gin.Engine, path string, offset int, data []byte) *httptest.ResponseRecorder {
+	return serve(router, tusRequest(http.MethodPatch, path, data, map[string]string{"Upload-Offset": strconv.Itoa(offset)}))
+}
+
+func getUpload(t *testing.T, router *gin.Engine, path string) models.ResumableUpload {
+	t.Helper()
+	recorder := serve(router, httptest.NewRequest(http.MethodGet, path, nil))
+	var response struct {
+		Data models.ResumableUpload `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("get upload: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	return response.Data
+}
+
+func partsKept(t *testing.T, upload models.ResumableUpload) bool {
+	t.Helper()
+	_, err := os.Stat(resumableUploadFile(upload.ID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestResumableUpload(t *testing.T) {
+	router := newUploadTest(t)
+	data := testPNG(t)
+	half := len(data) / 2
+
+	path, recorder := createUpload(t, router, len(data))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = patchUpload(router, path, 0, data[:half])
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first part: status %d, offset %q, body %s", recorder.Code, recorder.Header().Get("Upload-Offset"), recorder.Body)
+	}
+
+	// The client lost track of what was sent and asks where to resume.
+	recorder = serve(router, tusRequest(http.MethodHead, path, nil, nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Upload-Length") != strconv.Itoa(len(data)) {
+		t.Fatalf("head: status %d, length %q", recorder.Code, recorder.Header().Get("Upload-Length"))
+	}
+	offset, err := strconv.Atoi(recorder.Header().Get("Upload-Offset"))
+	if err != nil || offset != half {
+		t.Fatalf("head: offset %q, want %d", recorder.Header().Get("Upload-Offset"), half)
+	}
+
+	// Sending from the wrong offset is refused, pointing at the right one.
+	recorder = patchUpload(router, path, 0, data)
+	if recorder.Code != http.StatusConflict || recorder.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("stale part: status %d, offset %q", recorder.Code, recorder.Header().Get("Upload-Offset"))
+	}
+
+	recorder = patchUpload(router, path, offset, data[offset:])
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Fatalf("last part: status %d, offset %q, body %s", recorder.Code, recorder.Header().Get("Upload-Offset"), recorder.Body)
+	}
+
+	upload := getUpload(t, router, path)
+	if upload.Image.IsZero() || upload.Error != "" {
+		t.Fatalf("upload finished without an image: %+v", upload)
+	}
+	image := waitForDetection(t, upload.Image)
+	if image.Status != models.StatusSuccess || image.ImageName != "tus" || image.Size != int64(len(data)) {
+		t.Errorf("image %q of %d bytes is %s", image.ImageName, image.Size, image.Status)
+	}
+	if partsKept(t, upload) {
+		t.Error("parts kept after the image was created")
+	}
+
+	if recorder := patchUpload(router, path, len(data), nil); recorder.Code != http.StatusConflict {
+		t.Errorf("patch of a finished upload: status %d", recorder.Code)
+	}
+}
+
+func TestResumableUploadLocked(t *testing.T) {
+	router := newUploadTest(t)
+	data := testPNG(t)
+	path, _ := createUpload(t, router, len(data))
+	upload := getUpload(t, router, path)
+
+	lock, _ := resumableLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	recorder := patchUpload(router, path, 0, data)
+	lock.(*sync.Mutex).Unlock()
+	if recorder.Code != http.StatusLocked {
+		t.Fatalf("patch while locked: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	if recorder := patchUpload(router, path, 0, data); recorder.Code != http.StatusNoContent {
+		t.Fatalf("patch once unlocked: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	waitForDetection(t, getUpload(t, router, path).Image)
+}
+
+func TestResumableUploadExpired(t *testing.T) {
+	router := newUploadTest(t)
+	data := testPNG(t)
+	path, _ := createUpload(t, router, len(data))
+	upload := getUpload(t, router, path)
+
+	_, err := resumableUploadCollection.UpdateOne(context.Background(), bson.M{"_id": upload.ID},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Minute)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder := patchUpload(router, path, 0, data); recorder.Code != http.StatusGone {
+		t.Fatalf("patch of an expired upload: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	if partsKept(t, upload) {
+		t.Error("parts of the expired upload kept")
+	}
+	count, err := resumableUploadCollection.CountDocuments(context.Background(), bson.M{"_id": upload.ID})
+	if err != nil || count != 0 {
+		t.Errorf("expired upload still recorded: %d, %v", count, err)
+	}
+	if recorder := serve(router, tusRequest(http.MethodHead, path, nil, nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("head of a removed upload: status %d", recorder.Code)
+	}
+}
+
+// An empty upload is complete as soon as it is created. It is no image, so
+// its creation fails, and the parts stay for another try.
+func TestResumableUploadEmpty(t *testing.T) {
+	router := newUploadTest(t)
+
+	path, recorder := createUpload(t, router, 0)
+	if recorder.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("create: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	upload := getUpload(t, router, path)
+	if !upload.Image.IsZero() || upload.Code != uploadUnsupportedType {
+		t.Errorf("upload %+v, want code %s", upload, uploadUnsupportedType)
+	}
+	if !partsKept(t, upload) {
+		t.Error("parts removed although no image was created")
+	}
+
+	if recorder := patchUpload(router, path, 0, nil); recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("retry: status %d, body %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/imagemeta"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Reasons an upload is rejected, reported as the error code.
+const (
+	uploadTooLarge         = "file_too_large"
+	uploadUnsupportedType  = "unsupported_type"
+	uploadInvalidImage     = "invalid_image"
+	uploadDimensionsTooBig = "dimensions_too_large"
+	uploadTooManyPixels    = "too_many_pixels"
+	uploadInvalidForm      = "invalid_form"
+	uploadInvalidArchive   = "invalid_archive"
+	uploadReadFailed       = "read_failed"
+	uploadTooManyFiles     = "too_many_files"
+	uploadStorageFailed    = "storage_failed"
+	uploadDatabaseFailed   = "database_failed"
+	uploadQuotaExceeded    = "quota_exceeded"
+)
+
+// Multipart forms keep at most multipartMemoryLimit bytes in memory and may
+// exceed the upload limit by multipartFormAllowance for their other fields.
+const (
+	multipartMemoryLimit   = 32 << 20
+	multipartFormAllowance = 1 << 20
+)
+
+// uploadFormats are the image formats detect.py can read, as named by
+// image.DecodeConfig, with the extension files of that format are stored
+// under.
+var uploadFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"bmp":  ".bmp",
+	"tiff": ".tif",
+	"webp": ".webp",
+}
+
+// uploadError is an upload failure together with the response it produces.
+// Code names the reason and Details carries the values that were checked.
+type uploadError struct {
+	Status  int
+	Code    string
+	Message string
+	Details gin.H
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// response is the JSON body describing e.
+func (e *uploadError) response() gin.H {
+	body := gin.H{"error": e.Message, "code": e.Code}
+	if e.Details != nil {
+		body["details"] = e.Details
+	}
+	return body
+}
+
+// parseUploadForm parses a multipart request whose body may be at most
+// limit bytes plus a little for the other form fields.
+func parseUploadForm(c *gin.Context, limit int64) *uploadError {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartFormAllowance)
+	err := c.Request.ParseMultipartForm(min(limit, multipartMemoryLimit))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &uploadError{http.StatusRequestEntityTooLarge, uploadTooLarge, "Upload is too large", gin.H{"maxBytes": limit}}
+	}
+	if err != nil {
+		return &uploadError{http.StatusBadRequest, uploadInvalidForm, "Failed to parse form data", nil}
+	}
+	return nil
+}
+
+// spooledUpload is an upload copied to a temporary file and hashed on the
+// way, so it can be checked before anything is stored.
+type spooledUpload struct {
+	file   *os.File
+	size   int64
+	digest string
+	format string
+	width  int
+	height int
+}
+
+// spoolUpload copies src to a temporary file, giving up once it is larger
+// than the configured limit. The caller must close the result.
+func spoolUpload(src io.Reader) (*spooledUpload, error) {
+	maxBytes := configs.UploadMaxBytes()
+
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to save image file", nil}
+	}
+	upload := &spooledUpload{file: file}
+
+	hash := sha256.New()
+	upload.size, err = io.Copy(file, io.TeeReader(io.LimitReader(src, maxBytes+1), hash))
+	if err != nil {
+		upload.Close()
+		return nil, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to save image file", nil}
+	}
+	if upload.size > maxBytes {
+		upload.Close()
+		return nil, &uploadError{http.StatusRequestEntityTooLarge, uploadTooLarge, "Image file is too large", gin.H{"maxBytes": maxBytes}}
+	}
+	upload.digest = hex.EncodeToString(hash.Sum(nil))
+	return upload, nil
+}
+
+// validate sniffs the upload's content type and decodes its header,
+// rejecting anything that is not a supported image or whose dimensions are
+// over the configured limits. Checking the pixel count before anything
+// decodes the full image keeps small files that expand to huge bitmaps out.
+func (u *spooledUpload) validate() error {
+	var head [512]byte
+	n, err := u.file.ReadAt(head[:], 0)
+	if err != nil && err != io.EOF {
+		return &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to read image file", nil}
+	}
+	sniffed := http.DetectContentType(head[:n])
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to read image file", nil}
+	}
+	config, format, err := image.DecodeConfig(u.file)
+	if err != nil {
+		if !strings.HasPrefix(sniffed, "image/") {
+			return &uploadError{http.StatusUnsupportedMediaType, uploadUnsupportedType, "File is not an image", gin.H{"contentType": sniffed}}
+		}
+		if errors.Is(err, image.ErrFormat) {
+			return &uploadError{http.StatusUnsupportedMediaType, uploadUnsupportedType, "Image format is not supported", gin.H{"contentType": sniffed}}
+		}
+		return &uploadError{http.StatusBadRequest, uploadInvalidImage, "Image could not be decoded", gin.H{"contentType": sniffed}}
+	}
+
+	// DetectContentType does not know TIFF, everything else must agree with
+	// the decoder.
+	if _, ok := uploadFormats[format]; !ok || (format != "tiff" && sniffed != "image/"+format) {
+		return &uploadError{http.StatusUnsupportedMediaType, uploadUnsupportedType, fmt.Sprintf("Image format %s is not supported", format),
+			gin.H{"contentType": sniffed, "format": format}}
+	}
+
+	maxWidth, maxHeight, maxPixels := configs.UploadMaxWidth(), configs.UploadMaxHeight(), configs.UploadMaxPixels()
+	dimensions := gin.H{"width": config.Width, "height": config.Height}
+	if config.Width <= 0 || config.Height <= 0 {
+		return &uploadError{http.StatusBadRequest, uploadInvalidImage, "Image has no pixels", dimensions}
+	}
+	if config.Width > maxWidth || config.Height > maxHeight {
+		dimensions["maxWidth"], dimensions["maxHeight"] = maxWidth, maxHeight
+		return &uploadError{http.StatusBadRequest, uploadDimensionsTooBig, "Image dimensions are too large", dimensions}
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		dimensions["maxPixels"] = maxPixels
+		return &uploadError{http.StatusBadRequest, uploadTooManyPixels, "Image has too many pixels", dimensions}
+	}
+
+	u.format, u.width, u.height = format, config.Width, config.Height
+	return nil
+}
+
+// normalize reads the upload's EXIF block and replaces the upload with its
+// upright copy, without location metadata unless keepLocation is set. The
+// digest and dimensions are those of what will be stored, so two uploads of
+// the same photo share a file only if they were stored the same way.
+func (u *spooledUpload) normalize(keepLocation bool) (*models.ImageMetadata, error) {
+	data, err := io.ReadAll(io.NewSectionReader(u.file, 0, u.size))
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to read image file", nil}
+	}
+
+	metadata := imagemeta.Extract(data, u.format)
+	normalized, format, changed, err := imagemeta.Normalize(data, u.format, keepLocation)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, uploadInvalidImage, "Image could not be decoded", gin.H{"format": u.format}}
+	}
+	if metadata != nil {
+		// Whatever was asked for, only a location that is still in the
+		// stored file is shared.
+		kept := imagemeta.Extract(normalized, format)
+		metadata.LocationShared = metadata.Location != nil && kept != nil && kept.Location != nil
+	}
+	if !changed {
+		return metadata, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(normalized))
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, uploadInvalidImage, "Image could not be decoded", gin.H{"format": u.format}}
+	}
+	if err := u.file.Truncate(0); err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to save image file", nil}
+	}
+	if _, err := u.file.WriteAt(normalized, 0); err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, uploadStorageFailed, "Failed to save image file", nil}
+	}
+	digest := sha256.Sum256(normalized)
+	u.size, u.digest = int64(len(normalized)), hex.EncodeToString(digest[:])
+	u.format, u.width, u.height = format, config.Width, config.Height
+	return metadata, nil
+}
+
+// ext is the extension the upload is stored under, chosen from its format
+// rather than the name the client sent.
+func (u *spooledUpload) ext() string {
+	return uploadFormats[u.format]
+}
+
+func (u *spooledUpload) Close() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
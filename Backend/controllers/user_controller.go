@@ -135,7 +135,12 @@ func GetMe(c *gin.Context) {
 		Tel:       userData.Tel,
 		Role:      userData.Role,
 		CreatedAt: userData.CreatedAt,
+
+		Usage:         userData.Usage,
+		QuotaOverride: userData.QuotaOverride,
 	}
+	quota := userQuota(userData)
+	userResponse.Quota = &quota
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": userResponse})
 }
@@ -250,3 +255,59 @@ func GetAllUser(c *gin.Context) {
 	counts := len(user)
 	c.JSON(http.StatusOK, gin.H{"success": true, "count": counts, "data": user})
 }
+
+// SetUserQuota gives a user a quota of their own in place of their role's.
+func SetUserQuota(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	var quota models.Quota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": err.Error()})
+		return
+	}
+	if validationErr := validateUser.Struct(&quota); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": validationErr.Error()})
+		return
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"quotaOverride": quota}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating quota"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Quota updated successfully", "data": quota})
+}
+
+// ClearUserQuota puts a user back on their role's quota.
+func ClearUserQuota(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"quotaOverride": ""}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating quota"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Quota reset to role default"})
+}
@@ -0,0 +1,146 @@
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+// Input is the image handed to a Detector. Either Path or Data must be set;
+// when both are set Path wins. Filename is only used to keep the original
+// extension for detectors that need to write the bytes to disk. JobID names
+// the run; detectors that write intermediate files keep them in a directory
+// of that name so concurrent runs never share output. WeightsPath selects
+// the model to run and falls back to the detector's configured weights.
+// Zero fields in Params fall back to detect.py's defaults.
+type Input struct {
+	Path        string
+	Data        []byte
+	Filename    string
+	JobID       string
+	WeightsPath string
+	Params      models.InferenceParams
+}
+
+// Result is what a Detector found in an image. AnnotatedImage holds the
+// encoded image with the boxes drawn on it and AnnotatedImageExt its file
+// extension including the leading dot. RawOutput is the detector's own
+// record of the boxes before they were grouped, kept for debugging.
+type Result struct {
+	Objects           []models.DetectedObject
+	AnnotatedImage    []byte
+	AnnotatedImageExt string
+	RawOutput         []byte
+}
+
+type Detector interface {
+	Detect(ctx context.Context, input Input) (*Result, error)
+}
+
+// Settings used by detect.py when none are given.
+const (
+	DefaultConfThreshold = 0.25
+	DefaultIoUThreshold  = 0.45
+	DefaultImageSize     = 640
+	DefaultMaxDetections = 1000
+)
+
+// Options holds the settings used to build a detector. Relative paths are
+// resolved against the working directory. ServerScriptPath, MaxPending and
+// MaxModels only apply to the sidecar, the ONNX fields only to the onnx detector.
+// ClassNames overrides the names stored in the ONNX model metadata.
+type Options struct {
+	PythonPath       string
+	ScriptPath       string
+	ServerScriptPath string
+	WeightsPath      string
+	OutputDir        string
+	MaxPending       int
+	MaxModels        int
+	ONNXModelPath    string
+	ONNXRuntimePath  string
+	ClassNames       []string
+}
+
+// New returns the detector registered under name.
+func New(name string, options Options) (Detector, error) {
+	switch name {
+	case "", "yolov5":
+		return NewYoloV5(options)
+	case "sidecar":
+		return NewSidecar(options)
+	case "onnx":
+		return NewONNX(options)
+	case "fake":
+		return &Fake{}, nil
+	default:
+		return nil, fmt.Errorf("unknown detector %q", name)
+	}
+}
+
+// weights resolves the weights to use for input against the detector's
+// configured default.
+func (input Input) weights(fallback string) (string, error) {
+	if input.WeightsPath == "" {
+		return fallback, nil
+	}
+	return filepath.Abs(input.WeightsPath)
+}
+
+// params returns input.Params with detect.py's defaults filled in.
+func (input Input) params() models.InferenceParams {
+	params := input.Params
+	if params.ConfThreshold == 0 {
+		params.ConfThreshold = DefaultConfThreshold
+	}
+	if params.IoUThreshold == 0 {
+		params.IoUThreshold = DefaultIoUThreshold
+	}
+	if params.ImageSize == 0 {
+		params.ImageSize = DefaultImageSize
+	}
+	if params.MaxDetections == 0 {
+		params.MaxDetections = DefaultMaxDetections
+	}
+	return params
+}
+
+// filterClasses keeps the detections whose class is in classes, or all of
+// them when classes is empty.
+func filterClasses(detections []rawDetection, classes []string) []rawDetection {
+	if len(classes) == 0 {
+		return detections
+	}
+	var kept []rawDetection
+	for _, detection := range detections {
+		if slices.Contains(classes, detection.ClassName) {
+			kept = append(kept, detection)
+		}
+	}
+	return kept
+}
+
+// rawOutput encodes detections the way detect.py writes them, for
+// detectors that have no output file of their own.
+func rawOutput(detections []rawDetection) []byte {
+	if detections == nil {
+		detections = []rawDetection{}
+	}
+	data, err := json.Marshal(detections)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (input Input) bytes() ([]byte, error) {
+	if input.Path != "" {
+		return os.ReadFile(input.Path)
+	}
+	return input.Data, nil
+}
@@ -0,0 +1,185 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+func TestNew(t *testing.T) {
+	options := Options{
+		PythonPath:  "/bin/sh",
+		ScriptPath:  "testdata/detect_stub.sh",
+		WeightsPath: "weights.pt",
+		OutputDir:   t.TempDir(),
+	}
+	for _, test := range []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "", want: "*detector.YoloV5"},
+		{name: "yolov5", want: "*detector.YoloV5"},
+		{name: "fake", want: "*detector.Fake"},
+		// Without the onnx build tag, or here without a model, onnx fails.
+		{name: "onnx", wantErr: true},
+		{name: "yolov8", wantErr: true},
+	} {
+		got, err := New(test.name, options)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("New(%q) = %T, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%q): %v", test.name, err)
+			continue
+		}
+		if typ := fmt.Sprintf("%T", got); typ != test.want {
+			t.Errorf("New(%q) = %s, want %s", test.name, typ, test.want)
+		}
+	}
+}
+
+func TestNewResolvesPaths(t *testing.T) {
+	got, err := New("yolov5", Options{PythonPath: "python", ScriptPath: "detect.py", WeightsPath: "weights.pt", OutputDir: "runs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := got.(*YoloV5)
+	for _, path := range []string{y.pythonPath, y.scriptPath, y.weightsPath, y.outputDir} {
+		if !filepath.IsAbs(path) {
+			t.Errorf("path %q is not absolute", path)
+		}
+	}
+}
+
+// fakeInputs are images the Fake detector finds boxes in, cats and dogs
+// among them.
+func fakeInputs(t *testing.T) []Input {
+	t.Helper()
+	var inputs []Input
+	for i := 0; len(inputs) < 8; i++ {
+		data := []byte(fmt.Sprintf("image %d", i))
+		result, err := (&Fake{}).Detect(context.Background(), Input{Data: data, Params: models.InferenceParams{ConfThreshold: 0.01}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Objects) > 0 {
+			inputs = append(inputs, Input{Data: data, Filename: "image.png"})
+		}
+	}
+	return inputs
+}
+
+func boxCount(result *Result) int {
+	count := 0
+	for _, object := range result.Objects {
+		count += len(object.Coordinates)
+	}
+	return count
+}
+
+func TestFakeIsDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	for i, input := range fakeInputs(t) {
+		first, err := (&Fake{}).Detect(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The same bytes from a file give the same boxes.
+		path := filepath.Join(dir, fmt.Sprintf("image%d.jpg", i))
+		if err := os.WriteFile(path, input.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		second, err := (&Fake{}).Detect(context.Background(), Input{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(first.Objects) != len(second.Objects) {
+			t.Fatalf("image %d: %v, then %v", i, first.Objects, second.Objects)
+		}
+		for j, object := range first.Objects {
+			other := second.Objects[j]
+			if object.Name != other.Name || len(object.Coordinates) != len(other.Coordinates) {
+				t.Fatalf("image %d: %v, then %v", i, first.Objects, second.Objects)
+			}
+			for k, box := range object.Coordinates {
+				box.Bounding_id = other.Coordinates[k].Bounding_id
+				if box != other.Coordinates[k] {
+					t.Errorf("image %d: box %+v, then %+v", i, box, other.Coordinates[k])
+				}
+			}
+			if !slices.Contains(FakeClasses, object.Name) {
+				t.Errorf("image %d: class %q is not one of %v", i, object.Name, FakeClasses)
+			}
+		}
+
+		if !bytes.Equal(first.AnnotatedImage, input.Data) {
+			t.Errorf("image %d: annotated image is not the input", i)
+		}
+		if first.AnnotatedImageExt != ".png" || second.AnnotatedImageExt != ".jpg" {
+			t.Errorf("image %d: extensions %q and %q, want .png and .jpg", i, first.AnnotatedImageExt, second.AnnotatedImageExt)
+		}
+		if len(first.RawOutput) == 0 {
+			t.Errorf("image %d: no raw output", i)
+		}
+	}
+}
+
+func TestFakeParams(t *testing.T) {
+	for i, input := range fakeInputs(t) {
+		all := input
+		all.Params = models.InferenceParams{ConfThreshold: 0.01}
+		result, err := (&Fake{}).Detect(context.Background(), all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := boxCount(result)
+
+		limited := all
+		limited.Params.MaxDetections = 1
+		if result, _ := (&Fake{}).Detect(context.Background(), limited); boxCount(result) > 1 {
+			t.Errorf("image %d: %d boxes with max detections 1", i, boxCount(result))
+		}
+
+		strict := all
+		strict.Params.ConfThreshold = 0.99
+		if result, _ := (&Fake{}).Detect(context.Background(), strict); boxCount(result) != 0 {
+			t.Errorf("image %d: %d boxes above confidence 0.99", i, boxCount(result))
+		}
+
+		cats := all
+		cats.Params.Classes = []string{"cat"}
+		result, _ = (&Fake{}).Detect(context.Background(), cats)
+		for _, object := range result.Objects {
+			if object.Name != "cat" {
+				t.Errorf("image %d: class %q kept when only cats were asked for", i, object.Name)
+			}
+		}
+		dogs := all
+		dogs.Params.Classes = []string{"dog"}
+		other, _ := (&Fake{}).Detect(context.Background(), dogs)
+		if boxCount(result)+boxCount(other) != total {
+			t.Errorf("image %d: %d cats and %d dogs of %d boxes", i, boxCount(result), boxCount(other), total)
+		}
+	}
+}
+
+func TestFakeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Fake{}).Detect(ctx, Input{Data: []byte("image")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Detect = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,66 @@
+package detector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+)
+
+// FakeClasses are the class names the Fake detector can report.
+var FakeClasses = []string{"cat", "dog"}
+
+// Fake is a deterministic Detector for tests and for running the server
+// without python. The boxes it reports are derived from a hash of the image
+// bytes, so the same image always yields the same result, and the annotated
+// image is the input unchanged.
+type Fake struct{}
+
+func (f *Fake) Detect(ctx context.Context, input Input) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := input.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	params := input.params()
+	digest := sha256.Sum256(data)
+	var detections []rawDetection
+
+	boxCount := min(int(digest[0]%4), params.MaxDetections)
+	for i := 0; i < boxCount; i++ {
+		seed := digest[1+i*6 : 7+i*6]
+		xMin := int(binary.BigEndian.Uint16(seed[0:2]) % 500)
+		yMin := int(binary.BigEndian.Uint16(seed[2:4]) % 500)
+		className := FakeClasses[int(seed[4])%len(FakeClasses)]
+		confidence := 0.25 + float32(seed[5]%75)/100
+		if confidence < params.ConfThreshold || (len(params.Classes) > 0 && !slices.Contains(params.Classes, className)) {
+			continue
+		}
+
+		var detection rawDetection
+		detection.ClassName = className
+		detection.Confidence = confidence
+		detection.BoundingBox.XMin = xMin
+		detection.BoundingBox.YMin = yMin
+		detection.BoundingBox.XMax = xMin + 20 + int(seed[5])
+		detection.BoundingBox.YMax = yMin + 20 + int(seed[4])
+		detections = append(detections, detection)
+	}
+
+	ext := filepath.Ext(input.Path)
+	if ext == "" {
+		ext = filepath.Ext(input.Filename)
+	}
+
+	return &Result{
+		Objects:           objectsFromDetections(detections),
+		AnnotatedImage:    data,
+		AnnotatedImageExt: ext,
+		RawOutput:         rawOutput(detections),
+	}, nil
+}
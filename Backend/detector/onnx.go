@@ -0,0 +1,236 @@
+//go:build onnx
+
+package detector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/TenJit/SE/Backend/render"
+	ort "github.com/yalue/onnxruntime_go"
+	_ "golang.org/x/image/webp"
+)
+
+var initEnvironment sync.Once
+var initEnvironmentErr error
+
+// ONNX runs yolov5 models exported with `export.py --include onnx` in
+// process on the CPU through onnxruntime. Build with `-tags onnx`. Each model
+// is loaded the first time it is requested and kept for later runs.
+type ONNX struct {
+	modelPath  string
+	classNames []string
+
+	mu       sync.Mutex
+	sessions map[string]*onnxSession
+}
+
+type onnxSession struct {
+	mu         sync.Mutex
+	session    *ort.AdvancedSession
+	input      *ort.Tensor[float32]
+	output     *ort.Tensor[float32]
+	inputSize  int
+	rows       int
+	cols       int
+	classNames []string
+}
+
+func NewONNX(options Options) (*ONNX, error) {
+	initEnvironment.Do(func() {
+		if options.ONNXRuntimePath != "" {
+			ort.SetSharedLibraryPath(options.ONNXRuntimePath)
+		}
+		initEnvironmentErr = ort.InitializeEnvironment()
+	})
+	if initEnvironmentErr != nil {
+		return nil, fmt.Errorf("failed to initialize onnxruntime: %w", initEnvironmentErr)
+	}
+
+	modelPath, err := filepath.Abs(options.ONNXModelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", options.ONNXModelPath, err)
+	}
+
+	o := &ONNX{
+		modelPath:  modelPath,
+		classNames: options.ClassNames,
+		sessions:   make(map[string]*onnxSession),
+	}
+	if _, err := o.session(modelPath); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// session returns the loaded model at modelPath, loading it if needed.
+func (o *ONNX) session(modelPath string) (*onnxSession, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if session, ok := o.sessions[modelPath]; ok {
+		return session, nil
+	}
+
+	classNames := o.classNames
+	if modelPath != o.modelPath {
+		classNames = nil
+	}
+	session, err := newONNXSession(modelPath, classNames)
+	if err != nil {
+		return nil, err
+	}
+	o.sessions[modelPath] = session
+	return session, nil
+}
+
+func newONNXSession(modelPath string, classNames []string) (*onnxSession, error) {
+	inputs, outputs, err := ort.GetInputOutputInfo(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read onnx model: %w", err)
+	}
+	if len(inputs) != 1 || len(outputs) < 1 {
+		return nil, errors.New("onnx model must have one input and at least one output")
+	}
+	inputShape := inputs[0].Dimensions
+	outputShape := outputs[0].Dimensions
+	if len(inputShape) != 4 || inputShape[2] != inputShape[3] || len(outputShape) != 3 {
+		return nil, fmt.Errorf("unexpected onnx model shapes %v -> %v, export with a fixed square --img size", inputShape, outputShape)
+	}
+
+	if len(classNames) == 0 {
+		classNames, err = onnxClassNames(modelPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	input, err := ort.NewEmptyTensor[float32](ort.NewShape(1, 3, inputShape[2], inputShape[3]))
+	if err != nil {
+		return nil, err
+	}
+	output, err := ort.NewEmptyTensor[float32](ort.NewShape(1, outputShape[1], outputShape[2]))
+	if err != nil {
+		input.Destroy()
+		return nil, err
+	}
+	session, err := ort.NewAdvancedSession(modelPath,
+		[]string{inputs[0].Name}, []string{outputs[0].Name},
+		[]ort.Value{input}, []ort.Value{output}, nil)
+	if err != nil {
+		input.Destroy()
+		output.Destroy()
+		return nil, fmt.Errorf("failed to create onnx session: %w", err)
+	}
+
+	return &onnxSession{
+		session:    session,
+		input:      input,
+		output:     output,
+		inputSize:  int(inputShape[2]),
+		rows:       int(outputShape[1]),
+		cols:       int(outputShape[2]),
+		classNames: classNames,
+	}, nil
+}
+
+func (o *ONNX) Detect(ctx context.Context, input Input) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	modelPath, err := input.weights(o.modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", input.WeightsPath, err)
+	}
+	model, err := o.session(modelPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := input.bytes()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	params := input.params()
+	if params.ImageSize != model.inputSize {
+		return nil, fmt.Errorf("onnx model was exported for image size %d, not %d", model.inputSize, params.ImageSize)
+	}
+
+	// The session owns a single pair of input and output tensors.
+	model.mu.Lock()
+	info := letterbox(img, model.inputSize, model.input.GetData())
+	if err := model.session.Run(); err != nil {
+		model.mu.Unlock()
+		return nil, fmt.Errorf("onnx inference failed: %w", err)
+	}
+	boxes := decodePredictions(model.output.GetData(), model.rows, model.cols, float64(params.ConfThreshold))
+	model.mu.Unlock()
+
+	boxes = filterBoxClasses(boxes, model.classNames, params.Classes)
+	boxes = nonMaxSuppression(boxes, float64(params.IoUThreshold), params.MaxDetections)
+	scaleBoxes(boxes, info, width, height)
+
+	// Drawn the way /render and edited images are, so a class has the same
+	// colour everywhere.
+	detections := boxesToDetections(boxes, model.classNames)
+	objects := objectsFromDetections(detections)
+	annotated, err := render.Encode(render.Draw(img, objects, render.Default), render.FormatJPEG)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode detected image: %w", err)
+	}
+
+	return &Result{
+		Objects:           objects,
+		AnnotatedImage:    annotated,
+		AnnotatedImageExt: ".jpg",
+		RawOutput:         rawOutput(detections),
+	}, nil
+}
+
+// onnxClassNames reads the class names export.py stores in the model
+// metadata as a python dict such as {0: 'cat', 1: 'dog'}.
+func onnxClassNames(modelPath string) ([]string, error) {
+	metadata, err := ort.GetModelMetadata(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read onnx model metadata: %w", err)
+	}
+	defer metadata.Destroy()
+
+	names, ok, err := metadata.LookupCustomMetadataMap("names")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("onnx model has no class names, set ONNX_CLASSES")
+	}
+
+	matches := regexp.MustCompile(`(\d+):\s*'([^']*)'`).FindAllStringSubmatch(names, -1)
+	sort.Slice(matches, func(i, j int) bool {
+		iNum, _ := strconv.Atoi(matches[i][1])
+		jNum, _ := strconv.Atoi(matches[j][1])
+		return iNum < jNum
+	})
+
+	classNames := make([]string, 0, len(matches))
+	for _, match := range matches {
+		classNames = append(classNames, match[2])
+	}
+	return classNames, nil
+}
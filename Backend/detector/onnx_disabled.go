@@ -0,0 +1,11 @@
+//go:build !onnx
+
+package detector
+
+import "errors"
+
+// NewONNX is only available when the server is built with `-tags onnx`,
+// which links against onnxruntime.
+func NewONNX(options Options) (Detector, error) {
+	return nil, errors.New("onnx detector is not available, rebuild with -tags onnx")
+}
@@ -0,0 +1,187 @@
+//go:build onnx
+
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The golden file holds what detect.py found in every image under
+// public/images, so the ONNX detector is checked against the reference
+// implementation rather than against itself. Regenerate it after changing
+// the weights with
+//
+//	go test -tags onnx ./detector/ -run TestONNXGolden -update
+//
+// which runs detect.py with YOLOV5_PYTHON and YOLOV5_WEIGHTS, and export
+// ONNX_MODEL from the same weights.
+var update = flag.Bool("update", false, "regenerate testdata/onnx_golden.json with detect.py")
+
+const (
+	goldenPath   = "testdata/onnx_golden.json"
+	goldenImages = "../public/images"
+
+	// ONNX and PyTorch differ in float rounding and image resizing, so
+	// boxes only need to overlap closely and scores to be near.
+	goldenMinIoU          = 0.9
+	goldenConfidenceDelta = 0.05
+)
+
+func TestONNXGolden(t *testing.T) {
+	images, err := filepath.Glob(filepath.Join(goldenImages, "*"))
+	if err != nil || len(images) == 0 {
+		t.Fatalf("no images under %s", goldenImages)
+	}
+	slices.Sort(images)
+
+	if *update {
+		writeGolden(t, images)
+	}
+
+	modelPath := envOr("ONNX_MODEL", "../imagedetection/yolov5s-cat-dog.onnx")
+	if _, err := os.Stat(modelPath); err != nil {
+		t.Skipf("no ONNX model at %s: %v", modelPath, err)
+	}
+	o, err := NewONNX(Options{ONNXModelPath: modelPath, ONNXRuntimePath: os.Getenv("ONNXRUNTIME_LIB")})
+	if err != nil {
+		t.Skipf("no ONNX runtime: %v", err)
+	}
+
+	data, err := os.ReadFile(goldenPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("no %s, generate it with -update", goldenPath)
+	}
+	if err != nil {
+		t.Fatalf("read %s: %v", goldenPath, err)
+	}
+	var golden map[string][]models.DetectedObject
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("decode %s: %v", goldenPath, err)
+	}
+
+	for _, path := range images {
+		name := filepath.Base(path)
+		want, ok := golden[name]
+		if !ok {
+			t.Errorf("%s: not in %s, regenerate it with -update", name, goldenPath)
+			continue
+		}
+		result, err := o.Detect(context.Background(), Input{Path: path})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		compareObjects(t, name, result.Objects, want)
+	}
+}
+
+// writeGolden runs detect.py on images and stores its boxes as the golden
+// file.
+func writeGolden(t *testing.T, images []string) {
+	t.Helper()
+	y, err := NewYoloV5(Options{
+		PythonPath:  envOr("YOLOV5_PYTHON", "../imagedetection/yolov5/yolov5_venv/bin/python"),
+		ScriptPath:  envOr("YOLOV5_DETECT_SCRIPT", "../imagedetection/yolov5/detect.py"),
+		WeightsPath: envOr("YOLOV5_WEIGHTS", "../imagedetection/yolov5s-cat-dog.pt"),
+		OutputDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewYoloV5: %v", err)
+	}
+
+	golden := make(map[string][]models.DetectedObject)
+	for _, path := range images {
+		result, err := y.Detect(context.Background(), Input{Path: path})
+		if err != nil {
+			t.Fatalf("detect.py on %s: %v", path, err)
+		}
+		for _, object := range result.Objects {
+			for i := range object.Coordinates {
+				object.Coordinates[i].Bounding_id = primitive.NilObjectID
+			}
+		}
+		golden[filepath.Base(path)] = result.Objects
+	}
+
+	data, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(goldenPath, append(data, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compareObjects matches every expected box to a distinct box of the same
+// class found by the ONNX detector, and reports boxes left over on either
+// side.
+func compareObjects(t *testing.T, name string, got, want []models.DetectedObject) {
+	t.Helper()
+	gotBoxes := make(map[string][]models.Coordinate)
+	for _, object := range got {
+		gotBoxes[object.Name] = append(gotBoxes[object.Name], object.Coordinates...)
+	}
+
+	for _, object := range want {
+		candidates := gotBoxes[object.Name]
+		for _, box := range object.Coordinates {
+			best, bestIoU := -1, 0.0
+			for i, candidate := range candidates {
+				if iou := coordinateIoU(box, candidate); iou > bestIoU {
+					best, bestIoU = i, iou
+				}
+			}
+			if best < 0 || bestIoU < goldenMinIoU {
+				t.Errorf("%s: %s box %s not found, best IoU %.2f", name, object.Name, describeBox(box), bestIoU)
+				continue
+			}
+			if delta := math.Abs(float64(candidates[best].Confidence - box.Confidence)); delta > goldenConfidenceDelta {
+				t.Errorf("%s: %s box %s confidence %.3f, want %.3f", name, object.Name, describeBox(box), candidates[best].Confidence, box.Confidence)
+			}
+			candidates = slices.Delete(candidates, best, best+1)
+		}
+		gotBoxes[object.Name] = candidates
+	}
+
+	for class, boxes := range gotBoxes {
+		for _, box := range boxes {
+			t.Errorf("%s: unexpected %s box %s confidence %.3f", name, class, describeBox(box), box.Confidence)
+		}
+	}
+}
+
+func coordinateIoU(a, b models.Coordinate) float64 {
+	width := min(a.X_max, b.X_max) - max(a.X_min, b.X_min)
+	height := min(a.Y_max, b.Y_max) - max(a.Y_min, b.Y_min)
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	intersection := float64(width * height)
+	areaA := float64((a.X_max - a.X_min) * (a.Y_max - a.Y_min))
+	areaB := float64((b.X_max - b.X_min) * (b.Y_max - b.Y_min))
+	return intersection / (areaA + areaB - intersection)
+}
+
+func describeBox(box models.Coordinate) string {
+	return fmt.Sprintf("(%d,%d)-(%d,%d)", box.X_min, box.Y_min, box.X_max, box.Y_max)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
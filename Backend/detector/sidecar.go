@@ -0,0 +1,484 @@
+package detector
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrSidecarUnavailable = errors.New("inference sidecar is not running")
+
+const maxFrameSize = 16 << 20
+
+// sidecarTiming is how often the worker is checked and how quickly it is
+// restarted.
+type sidecarTiming struct {
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+	// The first ping also waits for the model to load.
+	startupTimeout  time.Duration
+	minRestartDelay time.Duration
+	maxRestartDelay time.Duration
+}
+
+var defaultSidecarTiming = sidecarTiming{
+	healthCheckInterval: 30 * time.Second,
+	healthCheckTimeout:  10 * time.Second,
+	startupTimeout:      5 * time.Minute,
+	minRestartDelay:     time.Second,
+	maxRestartDelay:     30 * time.Second,
+}
+
+// Sidecar keeps one python inference worker (imagedetection/inference_server.py)
+// running with the model loaded and sends it detection requests over
+// stdin/stdout. The worker is restarted whenever it exits or stops answering
+// health checks, and at most MaxPending requests are in flight at once;
+// further callers wait for a free slot.
+type Sidecar struct {
+	pythonPath  string
+	scriptPath  string
+	weightsPath string
+	outputDir   string
+	maxModels   int
+	timing      sidecarTiming
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	current *sidecarProcess
+	running chan struct{}
+	stopped chan struct{}
+	stop    sync.Once
+}
+
+type sidecarProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+	nextID  atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan sidecarResponse
+	closed  bool
+}
+
+type sidecarRequest struct {
+	ID            uint64   `json:"id"`
+	Type          string   `json:"type"`
+	Weights       string   `json:"weights,omitempty"`
+	Source        string   `json:"source,omitempty"`
+	Output        string   `json:"output,omitempty"`
+	ImageSize     int      `json:"img,omitempty"`
+	ConfThreshold float32  `json:"conf,omitempty"`
+	IoUThreshold  float32  `json:"iou,omitempty"`
+	MaxDetections int      `json:"max_det,omitempty"`
+	Classes       []string `json:"classes,omitempty"`
+}
+
+type sidecarResponse struct {
+	ID         uint64         `json:"id"`
+	OK         bool           `json:"ok"`
+	Error      string         `json:"error,omitempty"`
+	Detections []rawDetection `json:"detections,omitempty"`
+}
+
+func NewSidecar(options Options) (*Sidecar, error) {
+	return newSidecar(options, defaultSidecarTiming)
+}
+
+func newSidecar(options Options, timing sidecarTiming) (*Sidecar, error) {
+	paths := []string{options.PythonPath, options.ServerScriptPath, options.WeightsPath, options.OutputDir}
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path of %q: %w", path, err)
+		}
+		paths[i] = absPath
+	}
+
+	maxPending := options.MaxPending
+	if maxPending < 1 {
+		maxPending = 1
+	}
+
+	s := &Sidecar{
+		pythonPath:  paths[0],
+		scriptPath:  paths[1],
+		weightsPath: paths[2],
+		outputDir:   paths[3],
+		maxModels:   options.MaxModels,
+		timing:      timing,
+		slots:       make(chan struct{}, maxPending),
+		running:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go s.supervise()
+	return s, nil
+}
+
+func (s *Sidecar) Detect(ctx context.Context, input Input) (*Result, error) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.slots }()
+
+	sourcePath := input.Path
+	if sourcePath == "" {
+		tempFile, err := os.CreateTemp("", "detect-*"+filepath.Ext(input.Filename))
+		if err != nil {
+			return nil, errors.New("failed to create temporary image file")
+		}
+		defer os.Remove(tempFile.Name())
+
+		_, err = tempFile.Write(input.Data)
+		tempFile.Close()
+		if err != nil {
+			return nil, errors.New("failed to write temporary image file")
+		}
+		sourcePath = tempFile.Name()
+	}
+	sourcePath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", sourcePath, err)
+	}
+
+	weightsPath, err := input.weights(s.weightsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", input.WeightsPath, err)
+	}
+
+	jobID := input.JobID
+	if jobID == "" {
+		jobID = primitive.NewObjectID().Hex()
+	}
+	runDir := filepath.Join(s.outputDir, jobID)
+	if err := os.RemoveAll(runDir); err != nil {
+		return nil, errors.New("failed to clear detection output folder")
+	}
+	defer os.RemoveAll(runDir)
+
+	outputPath := filepath.Join(runDir, filepath.Base(sourcePath))
+
+	proc, err := s.process(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params := input.params()
+	response, err := proc.call(ctx, sidecarRequest{
+		Type:          "detect",
+		Weights:       weightsPath,
+		Source:        sourcePath,
+		Output:        outputPath,
+		ImageSize:     params.ImageSize,
+		ConfThreshold: params.ConfThreshold,
+		IoUThreshold:  params.IoUThreshold,
+		MaxDetections: params.MaxDetections,
+		Classes:       params.Classes,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// The worker handles one request at a time and cannot be
+			// interrupted, so restart it rather than let it block the queue.
+			proc.kill()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("detection timed out")
+			}
+		}
+		return nil, err
+	}
+	if !response.OK {
+		return nil, fmt.Errorf("inference sidecar failed: %s", response.Error)
+	}
+
+	annotatedImage, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, errors.New("failed to read detected image file")
+	}
+
+	return &Result{
+		Objects:           objectsFromDetections(response.Detections),
+		AnnotatedImage:    annotatedImage,
+		AnnotatedImageExt: filepath.Ext(outputPath),
+		RawOutput:         rawOutput(response.Detections),
+	}, nil
+}
+
+// Healthy reports whether a worker process is currently running.
+func (s *Sidecar) Healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current != nil
+}
+
+// process waits until a worker is running and returns it.
+func (s *Sidecar) process(ctx context.Context) (*sidecarProcess, error) {
+	for {
+		s.mu.Lock()
+		proc, running := s.current, s.running
+		s.mu.Unlock()
+		if proc != nil {
+			return proc, nil
+		}
+
+		select {
+		case <-running:
+		case <-s.stopped:
+			return nil, ErrSidecarUnavailable
+		case <-ctx.Done():
+			return nil, ErrSidecarUnavailable
+		}
+	}
+}
+
+// Close stops the worker for good; requests in flight fail.
+func (s *Sidecar) Close() {
+	s.stop.Do(func() { close(s.stopped) })
+	s.mu.Lock()
+	proc := s.current
+	s.mu.Unlock()
+	if proc != nil {
+		proc.kill()
+	}
+}
+
+// supervise keeps a worker running until Close, restarting it after an
+// exit with a delay that doubles while it keeps failing soon after start.
+func (s *Sidecar) supervise() {
+	delay := s.timing.minRestartDelay
+	for {
+		started := time.Now()
+		err := s.runProcess()
+		select {
+		case <-s.stopped:
+			return
+		default:
+		}
+		log.Printf("detector: inference sidecar exited: %v", err)
+
+		if time.Since(started) > s.timing.maxRestartDelay {
+			delay = s.timing.minRestartDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-s.stopped:
+			return
+		}
+		delay = min(delay*2, s.timing.maxRestartDelay)
+	}
+}
+
+// runProcess starts one worker and blocks until it exits.
+func (s *Sidecar) runProcess() error {
+	args := []string{s.scriptPath, "--weights", s.weightsPath}
+	if s.maxModels > 0 {
+		args = append(args, "--max-models", strconv.Itoa(s.maxModels))
+	}
+	cmd := exec.Command(s.pythonPath, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	proc := &sidecarProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan sidecarResponse),
+	}
+
+	s.mu.Lock()
+	s.current = proc
+	close(s.running)
+	s.mu.Unlock()
+	select {
+	case <-s.stopped:
+		// Closed while starting, after Close looked for a worker to kill.
+		proc.kill()
+	default:
+	}
+
+	done := make(chan struct{})
+	go proc.healthCheck(done, s.timing)
+
+	readErr := proc.readResponses(stdout)
+	proc.kill()
+	waitErr := cmd.Wait()
+	close(done)
+
+	s.mu.Lock()
+	s.current = nil
+	s.running = make(chan struct{})
+	s.mu.Unlock()
+	proc.close()
+
+	if waitErr != nil {
+		return waitErr
+	}
+	return readErr
+}
+
+func (p *sidecarProcess) call(ctx context.Context, request sidecarRequest) (sidecarResponse, error) {
+	request.ID = p.nextID.Add(1)
+	responseCh := make(chan sidecarResponse, 1)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return sidecarResponse{}, ErrSidecarUnavailable
+	}
+	p.pending[request.ID] = responseCh
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, request.ID)
+		p.mu.Unlock()
+	}()
+
+	p.writeMu.Lock()
+	err := writeFrame(p.stdin, request)
+	p.writeMu.Unlock()
+	if err != nil {
+		return sidecarResponse{}, ErrSidecarUnavailable
+	}
+
+	select {
+	case response, ok := <-responseCh:
+		if !ok {
+			return sidecarResponse{}, ErrSidecarUnavailable
+		}
+		return response, nil
+	case <-ctx.Done():
+		return sidecarResponse{}, ctx.Err()
+	}
+}
+
+func (p *sidecarProcess) readResponses(stdout io.Reader) error {
+	reader := bufio.NewReader(stdout)
+	for {
+		var response sidecarResponse
+		if err := readFrame(reader, &response); err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		responseCh, ok := p.pending[response.ID]
+		p.mu.Unlock()
+		if ok {
+			responseCh <- response
+		}
+	}
+}
+
+// healthCheck pings the worker while it is idle and kills it when it stops
+// answering. Busy workers are not pinged since the ping would queue behind
+// the detection in progress.
+func (p *sidecarProcess) healthCheck(done <-chan struct{}, timing sidecarTiming) {
+	ctx, cancel := context.WithTimeout(context.Background(), timing.startupTimeout)
+	_, err := p.call(ctx, sidecarRequest{Type: "ping"})
+	cancel()
+	if err != nil {
+		log.Printf("detector: inference sidecar did not start: %v", err)
+		p.kill()
+		return
+	}
+
+	ticker := time.NewTicker(timing.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		busy := len(p.pending) > 0
+		p.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timing.healthCheckTimeout)
+		_, err := p.call(ctx, sidecarRequest{Type: "ping"})
+		cancel()
+		if err != nil {
+			log.Printf("detector: inference sidecar failed health check: %v", err)
+			p.kill()
+			return
+		}
+	}
+}
+
+func (p *sidecarProcess) kill() {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// close fails every request still waiting for a response.
+func (p *sidecarProcess) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for id, responseCh := range p.pending {
+		close(responseCh)
+		delete(p.pending, id)
+	}
+}
+
+func writeFrame(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			// The header promised a body.
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,243 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	requests := []sidecarRequest{
+		{ID: 1, Type: "ping"},
+		{ID: 2, Type: "detect", Source: "in.jpg", Output: "out.jpg", ImageSize: 640, ConfThreshold: 0.25, IoUThreshold: 0.45, MaxDetections: 10, Classes: []string{"cat", "dog"}},
+		{ID: 3, Type: "detect", Source: strings.Repeat("x", 1<<16)},
+	}
+	var stream bytes.Buffer
+	for _, request := range requests {
+		if err := writeFrame(&stream, request); err != nil {
+			t.Fatalf("writeFrame: %v", err)
+		}
+	}
+	for _, want := range requests {
+		var got sidecarRequest
+		if err := readFrame(&stream, &got); err != nil {
+			t.Fatalf("readFrame: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("read %+v, wrote %+v", got, want)
+		}
+	}
+	var extra sidecarRequest
+	if err := readFrame(&stream, &extra); err != io.EOF {
+		t.Errorf("readFrame at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	var frame bytes.Buffer
+	writeFrame(&frame, sidecarResponse{ID: 7, OK: true})
+	data := frame.Bytes()
+
+	for _, cut := range []int{2, 4, len(data) - 1} {
+		var response sidecarResponse
+		err := readFrame(bytes.NewReader(data[:cut]), &response)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("frame cut to %d bytes: %v, want io.ErrUnexpectedEOF", cut, err)
+		}
+	}
+}
+
+func TestReadFrameOversized(t *testing.T) {
+	header := binary.BigEndian.AppendUint32(nil, maxFrameSize+1)
+	var response sidecarResponse
+	err := readFrame(bytes.NewReader(append(header, "{}"...)), &response)
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("readFrame = %v, want a size error", err)
+	}
+
+	var bad bytes.Buffer
+	bad.Write(binary.BigEndian.AppendUint32(nil, 3))
+	bad.WriteString("{x}")
+	if err := readFrame(&bad, &response); err == nil {
+		t.Error("readFrame accepted a frame that is not JSON")
+	}
+}
+
+// TestSidecarStubProcess is the worker testdata/sidecar_stub.sh starts. In
+// mode "crash" its first start exits on the first detection, and in mode
+// "hang" its first start stops answering pings after the first.
+func TestSidecarStubProcess(t *testing.T) {
+	mode := os.Getenv("SIDECAR_STUB_MODE")
+	if mode == "" {
+		t.Skip("run by the sidecar tests as their worker")
+	}
+	os.Exit(runSidecarStub(mode, os.Getenv("SIDECAR_STUB_DIR")))
+}
+
+func runSidecarStub(mode string, dir string) int {
+	startsPath := filepath.Join(dir, "starts")
+	starts, _ := os.ReadFile(startsPath)
+	start := len(starts) + 1
+	os.WriteFile(startsPath, append(starts, 's'), 0o644)
+
+	pings := 0
+	for {
+		var request sidecarRequest
+		if err := readFrame(os.Stdin, &request); err != nil {
+			return 0
+		}
+		response := sidecarResponse{ID: request.ID, OK: true}
+		switch request.Type {
+		case "ping":
+			pings++
+			if mode == "hang" && start == 1 && pings > 1 {
+				continue
+			}
+		case "detect":
+			if mode == "crash" && start == 1 {
+				return 1
+			}
+			data, err := os.ReadFile(request.Source)
+			if err == nil {
+				os.MkdirAll(filepath.Dir(request.Output), 0o755)
+				err = os.WriteFile(request.Output, data, 0o644)
+			}
+			if err != nil {
+				response = sidecarResponse{ID: request.ID, Error: err.Error()}
+				break
+			}
+			var detection rawDetection
+			detection.ClassName = "cat"
+			detection.Confidence = 0.9
+			detection.BoundingBox.XMax, detection.BoundingBox.YMax = 10, 10
+			response.Detections = []rawDetection{detection}
+		}
+		writeFrame(os.Stdout, response)
+	}
+}
+
+// newStubSidecar runs the test binary in mode as the sidecar's worker and
+// shortens the sidecar's delays. It returns the sidecar and a function
+// counting how often the worker was started.
+func newStubSidecar(t *testing.T, mode string) (*Sidecar, func() int) {
+	t.Helper()
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Setenv("SIDECAR_STUB_BINARY", binary)
+	t.Setenv("SIDECAR_STUB_MODE", mode)
+	t.Setenv("SIDECAR_STUB_DIR", dir)
+
+	s, err := newSidecar(Options{
+		PythonPath:       "/bin/sh",
+		ServerScriptPath: "testdata/sidecar_stub.sh",
+		WeightsPath:      "weights.pt",
+		OutputDir:        t.TempDir(),
+		MaxPending:       2,
+		MaxModels:        2,
+	}, sidecarTiming{
+		healthCheckInterval: 50 * time.Millisecond,
+		healthCheckTimeout:  200 * time.Millisecond,
+		startupTimeout:      5 * time.Second,
+		minRestartDelay:     10 * time.Millisecond,
+		maxRestartDelay:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewSidecar: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	starts := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "starts"))
+		return len(data)
+	}
+	return s, starts
+}
+
+func detectWithStub(t *testing.T, s *Sidecar) (*Result, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.Detect(ctx, Input{Data: []byte("image data"), Filename: "image.jpg"})
+}
+
+func checkStubResult(t *testing.T, result *Result) {
+	t.Helper()
+	if string(result.AnnotatedImage) != "image data" || result.AnnotatedImageExt != ".jpg" {
+		t.Errorf("annotated image %q%s", result.AnnotatedImage, result.AnnotatedImageExt)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Name != "cat" {
+		t.Errorf("objects %+v, want one cat", result.Objects)
+	}
+}
+
+func TestSidecarDetect(t *testing.T) {
+	s, starts := newStubSidecar(t, "ok")
+	result, err := detectWithStub(t, s)
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	checkStubResult(t, result)
+	if !s.Healthy() || starts() != 1 {
+		t.Errorf("healthy %v after %d starts, want one healthy worker", s.Healthy(), starts())
+	}
+}
+
+func TestSidecarRestartsCrashedWorker(t *testing.T) {
+	s, starts := newStubSidecar(t, "crash")
+
+	if _, err := detectWithStub(t, s); !errors.Is(err, ErrSidecarUnavailable) {
+		t.Fatalf("Detect on a crashing worker = %v, want ErrSidecarUnavailable", err)
+	}
+	result, err := detectWithStub(t, s)
+	if err != nil {
+		t.Fatalf("Detect after the crash: %v", err)
+	}
+	checkStubResult(t, result)
+	if starts() != 2 {
+		t.Errorf("worker started %d times, want 2", starts())
+	}
+}
+
+func TestSidecarRestartsUnresponsiveWorker(t *testing.T) {
+	s, starts := newStubSidecar(t, "hang")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for starts() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker failing its health check was not restarted")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	result, err := detectWithStub(t, s)
+	if err != nil {
+		t.Fatalf("Detect after the restart: %v", err)
+	}
+	checkStubResult(t, result)
+}
+
+func TestSidecarClose(t *testing.T) {
+	s, starts := newStubSidecar(t, "ok")
+	if _, err := detectWithStub(t, s); err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	s.Close()
+
+	if _, err := detectWithStub(t, s); !errors.Is(err, ErrSidecarUnavailable) {
+		t.Errorf("Detect after Close = %v, want ErrSidecarUnavailable", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if starts() != 1 {
+		t.Errorf("worker restarted %d times after Close", starts()-1)
+	}
+}
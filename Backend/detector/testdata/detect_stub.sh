@@ -0,0 +1,23 @@
+#!/bin/sh
+# Stands in for yolov5's detect.py in tests. It takes the same arguments,
+# and writes one box of a class named after the source file, without its
+# extension, next to an unchanged copy of the source, as detect.py would.
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--project) project="$2"; shift ;;
+	--name) name="$2"; shift ;;
+	--source) source="$2"; shift ;;
+	esac
+	shift
+done
+
+dir="$project/$name"
+mkdir -p "$dir"
+# Give concurrent runs time to overlap.
+sleep 0.2
+cp "$source" "$dir/"
+class=$(basename "$source")
+class=${class%.*}
+cat > "$dir/detections.json" <<JSON
+{"results": [{"detections": [{"class_name": "$class", "confidence": 0.9, "bounding_box": {"x_min": 1, "y_min": 2, "x_max": 3, "y_max": 4}}]}]}
+JSON
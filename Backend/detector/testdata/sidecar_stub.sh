@@ -0,0 +1,5 @@
+#!/bin/sh
+# Stands in for imagedetection/inference_server.py in tests. It ignores its
+# arguments and runs the test binary's TestSidecarStubProcess, which speaks
+# the sidecar protocol as SIDECAR_STUB_MODE says.
+exec "$SIDECAR_STUB_BINARY" -test.run='^TestSidecarStubProcess$'
@@ -0,0 +1,229 @@
+package detector
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// The helpers in this file reproduce yolov5's pre and post-processing
+// (utils/augmentations.letterbox, utils/general.non_max_suppression and
+// scale_boxes) so that native backends report the same boxes as detect.py.
+
+const (
+	letterboxFill   = 114
+	maxBoxesIntoNMS = 30000
+)
+
+type letterboxInfo struct {
+	gain float64
+	padX float64
+	padY float64
+}
+
+type predictedBox struct {
+	x1, y1, x2, y2 float64
+	score          float64
+	class          int
+}
+
+// letterbox resizes img to fit a size x size square keeping its aspect
+// ratio, pads the rest with grey and writes the result into dst as a CHW
+// RGB float tensor scaled to [0, 1].
+func letterbox(img image.Image, size int, dst []float32) letterboxInfo {
+	src := toRGBA(img)
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	gain := math.Min(float64(size)/float64(srcH), float64(size)/float64(srcW))
+	newW := int(math.RoundToEven(float64(srcW) * gain))
+	newH := int(math.RoundToEven(float64(srcH) * gain))
+	padX := float64(size-newW) / 2
+	padY := float64(size-newH) / 2
+	left := int(math.RoundToEven(padX - 0.1))
+	top := int(math.RoundToEven(padY - 0.1))
+
+	plane := size * size
+	fill := float32(letterboxFill) / 255
+	for i := range dst[:3*plane] {
+		dst[i] = fill
+	}
+
+	scaleX := float64(srcW) / float64(newW)
+	scaleY := float64(srcH) / float64(newH)
+	for y := 0; y < newH; y++ {
+		sy, wy := bilinearSource(y, scaleY, srcH)
+		for x := 0; x < newW; x++ {
+			sx, wx := bilinearSource(x, scaleX, srcW)
+			offset := (top+y)*size + left + x
+			for c := 0; c < 3; c++ {
+				p00 := float64(src.Pix[src.PixOffset(sx, sy)+c])
+				p01 := float64(src.Pix[src.PixOffset(min(sx+1, srcW-1), sy)+c])
+				p10 := float64(src.Pix[src.PixOffset(sx, min(sy+1, srcH-1))+c])
+				p11 := float64(src.Pix[src.PixOffset(min(sx+1, srcW-1), min(sy+1, srcH-1))+c])
+				upper := p00*(1-wx) + p01*wx
+				lower := p10*(1-wx) + p11*wx
+				value := math.Round(upper*(1-wy) + lower*wy)
+				dst[c*plane+offset] = float32(value / 255)
+			}
+		}
+	}
+
+	return letterboxInfo{gain: gain, padX: padX, padY: padY}
+}
+
+// bilinearSource maps a destination coordinate to its source pixel and the
+// weight of the next pixel, using OpenCV's half-pixel convention.
+func bilinearSource(dst int, scale float64, srcSize int) (int, float64) {
+	f := (float64(dst)+0.5)*scale - 0.5
+	s := math.Floor(f)
+	w := f - s
+	if s < 0 {
+		return 0, 0
+	}
+	if int(s) >= srcSize-1 {
+		return srcSize - 1, 0
+	}
+	return int(s), w
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// decodePredictions reads yolov5's raw output rows of
+// [cx, cy, w, h, objectness, class scores...] and keeps the best class of
+// each row whose confidence passes confThreshold.
+func decodePredictions(output []float32, rows int, cols int, confThreshold float64) []predictedBox {
+	var boxes []predictedBox
+	for i := 0; i < rows; i++ {
+		row := output[i*cols : (i+1)*cols]
+		objectness := float64(row[4])
+		if objectness <= confThreshold {
+			continue
+		}
+
+		bestClass, bestScore := 0, 0.0
+		for c, score := range row[5:] {
+			if s := float64(score) * objectness; s > bestScore {
+				bestClass, bestScore = c, s
+			}
+		}
+		if bestScore <= confThreshold {
+			continue
+		}
+
+		cx, cy, w, h := float64(row[0]), float64(row[1]), float64(row[2]), float64(row[3])
+		boxes = append(boxes, predictedBox{
+			x1:    cx - w/2,
+			y1:    cy - h/2,
+			x2:    cx + w/2,
+			y2:    cy + h/2,
+			score: bestScore,
+			class: bestClass,
+		})
+	}
+	return boxes
+}
+
+// filterBoxClasses keeps the boxes whose class name is in classes, or all of
+// them when classes is empty.
+func filterBoxClasses(boxes []predictedBox, classNames []string, classes []string) []predictedBox {
+	if len(classes) == 0 {
+		return boxes
+	}
+	var kept []predictedBox
+	for _, b := range boxes {
+		if slices.Contains(classes, className(classNames, b.class)) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// nonMaxSuppression greedily keeps the highest scoring boxes and drops any
+// box of the same class overlapping a kept one by more than iouThreshold.
+func nonMaxSuppression(boxes []predictedBox, iouThreshold float64, maxDetections int) []predictedBox {
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].score > boxes[j].score
+	})
+	if len(boxes) > maxBoxesIntoNMS {
+		boxes = boxes[:maxBoxesIntoNMS]
+	}
+
+	var kept []predictedBox
+	suppressed := make([]bool, len(boxes))
+	for i := range boxes {
+		if suppressed[i] {
+			continue
+		}
+		kept = append(kept, boxes[i])
+		if len(kept) == maxDetections {
+			break
+		}
+		for j := i + 1; j < len(boxes); j++ {
+			if !suppressed[j] && boxes[i].class == boxes[j].class && boxIoU(boxes[i], boxes[j]) > iouThreshold {
+				suppressed[j] = true
+			}
+		}
+	}
+	return kept
+}
+
+func boxIoU(a predictedBox, b predictedBox) float64 {
+	interW := math.Min(a.x2, b.x2) - math.Max(a.x1, b.x1)
+	interH := math.Min(a.y2, b.y2) - math.Max(a.y1, b.y1)
+	if interW <= 0 || interH <= 0 {
+		return 0
+	}
+	inter := interW * interH
+	union := (a.x2-a.x1)*(a.y2-a.y1) + (b.x2-b.x1)*(b.y2-b.y1) - inter
+	return inter / union
+}
+
+// scaleBoxes maps boxes from letterboxed model space back onto the original
+// width x height image and rounds them to whole pixels.
+func scaleBoxes(boxes []predictedBox, info letterboxInfo, width int, height int) {
+	padX := math.RoundToEven(info.padX - 0.1)
+	padY := math.RoundToEven(info.padY - 0.1)
+	for i := range boxes {
+		b := &boxes[i]
+		b.x1 = math.RoundToEven(clamp((b.x1-padX)/info.gain, 0, float64(width)))
+		b.x2 = math.RoundToEven(clamp((b.x2-padX)/info.gain, 0, float64(width)))
+		b.y1 = math.RoundToEven(clamp((b.y1-padY)/info.gain, 0, float64(height)))
+		b.y2 = math.RoundToEven(clamp((b.y2-padY)/info.gain, 0, float64(height)))
+	}
+}
+
+func clamp(v float64, lo float64, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// boxesToDetections converts boxes into the shape detect.py writes, with
+// confidence rounded to two decimals.
+func boxesToDetections(boxes []predictedBox, classNames []string) []rawDetection {
+	detections := make([]rawDetection, 0, len(boxes))
+	for _, b := range boxes {
+		var detection rawDetection
+		detection.ClassName = className(classNames, b.class)
+		detection.Confidence = float32(math.Round(b.score*100) / 100)
+		detection.BoundingBox.XMin = int(b.x1)
+		detection.BoundingBox.YMin = int(b.y1)
+		detection.BoundingBox.XMax = int(b.x2)
+		detection.BoundingBox.YMax = int(b.y2)
+		detections = append(detections, detection)
+	}
+	return detections
+}
+
+func className(classNames []string, class int) string {
+	if class < len(classNames) {
+		return classNames[class]
+	}
+	return "class" + strconv.Itoa(class)
+}
@@ -0,0 +1,148 @@
+package detector
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestLetterbox(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	for _, test := range []struct {
+		name          string
+		width, height int
+		size          int
+		want          letterboxInfo
+		// The rectangle of the size x size square the image lands in.
+		content image.Rectangle
+	}{
+		{name: "square", width: 4, height: 4, size: 8, want: letterboxInfo{gain: 2}, content: image.Rect(0, 0, 8, 8)},
+		{name: "wide", width: 4, height: 2, size: 8, want: letterboxInfo{gain: 2, padY: 2}, content: image.Rect(0, 2, 8, 6)},
+		{name: "tall", width: 2, height: 8, size: 8, want: letterboxInfo{gain: 1, padX: 3}, content: image.Rect(3, 0, 5, 8)},
+		{name: "odd padding", width: 8, height: 5, size: 8, want: letterboxInfo{gain: 1, padY: 1.5}, content: image.Rect(0, 1, 8, 6)},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+		for y := 0; y < test.height; y++ {
+			for x := 0; x < test.width; x++ {
+				img.SetRGBA(x, y, red)
+			}
+		}
+		dst := make([]float32, 3*test.size*test.size)
+		if got := letterbox(img, test.size, dst); got != test.want {
+			t.Errorf("%s: letterbox = %+v, want %+v", test.name, got, test.want)
+		}
+
+		plane := test.size * test.size
+		fill := float32(letterboxFill) / 255
+		for y := 0; y < test.size; y++ {
+			for x := 0; x < test.size; x++ {
+				want := [3]float32{fill, fill, fill}
+				if (image.Point{X: x, Y: y}).In(test.content) {
+					want = [3]float32{1, 0, 0}
+				}
+				offset := y*test.size + x
+				got := [3]float32{dst[offset], dst[plane+offset], dst[2*plane+offset]}
+				if got != want {
+					t.Errorf("%s: pixel (%d,%d) = %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodePredictions(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		rows [][]float32
+		conf float64
+		want []predictedBox
+	}{
+		{
+			name: "best class",
+			rows: [][]float32{{50, 40, 20, 10, 0.8, 0.25, 0.75}},
+			conf: 0.25,
+			want: []predictedBox{{x1: 40, y1: 35, x2: 60, y2: 45, score: 0.8 * 0.75, class: 1}},
+		},
+		{
+			name: "low objectness",
+			rows: [][]float32{{50, 40, 20, 10, 0.2, 0, 1}},
+			conf: 0.25,
+		},
+		{
+			// Objectness passes, but not once multiplied by the class score.
+			name: "low class score",
+			rows: [][]float32{{50, 40, 20, 10, 0.5, 0.4, 0.3}},
+			conf: 0.25,
+		},
+		{
+			name: "several rows",
+			rows: [][]float32{
+				{10, 10, 4, 4, 0.9, 1, 0},
+				{50, 40, 20, 10, 0.1, 1, 0},
+				{30, 30, 10, 10, 0.6, 0, 1},
+			},
+			conf: 0.5,
+			want: []predictedBox{
+				{x1: 8, y1: 8, x2: 12, y2: 12, score: 0.9, class: 0},
+				{x1: 25, y1: 25, x2: 35, y2: 35, score: 0.6, class: 1},
+			},
+		},
+	} {
+		var output []float32
+		for _, row := range test.rows {
+			output = append(output, row...)
+		}
+		got := decodePredictions(output, len(test.rows), 7, test.conf)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: decodePredictions = %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+		for i, box := range got {
+			if !sameBox(box, test.want[i]) {
+				t.Errorf("%s: box %d = %+v, want %+v", test.name, i, box, test.want[i])
+			}
+		}
+	}
+}
+
+func TestNonMaxSuppression(t *testing.T) {
+	high := predictedBox{x1: 0, y1: 0, x2: 10, y2: 10, score: 0.9}
+	// Overlaps high with an IoU of 0.81.
+	overlapping := predictedBox{x1: 1, y1: 1, x2: 10, y2: 10, score: 0.8}
+	// Overlaps high with an IoU of 1/3.
+	shifted := predictedBox{x1: 5, y1: 0, x2: 15, y2: 10, score: 0.7}
+	apart := predictedBox{x1: 20, y1: 20, x2: 30, y2: 30, score: 0.6}
+	otherClass := overlapping
+	otherClass.class = 1
+
+	for _, test := range []struct {
+		name  string
+		boxes []predictedBox
+		iou   float64
+		max   int
+		want  []predictedBox
+	}{
+		{name: "overlap dropped", boxes: []predictedBox{overlapping, high}, iou: 0.45, max: 10, want: []predictedBox{high}},
+		{name: "below threshold kept", boxes: []predictedBox{shifted, high}, iou: 0.45, max: 10, want: []predictedBox{high, shifted}},
+		{name: "lower threshold", boxes: []predictedBox{shifted, high}, iou: 0.3, max: 10, want: []predictedBox{high}},
+		{name: "other class kept", boxes: []predictedBox{otherClass, high}, iou: 0.45, max: 10, want: []predictedBox{high, otherClass}},
+		{name: "max detections", boxes: []predictedBox{apart, shifted, high}, iou: 0.45, max: 2, want: []predictedBox{high, shifted}},
+		{name: "distant box kept", boxes: []predictedBox{high, overlapping, apart}, iou: 0.45, max: 10, want: []predictedBox{high, apart}},
+		{name: "empty", iou: 0.45, max: 10},
+	} {
+		got := nonMaxSuppression(append([]predictedBox(nil), test.boxes...), test.iou, test.max)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: nonMaxSuppression = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func sameBox(a, b predictedBox) bool {
+	const epsilon = 1e-6
+	return a.class == b.class &&
+		math.Abs(a.x1-b.x1) < epsilon && math.Abs(a.y1-b.y1) < epsilon &&
+		math.Abs(a.x2-b.x2) < epsilon && math.Abs(a.y2-b.y2) < epsilon &&
+		math.Abs(a.score-b.score) < epsilon
+}
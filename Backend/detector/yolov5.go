@@ -0,0 +1,191 @@
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// YoloV5 runs yolov5's detect.py in a fresh python process for every image.
+// Each run writes to its own directory under outputDir, named after the job,
+// which is removed once its results have been read.
+type YoloV5 struct {
+	pythonPath  string
+	scriptPath  string
+	weightsPath string
+	outputDir   string
+}
+
+func NewYoloV5(options Options) (*YoloV5, error) {
+	paths := []string{options.PythonPath, options.ScriptPath, options.WeightsPath, options.OutputDir}
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path of %q: %w", path, err)
+		}
+		paths[i] = absPath
+	}
+
+	return &YoloV5{
+		pythonPath:  paths[0],
+		scriptPath:  paths[1],
+		weightsPath: paths[2],
+		outputDir:   paths[3],
+	}, nil
+}
+
+func (y *YoloV5) Detect(ctx context.Context, input Input) (*Result, error) {
+	sourcePath := input.Path
+	if sourcePath == "" {
+		tempFile, err := os.CreateTemp("", "detect-*"+filepath.Ext(input.Filename))
+		if err != nil {
+			return nil, errors.New("failed to create temporary image file")
+		}
+		defer os.Remove(tempFile.Name())
+
+		_, err = tempFile.Write(input.Data)
+		tempFile.Close()
+		if err != nil {
+			return nil, errors.New("failed to write temporary image file")
+		}
+		sourcePath = tempFile.Name()
+	}
+
+	weightsPath, err := input.weights(y.weightsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %q: %w", input.WeightsPath, err)
+	}
+
+	jobID := input.JobID
+	if jobID == "" {
+		jobID = primitive.NewObjectID().Hex()
+	}
+	runDir := filepath.Join(y.outputDir, jobID)
+
+	// A run interrupted by a crash may have left files behind under the same
+	// job ID; clear them so --exist-ok never mixes old and new output.
+	if err := os.RemoveAll(runDir); err != nil {
+		return nil, errors.New("failed to clear detection output folder")
+	}
+	defer os.RemoveAll(runDir)
+
+	params := input.params()
+	cmd := exec.CommandContext(ctx, y.pythonPath, y.scriptPath, "--weights", weightsPath,
+		"--img", strconv.Itoa(params.ImageSize),
+		"--conf-thres", strconv.FormatFloat(float64(params.ConfThreshold), 'f', -1, 32),
+		"--iou-thres", strconv.FormatFloat(float64(params.IoUThreshold), 'f', -1, 32),
+		"--max-det", strconv.Itoa(params.MaxDetections),
+		"--project", y.outputDir, "--name", jobID, "--exist-ok", "--source", sourcePath)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, errors.New("detection script timed out")
+		}
+		return nil, fmt.Errorf("failed to run detection script: %s\n%w", output, err)
+	}
+
+	// detect.py only filters by class index, so classes are filtered by name
+	// once its output has been read.
+	detectedObjects, rawOutput, err := readDetections(filepath.Join(runDir, "detections.json"), params.Classes)
+	if err != nil {
+		return nil, err
+	}
+
+	detectedImagePath := filepath.Join(runDir, filepath.Base(sourcePath))
+	annotatedImage, err := os.ReadFile(detectedImagePath)
+	if err != nil {
+		return nil, errors.New("failed to read detected image file")
+	}
+
+	return &Result{
+		Objects:           detectedObjects,
+		AnnotatedImage:    annotatedImage,
+		AnnotatedImageExt: filepath.Ext(detectedImagePath),
+		RawOutput:         rawOutput,
+	}, nil
+}
+
+// rawDetection is a single box as written by detect.py and the inference
+// sidecar.
+type rawDetection struct {
+	ClassName   string  `json:"class_name"`
+	Confidence  float32 `json:"confidence"`
+	BoundingBox struct {
+		XMin int `json:"x_min"`
+		YMin int `json:"y_min"`
+		XMax int `json:"x_max"`
+		YMax int `json:"y_max"`
+	} `json:"bounding_box"`
+}
+
+// readDetections parses the detections.json file written by detect.py,
+// keeping only the given classes when any are set. The file's contents are
+// returned alongside the objects.
+func readDetections(jsonFilePath string, classes []string) ([]models.DetectedObject, []byte, error) {
+	data, err := os.ReadFile(jsonFilePath)
+	if err != nil {
+		return nil, nil, errors.New("failed to open JSON output file")
+	}
+
+	var detectOutput struct {
+		Results []struct {
+			Detections []rawDetection `json:"detections"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &detectOutput); err != nil {
+		return nil, nil, errors.New("failed to decode JSON output file")
+	}
+
+	var detections []rawDetection
+	for _, result := range detectOutput.Results {
+		detections = append(detections, result.Detections...)
+	}
+	return objectsFromDetections(filterClasses(detections, classes)), data, nil
+}
+
+// objectsFromDetections groups raw boxes by class name.
+func objectsFromDetections(detections []rawDetection) []models.DetectedObject {
+	detectedObjectsMap := make(map[string][]models.Coordinate)
+
+	for _, detection := range detections {
+		coordinate := models.Coordinate{
+			Bounding_id: primitive.NewObjectID(),
+			Confidence:  detection.Confidence,
+			X_min:       detection.BoundingBox.XMin,
+			X_max:       detection.BoundingBox.XMax,
+			Y_min:       detection.BoundingBox.YMin,
+			Y_max:       detection.BoundingBox.YMax,
+			Origin:      models.OriginModel,
+		}
+		detectedObjectsMap[detection.ClassName] = append(detectedObjectsMap[detection.ClassName], coordinate)
+	}
+
+	return groupedObjects(detectedObjectsMap)
+}
+
+func groupedObjects(detectedObjectsMap map[string][]models.Coordinate) []models.DetectedObject {
+	names := make([]string, 0, len(detectedObjectsMap))
+	for name := range detectedObjectsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	detectedObjects := []models.DetectedObject{}
+	for _, name := range names {
+		detectedObjects = append(detectedObjects, models.DetectedObject{
+			Name:        name,
+			Coordinates: detectedObjectsMap[name],
+		})
+	}
+	return detectedObjects
+}
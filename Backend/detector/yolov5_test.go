@@ -0,0 +1,104 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newStubYoloV5 runs testdata/detect_stub.sh in place of detect.py.
+func newStubYoloV5(t *testing.T) *YoloV5 {
+	t.Helper()
+	y, err := NewYoloV5(Options{
+		PythonPath:  "/bin/sh",
+		ScriptPath:  "testdata/detect_stub.sh",
+		WeightsPath: "weights.pt",
+		OutputDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewYoloV5: %v", err)
+	}
+	return y
+}
+
+func TestYoloV5ConcurrentDetections(t *testing.T) {
+	y := newStubYoloV5(t)
+	sourceDir := t.TempDir()
+
+	const jobs = 8
+	inputs := make([]Input, jobs)
+	for i := range inputs {
+		name := fmt.Sprintf("image%d", i)
+		data := []byte("image data " + name)
+		// Half the jobs pass a file and half the bytes, which Detect spools
+		// to a temporary file, and every other job has no ID of its own.
+		if i%2 == 0 {
+			path := filepath.Join(sourceDir, name+".jpg")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			inputs[i] = Input{Path: path}
+		} else {
+			inputs[i] = Input{Data: data, Filename: name + ".jpg"}
+		}
+		if i%4 < 2 {
+			inputs[i].JobID = name
+		}
+	}
+
+	results := make([]*Result, jobs)
+	errs := make([]error, jobs)
+	var wg sync.WaitGroup
+	for i := range inputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = y.Detect(context.Background(), inputs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, input := range inputs {
+		if errs[i] != nil {
+			t.Errorf("job %d: %v", i, errs[i])
+			continue
+		}
+		result := results[i]
+
+		want := input.Data
+		if input.Path != "" {
+			want, _ = os.ReadFile(input.Path)
+		}
+		if !bytes.Equal(result.AnnotatedImage, want) {
+			t.Errorf("job %d: got annotated image %q, want %q", i, result.AnnotatedImage, want)
+		}
+
+		// The stub names its one class after the file it was given, which
+		// for spooled inputs is a random temporary name.
+		if len(result.Objects) != 1 || len(result.Objects[0].Coordinates) != 1 {
+			t.Errorf("job %d: got objects %+v, want one box", i, result.Objects)
+			continue
+		}
+		if input.Path != "" {
+			wantClass := fmt.Sprintf("image%d", i)
+			if got := result.Objects[0].Name; got != wantClass {
+				t.Errorf("job %d: got detections of %q", i, got)
+			}
+		}
+		if !bytes.Contains(result.RawOutput, []byte(result.Objects[0].Name)) {
+			t.Errorf("job %d: raw output %s does not match objects", i, result.RawOutput)
+		}
+	}
+
+	entries, err := os.ReadDir(y.outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("run directories left behind: %d", len(entries))
+	}
+}
@@ -0,0 +1,158 @@
+// Package events is an in-process publish/subscribe hub for per-user
+// notifications such as image status changes. Recent events are kept in a
+// ring buffer so a client that reconnects can resume from the last event it
+// saw.
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sizes used by the default broker.
+const (
+	historySize      = 1000
+	subscriberBuffer = 64
+)
+
+// Event is one notification. IDs increase monotonically; they start from the
+// process start time so IDs handed out before a restart stay lower than the
+// ones handed out after it.
+type Event struct {
+	ID   uint64
+	User primitive.ObjectID
+	Type string
+	Data interface{}
+}
+
+func (e Event) IDString() string {
+	return strconv.FormatUint(e.ID, 10)
+}
+
+// Subscription receives the events of one user. C is closed when the
+// subscriber falls too far behind or is unsubscribed.
+type Subscription struct {
+	C    <-chan Event
+	c    chan Event
+	user primitive.ObjectID
+}
+
+type Broker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	start       int
+	subscribers map[primitive.ObjectID]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		nextID:      uint64(time.Now().UnixMilli()) << 10,
+		subscribers: make(map[primitive.ObjectID]map[*Subscription]struct{}),
+	}
+}
+
+var defaultBroker = NewBroker()
+
+// Publish sends an event to every subscriber of user on the default broker.
+func Publish(user primitive.ObjectID, eventType string, data interface{}) {
+	defaultBroker.Publish(user, eventType, data)
+}
+
+// Subscribe subscribes to user's events on the default broker.
+func Subscribe(user primitive.ObjectID, lastEventID string) (*Subscription, []Event, bool) {
+	return defaultBroker.Subscribe(user, lastEventID)
+}
+
+// Unsubscribe removes a subscription from the default broker.
+func Unsubscribe(sub *Subscription) {
+	defaultBroker.Unsubscribe(sub)
+}
+
+func (b *Broker) Publish(user primitive.ObjectID, eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, User: user, Type: eventType, Data: data}
+	if len(b.history) < historySize {
+		b.history = append(b.history, event)
+	} else {
+		b.history[b.start] = event
+		b.start = (b.start + 1) % historySize
+	}
+
+	for sub := range b.subscribers[user] {
+		select {
+		case sub.c <- event:
+		default:
+			// A subscriber that cannot keep up is dropped; it reconnects
+			// with its last event ID and catches up from the history.
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribe starts delivering user's events. When lastEventID is set, the
+// events published after it that are still in the history are returned to
+// be sent first; complete is false when some of them have already been
+// dropped from the history, so the client should reload its state.
+func (b *Broker) Subscribe(user primitive.ObjectID, lastEventID string) (*Subscription, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, user: user}
+	if b.subscribers[user] == nil {
+		b.subscribers[user] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[user][sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return sub, nil, false
+	}
+
+	complete := true
+	var missed []Event
+	for i := 0; i < len(b.history); i++ {
+		event := b.history[(b.start+i)%len(b.history)]
+		if i == 0 && event.ID > lastID+1 {
+			complete = false
+		}
+		if event.ID > lastID && event.User == user {
+			missed = append(missed, event)
+		}
+	}
+	if len(b.history) == 0 && lastID < b.nextID {
+		complete = false
+	}
+	return sub, missed, complete
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub)
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	subs, ok := b.subscribers[sub.user]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.c)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.user)
+	}
+}
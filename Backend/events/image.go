@@ -0,0 +1,42 @@
+package events
+
+import (
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TypeImageStatus   = "image.status"
+	TypeBatchProgress = "batch.progress"
+)
+
+// ImageStatus is published whenever an image moves between detection
+// states. Summary counts the boxes found per class once a run has finished.
+type ImageStatus struct {
+	Image             primitive.ObjectID `json:"image"`
+	ImageName         string             `json:"imageName,omitempty"`
+	Status            string             `json:"status"`
+	Run               primitive.ObjectID `json:"run,omitempty"`
+	DetectedImagePath string             `json:"detectedImagePath,omitempty"`
+	Error             string             `json:"error,omitempty"`
+	Summary           map[string]int     `json:"summary,omitempty"`
+}
+
+// PublishImageStatus tells image's owner about its current state.
+func PublishImageStatus(image models.Image) {
+	status := ImageStatus{
+		Image:     image.ID,
+		ImageName: image.ImageName,
+		Status:    image.Status,
+		Error:     image.Error,
+	}
+	if image.Status == models.StatusSuccess || image.Status == models.StatusFail {
+		status.Run = image.CurrentRun
+		status.DetectedImagePath = image.DetectedImagePath
+		status.Summary = make(map[string]int, len(image.Result))
+		for _, object := range image.Result {
+			status.Summary[object.Name] += len(object.Coordinates)
+		}
+	}
+	Publish(image.User, TypeImageStatus, status)
+}
@@ -0,0 +1,242 @@
+// Package export converts detection results into the annotation formats
+// training tools read: COCO JSON, YOLO txt, Pascal VOC XML and flat CSV.
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+// Formats annotations can be exported in.
+const (
+	FormatCOCO = "coco"
+	FormatYOLO = "yolo"
+	FormatVOC  = "voc"
+	FormatCSV  = "csv"
+)
+
+// ImagesDir is where the exported images go inside the archive, and what
+// File paths are relative to.
+const ImagesDir = "images"
+
+// Image is an exported image: its file inside ImagesDir, its size in
+// pixels, how many colour channels it has and its boxes.
+type Image struct {
+	ID     string
+	File   string
+	Width  int
+	Height int
+	Depth  int
+	Result []models.DetectedObject
+}
+
+// Classes lists the class names used in results, sorted, so every format
+// numbers them the same way.
+func Classes(results ...[]models.DetectedObject) []string {
+	seen := map[string]bool{}
+	classes := []string{}
+	for _, result := range results {
+		for _, object := range result {
+			if !seen[object.Name] && len(object.Coordinates) > 0 {
+				seen[object.Name] = true
+				classes = append(classes, object.Name)
+			}
+		}
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+func classIndex(classes []string) map[string]int {
+	index := make(map[string]int, len(classes))
+	for i, class := range classes {
+		index[class] = i
+	}
+	return index
+}
+
+// COCODataset is a COCO object detection annotation file.
+type COCODataset struct {
+	Info        COCOInfo         `json:"info"`
+	Images      []COCOImage      `json:"images"`
+	Annotations []COCOAnnotation `json:"annotations"`
+	Categories  []COCOCategory   `json:"categories"`
+}
+
+type COCOInfo struct {
+	Description string `json:"description"`
+	DateCreated string `json:"date_created"`
+}
+
+type COCOImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// COCOAnnotation is one box. Score is the model's confidence, left out for
+// boxes people drew.
+type COCOAnnotation struct {
+	ID         int        `json:"id"`
+	ImageID    int        `json:"image_id"`
+	CategoryID int        `json:"category_id"`
+	BBox       [4]float64 `json:"bbox"`
+	Area       float64    `json:"area"`
+	IsCrowd    int        `json:"iscrowd"`
+	Score      float32    `json:"score,omitempty"`
+}
+
+type COCOCategory struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	SuperCategory string `json:"supercategory"`
+}
+
+// COCO builds one dataset of all images. Images, boxes and categories are
+// numbered from 1 in order, with categories in the order of classes.
+func COCO(images []Image, classes []string) COCODataset {
+	dataset := COCODataset{
+		Info:        COCOInfo{Description: "Exported detections", DateCreated: time.Now().UTC().Format(time.RFC3339)},
+		Images:      []COCOImage{},
+		Annotations: []COCOAnnotation{},
+		Categories:  []COCOCategory{},
+	}
+	for i, class := range classes {
+		dataset.Categories = append(dataset.Categories, COCOCategory{ID: i + 1, Name: class})
+	}
+
+	index := classIndex(classes)
+	for i, image := range images {
+		imageID := i + 1
+		dataset.Images = append(dataset.Images, COCOImage{ID: imageID, FileName: image.File, Width: image.Width, Height: image.Height})
+		for _, object := range image.Result {
+			for _, box := range object.Coordinates {
+				width, height := float64(box.X_max-box.X_min), float64(box.Y_max-box.Y_min)
+				annotation := COCOAnnotation{
+					ID:         len(dataset.Annotations) + 1,
+					ImageID:    imageID,
+					CategoryID: index[object.Name] + 1,
+					BBox:       [4]float64{float64(box.X_min), float64(box.Y_min), width, height},
+					Area:       width * height,
+				}
+				if box.Origin != models.OriginHuman {
+					annotation.Score = box.Confidence
+				}
+				dataset.Annotations = append(dataset.Annotations, annotation)
+			}
+		}
+	}
+	return dataset
+}
+
+// YOLO writes the label file of image: a line per box with its class index
+// and its centre, width and height as fractions of the image size.
+func YOLO(image Image, classes []string) []byte {
+	index := classIndex(classes)
+	var buf bytes.Buffer
+	for _, object := range image.Result {
+		for _, box := range object.Coordinates {
+			fmt.Fprintf(&buf, "%d %.6f %.6f %.6f %.6f\n", index[object.Name],
+				float64(box.X_min+box.X_max)/2/float64(image.Width),
+				float64(box.Y_min+box.Y_max)/2/float64(image.Height),
+				float64(box.X_max-box.X_min)/float64(image.Width),
+				float64(box.Y_max-box.Y_min)/float64(image.Height))
+		}
+	}
+	return buf.Bytes()
+}
+
+// ClassNames writes classes.txt, a class name per line in index order.
+func ClassNames(classes []string) []byte {
+	var buf bytes.Buffer
+	for _, class := range classes {
+		buf.WriteString(class + "\n")
+	}
+	return buf.Bytes()
+}
+
+type vocAnnotation struct {
+	XMLName   xml.Name    `xml:"annotation"`
+	Folder    string      `xml:"folder"`
+	Filename  string      `xml:"filename"`
+	Size      vocSize     `xml:"size"`
+	Segmented int         `xml:"segmented"`
+	Objects   []vocObject `xml:"object"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	BndBox    struct {
+		XMin int `xml:"xmin"`
+		YMin int `xml:"ymin"`
+		XMax int `xml:"xmax"`
+		YMax int `xml:"ymax"`
+	} `xml:"bndbox"`
+}
+
+// VOC writes the Pascal VOC annotation file of image.
+func VOC(image Image) ([]byte, error) {
+	annotation := vocAnnotation{
+		Folder:   ImagesDir,
+		Filename: image.File,
+		Size:     vocSize{Width: image.Width, Height: image.Height, Depth: image.Depth},
+	}
+	for _, object := range image.Result {
+		for _, box := range object.Coordinates {
+			entry := vocObject{Name: object.Name, Pose: "Unspecified"}
+			entry.BndBox.XMin, entry.BndBox.YMin = box.X_min, box.Y_min
+			entry.BndBox.XMax, entry.BndBox.YMax = box.X_max, box.Y_max
+			annotation.Objects = append(annotation.Objects, entry)
+		}
+	}
+
+	data, err := xml.MarshalIndent(annotation, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// CSV writes every box of images as a row, after a header row.
+func CSV(images []Image) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"image_id", "file", "width", "height", "class", "x_min", "y_min", "x_max", "y_max", "confidence", "origin", "box_id"})
+	for _, image := range images {
+		for _, object := range image.Result {
+			for _, box := range object.Coordinates {
+				origin, confidence := box.Origin, ""
+				if origin == "" {
+					origin = models.OriginModel
+				}
+				if origin != models.OriginHuman {
+					confidence = strconv.FormatFloat(float64(box.Confidence), 'f', 4, 32)
+				}
+				writer.Write([]string{
+					image.ID, image.File, strconv.Itoa(image.Width), strconv.Itoa(image.Height), object.Name,
+					strconv.Itoa(box.X_min), strconv.Itoa(box.Y_min), strconv.Itoa(box.X_max), strconv.Itoa(box.Y_max),
+					confidence, origin, box.Bounding_id.Hex(),
+				})
+			}
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,209 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var humanBoxID = primitive.NewObjectID()
+
+// testImages holds a model box and a human box in a 200x100 image, and in a
+// square one a model box and a class with no boxes.
+func testImages() []Image {
+	return []Image{
+		{
+			ID: "a", File: "a.png", Width: 200, Height: 100, Depth: 3,
+			Result: []models.DetectedObject{
+				{Name: "dog", Coordinates: []models.Coordinate{{X_min: 20, Y_min: 10, X_max: 60, Y_max: 50, Confidence: 0.9}}},
+				{Name: "cat", Coordinates: []models.Coordinate{{X_min: 100, Y_min: 0, X_max: 200, Y_max: 100, Origin: models.OriginHuman, Bounding_id: humanBoxID}}},
+			},
+		},
+		{
+			ID: "b", File: "b.jpg", Width: 100, Height: 100, Depth: 1,
+			Result: []models.DetectedObject{
+				{Name: "bird", Coordinates: []models.Coordinate{}},
+				{Name: "cat", Coordinates: []models.Coordinate{{X_min: 0, Y_min: 0, X_max: 50, Y_max: 50, Confidence: 0.5, Origin: models.OriginModel}}},
+			},
+		},
+	}
+}
+
+func testClasses(images []Image) []string {
+	var results [][]models.DetectedObject
+	for _, image := range images {
+		results = append(results, image.Result)
+	}
+	return Classes(results...)
+}
+
+func TestClasses(t *testing.T) {
+	if got, want := testClasses(testImages()), []string{"cat", "dog"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Classes = %v, want %v", got, want)
+	}
+	if got := Classes(); got == nil || len(got) != 0 {
+		t.Errorf("Classes of nothing = %#v, want an empty list", got)
+	}
+}
+
+func TestYOLO(t *testing.T) {
+	images := testImages()
+	classes := testClasses(images)
+	names := strings.Split(strings.TrimSuffix(string(ClassNames(classes)), "\n"), "\n")
+
+	for _, test := range []struct {
+		image Image
+		want  string
+		// The class of each line, which its index must name in classes.txt.
+		lineClasses []string
+	}{
+		{
+			image:       images[0],
+			want:        "1 0.200000 0.300000 0.200000 0.400000\n0 0.750000 0.500000 0.500000 1.000000\n",
+			lineClasses: []string{"dog", "cat"},
+		},
+		{
+			image:       images[1],
+			want:        "0 0.250000 0.250000 0.500000 0.500000\n",
+			lineClasses: []string{"cat"},
+		},
+		{image: Image{File: "empty.png", Width: 10, Height: 10}, want: ""},
+	} {
+		got := string(YOLO(test.image, classes))
+		if got != test.want {
+			t.Errorf("%s: labels\n%s\nwant\n%s", test.image.File, got, test.want)
+			continue
+		}
+		if got == "" {
+			continue
+		}
+		for i, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+			index, err := strconv.Atoi(strings.Fields(line)[0])
+			if err != nil || index >= len(names) || names[index] != test.lineClasses[i] {
+				t.Errorf("%s: line %d is %q, want the index classes.txt gives %s", test.image.File, i, line, test.lineClasses[i])
+			}
+		}
+	}
+}
+
+func TestCOCO(t *testing.T) {
+	images := testImages()
+	dataset := COCO(images, testClasses(images))
+
+	wantCategories := []COCOCategory{{ID: 1, Name: "cat"}, {ID: 2, Name: "dog"}}
+	if !reflect.DeepEqual(dataset.Categories, wantCategories) {
+		t.Errorf("categories %+v, want %+v", dataset.Categories, wantCategories)
+	}
+	wantImages := []COCOImage{{ID: 1, FileName: "a.png", Width: 200, Height: 100}, {ID: 2, FileName: "b.jpg", Width: 100, Height: 100}}
+	if !reflect.DeepEqual(dataset.Images, wantImages) {
+		t.Errorf("images %+v, want %+v", dataset.Images, wantImages)
+	}
+	wantAnnotations := []COCOAnnotation{
+		{ID: 1, ImageID: 1, CategoryID: 2, BBox: [4]float64{20, 10, 40, 40}, Area: 1600, Score: 0.9},
+		{ID: 2, ImageID: 1, CategoryID: 1, BBox: [4]float64{100, 0, 100, 100}, Area: 10000},
+		{ID: 3, ImageID: 2, CategoryID: 1, BBox: [4]float64{0, 0, 50, 50}, Area: 2500, Score: 0.5},
+	}
+	if !reflect.DeepEqual(dataset.Annotations, wantAnnotations) {
+		t.Errorf("annotations %+v, want %+v", dataset.Annotations, wantAnnotations)
+	}
+
+	data, err := json.Marshal(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Annotations []map[string]any `json:"annotations"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for i, annotation := range decoded.Annotations {
+		if _, scored := annotation["score"]; scored != (wantAnnotations[i].Score != 0) {
+			t.Errorf("annotation %d: score present %v in %v", i+1, scored, annotation)
+		}
+	}
+}
+
+func TestVOC(t *testing.T) {
+	for _, test := range []struct {
+		image Image
+		want  vocAnnotation
+	}{
+		{
+			image: testImages()[0],
+			want: vocAnnotation{
+				XMLName: xml.Name{Local: "annotation"}, Folder: ImagesDir, Filename: "a.png",
+				Size: vocSize{Width: 200, Height: 100, Depth: 3},
+				Objects: []vocObject{
+					vocBox("dog", 20, 10, 60, 50),
+					vocBox("cat", 100, 0, 200, 100),
+				},
+			},
+		},
+		{
+			image: Image{File: "empty.png", Width: 10, Height: 20, Depth: 1},
+			want: vocAnnotation{
+				XMLName: xml.Name{Local: "annotation"}, Folder: ImagesDir, Filename: "empty.png",
+				Size: vocSize{Width: 10, Height: 20, Depth: 1},
+			},
+		},
+	} {
+		data, err := VOC(test.image)
+		if err != nil {
+			t.Fatalf("%s: %v", test.image.File, err)
+		}
+		if !bytes.HasPrefix(data, []byte(xml.Header+"<annotation>")) {
+			t.Errorf("%s: starts %q", test.image.File, data[:min(len(data), 60)])
+		}
+		var got vocAnnotation
+		if err := xml.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", test.image.File, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.image.File, got, test.want)
+		}
+	}
+}
+
+func vocBox(name string, xMin, yMin, xMax, yMax int) vocObject {
+	object := vocObject{Name: name, Pose: "Unspecified"}
+	object.BndBox.XMin, object.BndBox.YMin, object.BndBox.XMax, object.BndBox.YMax = xMin, yMin, xMax, yMax
+	return object
+}
+
+func TestCSV(t *testing.T) {
+	data, err := CSV(testImages())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"image_id", "file", "width", "height", "class", "x_min", "y_min", "x_max", "y_max", "confidence", "origin", "box_id"},
+		{"a", "a.png", "200", "100", "dog", "20", "10", "60", "50", "0.9000", models.OriginModel, primitive.NilObjectID.Hex()},
+		{"a", "a.png", "200", "100", "cat", "100", "0", "200", "100", "", models.OriginHuman, humanBoxID.Hex()},
+		{"b", "b.jpg", "100", "100", "cat", "0", "0", "50", "50", "0.5000", models.OriginModel, primitive.NilObjectID.Hex()},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows\n%v\nwant\n%v", rows, want)
+	}
+
+	data, err = CSV(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := csv.NewReader(bytes.NewReader(data)).ReadAll(); len(rows) != 1 {
+		t.Errorf("%d rows without images, want just the header", len(rows))
+	}
+}
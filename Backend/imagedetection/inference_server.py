@@ -0,0 +1,192 @@
+"""
+Long-lived YOLOv5 inference worker.
+
+The Go server starts this script once and keeps the model in memory between
+requests. Messages in both directions are length-prefixed JSON: a 4-byte
+big-endian length followed by that many bytes of UTF-8 JSON.
+
+Requests:
+    {"id": 1, "type": "ping"}
+    {"id": 2, "type": "detect", "source": "in.jpg", "output": "out.jpg",
+     "weights": "model.pt", "img": 640, "conf": 0.25, "iou": 0.45,
+     "max_det": 1000, "classes": ["cat"]}
+
+Responses always echo the request id:
+    {"id": 1, "ok": true}
+    {"id": 2, "ok": true, "detections": [...]}
+    {"id": 2, "ok": false, "error": "..."}
+
+"weights" is optional and defaults to the --weights the worker was started
+with. Models are loaded once and kept in memory, up to --max-models of them;
+the least recently used one is dropped to make room for another.
+Detections use the same shape as detect.py's detections.json. Anything the
+model or yolov5 prints goes to stderr so stdout carries protocol frames only.
+"""
+
+import argparse
+import json
+import os
+import struct
+import sys
+import traceback
+from collections import OrderedDict
+from pathlib import Path
+
+# Keep the real stdout for protocol frames and send everything else written to
+# fd 1, including yolov5's logger, to stderr.
+PROTOCOL_OUT = os.fdopen(os.dup(sys.stdout.fileno()), "wb")
+os.dup2(sys.stderr.fileno(), sys.stdout.fileno())
+PROTOCOL_IN = sys.stdin.buffer
+
+ROOT = Path(__file__).resolve().parent / "yolov5"
+if str(ROOT) not in sys.path:
+    sys.path.append(str(ROOT))
+
+import cv2  # noqa: E402
+import torch  # noqa: E402
+
+from models.common import DetectMultiBackend  # noqa: E402
+from utils.dataloaders import LoadImages  # noqa: E402
+from utils.general import check_img_size, non_max_suppression, scale_boxes  # noqa: E402
+from utils.plots import Annotator, colors  # noqa: E402
+from utils.torch_utils import select_device  # noqa: E402
+
+MAX_FRAME_SIZE = 16 << 20
+
+
+def read_frame():
+    """Reads one request, returning None once the Go side closes stdin."""
+    header = PROTOCOL_IN.read(4)
+    if len(header) < 4:
+        return None
+    (length,) = struct.unpack(">I", header)
+    if length > MAX_FRAME_SIZE:
+        raise ValueError(f"frame of {length} bytes exceeds limit")
+    body = PROTOCOL_IN.read(length)
+    if len(body) < length:
+        return None
+    return json.loads(body)
+
+
+def write_frame(payload):
+    body = json.dumps(payload).encode("utf-8")
+    PROTOCOL_OUT.write(struct.pack(">I", len(body)) + body)
+    PROTOCOL_OUT.flush()
+
+
+@torch.no_grad()
+def detect(model, request):
+    imgsz = check_img_size([int(request.get("img", 640))] * 2, s=model.stride)
+    dataset = LoadImages(request["source"], img_size=imgsz, stride=model.stride, auto=model.pt)
+    names = model.names
+    classes = None
+    if request.get("classes"):
+        indexed = names.items() if isinstance(names, dict) else enumerate(names)
+        classes = [i for i, name in indexed if name in request["classes"]]
+
+    detections = []
+    for _, im, im0s, _, _ in dataset:
+        im = torch.from_numpy(im).to(model.device)
+        im = im.half() if model.fp16 else im.float()
+        im /= 255
+        if len(im.shape) == 3:
+            im = im[None]
+
+        pred = model(im)
+        pred = non_max_suppression(
+            pred,
+            float(request.get("conf", 0.25)),
+            float(request.get("iou", 0.45)),
+            classes=classes,
+            max_det=int(request.get("max_det", 1000)),
+        )
+
+        det = pred[0]
+        im0 = im0s.copy()
+        annotator = Annotator(im0, line_width=3, example=str(names))
+        if len(det):
+            det[:, :4] = scale_boxes(im.shape[2:], det[:, :4], im0.shape).round()
+            for *xyxy, conf, cls in reversed(det):
+                c = int(cls)
+                detections.append(
+                    {
+                        "class_id": c,
+                        "class_name": names[c],
+                        "confidence": float(f"{conf:.2f}"),
+                        "bounding_box": {
+                            "x_min": int(xyxy[0]),
+                            "y_min": int(xyxy[1]),
+                            "x_max": int(xyxy[2]),
+                            "y_max": int(xyxy[3]),
+                        },
+                    }
+                )
+                annotator.box_label(xyxy, f"{names[c]} {conf:.2f}", color=colors(c, True))
+
+        Path(request["output"]).parent.mkdir(parents=True, exist_ok=True)
+        cv2.imwrite(request["output"], annotator.result())
+        break
+
+    return detections
+
+
+class ModelCache:
+    """Loads weights files on first use and keeps the most recently used
+    max_models of them for later requests."""
+
+    def __init__(self, device, img, max_models):
+        self.device = device
+        self.img = img
+        self.max_models = max(1, max_models)
+        self.models = OrderedDict()
+
+    def get(self, weights):
+        if weights in self.models:
+            self.models.move_to_end(weights)
+            return self.models[weights]
+
+        while len(self.models) >= self.max_models:
+            _, evicted = self.models.popitem(last=False)
+            del evicted
+            if torch.cuda.is_available():
+                torch.cuda.empty_cache()
+
+        model = DetectMultiBackend(weights, device=self.device)
+        imgsz = check_img_size([self.img] * 2, s=model.stride)
+        model.warmup(imgsz=(1, 3, *imgsz))
+        self.models[weights] = model
+        return model
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--weights", required=True)
+    parser.add_argument("--device", default="")
+    parser.add_argument("--img", type=int, default=640)
+    parser.add_argument("--max-models", type=int, default=4)
+    opt = parser.parse_args()
+
+    cache = ModelCache(select_device(opt.device), opt.img, opt.max_models)
+    cache.get(opt.weights)
+
+    while True:
+        request = read_frame()
+        if request is None:
+            return
+
+        request_id = request.get("id")
+        try:
+            if request.get("type") == "ping":
+                write_frame({"id": request_id, "ok": True})
+            elif request.get("type") == "detect":
+                model = cache.get(request.get("weights") or opt.weights)
+                write_frame({"id": request_id, "ok": True, "detections": detect(model, request)})
+            else:
+                write_frame({"id": request_id, "ok": False, "error": f"unknown request type {request.get('type')!r}"})
+        except Exception as e:
+            traceback.print_exc(file=sys.stderr)
+            write_frame({"id": request_id, "ok": False, "error": str(e)})
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,85 @@
+package imagemeta
+
+import "encoding/binary"
+
+const tagOrientation = 0x0112
+
+// ifd0 returns the byte order of the TIFF structure an EXIF block holds and
+// the offset of its first directory.
+func ifd0(tiff []byte) (binary.ByteOrder, int, bool) {
+	if len(tiff) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil, 0, false
+	}
+	return order, offset, true
+}
+
+// findEntry returns the offset of the 12-byte entry for tag in the
+// directory at ifd, and the offset just past the directory's entries where
+// the link to the next directory is.
+func findEntry(tiff []byte, order binary.ByteOrder, ifd int, tag uint16) (entry int, next int, found bool) {
+	count := int(order.Uint16(tiff[ifd:]))
+	next = ifd + 2 + 12*count
+	if next+4 > len(tiff) {
+		return 0, 0, false
+	}
+	for i := 0; i < count; i++ {
+		entry = ifd + 2 + 12*i
+		if order.Uint16(tiff[entry:]) == tag {
+			return entry, next, true
+		}
+	}
+	return 0, next, false
+}
+
+// exifOrientation reads the orientation tag of the TIFF structure in an
+// EXIF block, 1 (upright) when it is missing or unreadable.
+func exifOrientation(tiff []byte) int {
+	order, ifd, ok := ifd0(tiff)
+	if !ok {
+		return 1
+	}
+	entry, _, found := findEntry(tiff, order, ifd, tagOrientation)
+	if !found {
+		return 1
+	}
+	return int(order.Uint16(tiff[entry+8:]))
+}
+
+// uprightExif returns a copy of the APP1 segment holding an EXIF block with
+// its orientation set to upright.
+func uprightExif(app1 []byte) []byte {
+	header := 4 + len(exifHeader)
+	return append(append([]byte(nil), app1[:header]...), uprightTIFF(app1[header:])...)
+}
+
+// uprightTIFF returns a copy of the TIFF structure of an EXIF block with its
+// orientation set to upright. The link to the embedded thumbnail's
+// directory is cut too, since the thumbnail is still turned the old way.
+func uprightTIFF(tiff []byte) []byte {
+	upright := append([]byte(nil), tiff...)
+	order, ifd, ok := ifd0(upright)
+	if !ok {
+		return upright
+	}
+	entry, next, found := findEntry(upright, order, ifd, tagOrientation)
+	if found {
+		order.PutUint16(upright[entry+8:], 1)
+	}
+	if next > 0 {
+		order.PutUint32(upright[next:], 0)
+	}
+	return upright
+}
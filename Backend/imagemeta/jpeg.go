@@ -0,0 +1,161 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+)
+
+const normalizedQuality = 95
+
+var errNotJPEG = errors.New("imagemeta: not a JPEG file")
+
+// Headers of the APP1 segments that carry EXIF and XMP, and of the APP2
+// segments that carry an ICC colour profile.
+var (
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	iccHeader         = []byte("ICC_PROFILE\x00")
+)
+
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+)
+
+// segment is one marker segment of a JPEG file, from its 0xFF to the end of
+// its payload.
+type segment struct {
+	marker byte
+	data   []byte
+}
+
+func (s segment) payload() []byte {
+	return s.data[4:]
+}
+
+func (s segment) is(marker byte, header []byte) bool {
+	return s.marker == marker && bytes.HasPrefix(s.payload(), header)
+}
+
+// jpegSegments splits data into the segments before its first scan and the
+// remainder, which starts at the start-of-scan marker.
+func jpegSegments(data []byte) ([]segment, []byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, errNotJPEG
+	}
+
+	var segments []segment
+	pos := 2
+	for {
+		if pos >= len(data) || data[pos] != 0xff {
+			return nil, nil, errNotJPEG
+		}
+		// Any number of 0xFF fill bytes may precede a marker; they are
+		// dropped.
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, nil, errNotJPEG
+		}
+		start := pos - 1
+		marker := data[pos]
+		pos++
+		if marker == markerSOS {
+			return segments, data[start:], nil
+		}
+		if pos+2 > len(data) {
+			return nil, nil, errNotJPEG
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, nil, errNotJPEG
+		}
+		pos += length
+		segments = append(segments, segment{marker: marker, data: data[start:pos]})
+	}
+}
+
+// normalizeJPEG prepares a JPEG file for storage. A photo whose EXIF
+// orientation is not upright is decoded, turned and encoded again so that
+// its pixels are the way it is displayed. Unless keepLocation is set, the
+// EXIF and XMP blocks, which hold the GPS position, are left out. With
+// keepLocation the EXIF block is kept, marked upright. changed reports
+// whether the result differs from data.
+func normalizeJPEG(data []byte, keepLocation bool) (normalized []byte, changed bool, err error) {
+	segments, scan, err := jpegSegments(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var exifSegment *segment
+	for i := range segments {
+		if segments[i].is(markerAPP1, exifHeader) {
+			exifSegment = &segments[i]
+			break
+		}
+	}
+	orientation := 1
+	if exifSegment != nil {
+		orientation = exifOrientation(exifSegment.payload()[len(exifHeader):])
+	}
+
+	if orientation < 2 || orientation > 8 {
+		if keepLocation {
+			return data, false, nil
+		}
+		if stripped, ok := withoutMetadata(segments, scan); ok {
+			return stripped, true, nil
+		}
+		return data, false, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, Orient(img, orientation), &jpeg.Options{Quality: normalizedQuality}); err != nil {
+		return nil, false, err
+	}
+
+	// The encoder writes no metadata at all, so carry over the colour
+	// profile and, if asked to, the EXIF block.
+	var kept bytes.Buffer
+	kept.Write(encoded.Bytes()[:2])
+	if keepLocation && exifSegment != nil {
+		kept.Write(uprightExif(exifSegment.data))
+	}
+	for _, s := range segments {
+		if s.is(markerAPP2, iccHeader) {
+			kept.Write(s.data)
+		}
+	}
+	kept.Write(encoded.Bytes()[2:])
+	return kept.Bytes(), true, nil
+}
+
+// withoutMetadata reassembles a JPEG file from its segments, leaving out the
+// EXIF and XMP blocks. It reports false when there were none.
+func withoutMetadata(segments []segment, scan []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	out.Write([]byte{0xff, markerSOI})
+	removed := false
+	for _, s := range segments {
+		if s.is(markerAPP1, exifHeader) || s.is(markerAPP1, xmpHeader) || s.is(markerAPP1, xmpExtendedHeader) {
+			removed = true
+			continue
+		}
+		out.Write(s.data)
+	}
+	if !removed {
+		return nil, false
+	}
+	out.Write(scan)
+	return out.Bytes(), true
+}
@@ -0,0 +1,81 @@
+// Package imagemeta reads the EXIF block of uploaded photos and prepares the
+// copy that is stored: turned upright, so detect.py sees what the user sees,
+// and without the camera's location unless the user chose to keep it.
+package imagemeta
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Extract returns what the EXIF block of an image file of the given format
+// records about the photo, or nil when the file has none.
+func Extract(data []byte, format string) *models.ImageMetadata {
+	switch format {
+	case FormatPNG:
+		data = pngExif(data)
+	case FormatWebP:
+		data = webpExif(data)
+	case FormatJPEG, FormatTIFF:
+	default:
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	metadata := &models.ImageMetadata{
+		CameraMake:  stringTag(x, exif.Make),
+		CameraModel: stringTag(x, exif.Model),
+		LensModel:   stringTag(x, exif.LensModel),
+	}
+	if capturedAt, err := x.DateTime(); err == nil {
+		metadata.CapturedAt = &capturedAt
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		metadata.Orientation, _ = tag.Int(0)
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		metadata.Location = &models.GeoLocation{Latitude: lat, Longitude: long, Altitude: altitude(x)}
+	}
+	return metadata
+}
+
+func stringTag(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// altitude is the recorded GPS altitude in metres, negative below sea level.
+func altitude(x *exif.Exif) *float64 {
+	tag, err := x.Get(exif.GPSAltitude)
+	if err != nil {
+		return nil
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return nil
+	}
+	metres := float64(num) / float64(den)
+	if ref, err := x.Get(exif.GPSAltitudeRef); err == nil {
+		if below, _ := ref.Int(0); below == 1 {
+			metres = -metres
+		}
+	}
+	return &metres
+}
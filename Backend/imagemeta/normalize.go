@@ -0,0 +1,38 @@
+package imagemeta
+
+import "fmt"
+
+// Formats as image.DecodeConfig names them.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+)
+
+// Normalize returns the data of an image file of the given format as it
+// should be stored: turned upright when its EXIF orientation says it is
+// displayed turned, and without the metadata that can hold the camera's
+// location unless keepLocation is set. A WebP file that has to be turned
+// is stored as PNG, as there is no WebP encoder, so the format of the
+// result is returned too. changed reports whether the result differs from
+// data.
+func Normalize(data []byte, format string, keepLocation bool) (normalized []byte, normalizedFormat string, changed bool, err error) {
+	switch format {
+	case FormatJPEG:
+		normalized, changed, err = normalizeJPEG(data, keepLocation)
+	case FormatPNG:
+		normalized, changed, err = normalizePNG(data, keepLocation)
+	case FormatWebP:
+		return normalizeWebP(data, keepLocation)
+	case FormatTIFF:
+		normalized, changed, err = normalizeTIFF(data, keepLocation)
+	case FormatBMP:
+		// BMP files carry no metadata and no orientation.
+		return data, format, false, nil
+	default:
+		return nil, "", false, fmt.Errorf("imagemeta: cannot normalize %s files", format)
+	}
+	return normalized, format, changed, err
+}
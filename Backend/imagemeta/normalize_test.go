@@ -0,0 +1,371 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"slices"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// tiffEntry is a directory entry of a TIFF structure built for a test.
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+func shortEntry(tag uint16, value uint16) tiffEntry {
+	return tiffEntry{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+func longEntry(tag uint16, value uint32) tiffEntry {
+	return tiffEntry{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, value)}
+}
+
+func asciiEntry(tag uint16, value string) tiffEntry {
+	return tiffEntry{tag, 2, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func rationalEntry(tag uint16, values ...uint32) tiffEntry {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	return tiffEntry{tag, 5, uint32(len(values) / 2), data}
+}
+
+// gpsEntries place the camera at 13°45'N 100°30'E.
+var gpsEntries = []tiffEntry{
+	asciiEntry(0x0001, "N"),
+	rationalEntry(0x0002, 13, 1, 45, 1, 0, 1),
+	asciiEntry(0x0003, "E"),
+	rationalEntry(0x0004, 100, 1, 30, 1, 0, 1),
+}
+
+// buildTIFF lays out a little-endian TIFF structure with the entries of its
+// first directory, a GPS directory when gps is set and, when pixels is
+// set, a single strip of them.
+func buildTIFF(entries []tiffEntry, gps []tiffEntry, pixels []byte) []byte {
+	entries = slices.Clone(entries)
+	if gps != nil {
+		entries = append(entries, longEntry(0x8825, 0))
+	}
+	if pixels != nil {
+		entries = append(entries, longEntry(0x0111, 0), longEntry(0x0117, uint32(len(pixels))))
+	}
+	slices.SortFunc(entries, func(a, b tiffEntry) int { return int(a.tag) - int(b.tag) })
+
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	gpsOffset := 8 + ifdSize(len(entries))
+	dataOffset := gpsOffset
+	if gps != nil {
+		dataOffset += ifdSize(len(gps))
+	}
+	var data []byte
+	place := func(value []byte) uint32 {
+		offset := dataOffset + len(data)
+		data = append(data, value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+		return uint32(offset)
+	}
+
+	writeIFD := func(out []byte, ifd []tiffEntry) []byte {
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(ifd)))
+		for _, e := range ifd {
+			out = binary.LittleEndian.AppendUint16(out, e.tag)
+			out = binary.LittleEndian.AppendUint16(out, e.kind)
+			out = binary.LittleEndian.AppendUint32(out, e.count)
+			if len(e.value) > 4 {
+				out = binary.LittleEndian.AppendUint32(out, place(e.value))
+			} else {
+				value := make([]byte, 4)
+				copy(value, e.value)
+				out = append(out, value...)
+			}
+		}
+		return binary.LittleEndian.AppendUint32(out, 0)
+	}
+
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	out = writeIFD(out, entries)
+	if gps != nil {
+		out = writeIFD(out, gps)
+	}
+	pixelOffset := dataOffset + len(data)
+	out = append(out, data...)
+	out = append(out, pixels...)
+
+	// Point the GPS and strip entries at what they refer to.
+	for i, e := range entries {
+		at := 8 + 2 + 12*i + 8
+		switch e.tag {
+		case 0x8825:
+			binary.LittleEndian.PutUint32(out[at:], uint32(gpsOffset))
+		case 0x0111:
+			binary.LittleEndian.PutUint32(out[at:], uint32(pixelOffset))
+		}
+	}
+	return out
+}
+
+// exifBlock is an EXIF block with the given orientation and a location.
+func exifBlock(orientation uint16) []byte {
+	return buildTIFF([]tiffEntry{asciiEntry(0x010f, "Camera"), shortEntry(0x0112, orientation)}, gpsEntries, nil)
+}
+
+// testImage is a white 32x16 image with a red 8x8 block in its top left
+// corner, big enough to survive JPEG's colour subsampling.
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{0xff, 0, 0, 0xff})
+		}
+	}
+	return img
+}
+
+// checkNormalized decodes a normalized file and checks its size, where the
+// red pixel ended up and whether it still has a location.
+func checkNormalized(t *testing.T, data []byte, format string, width, height int, red image.Point, location bool) {
+	t.Helper()
+	img, decodedFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode normalized file: %v", err)
+	}
+	if decodedFormat != format {
+		t.Errorf("normalized file is %s, want %s", decodedFormat, format)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(width, height) {
+		t.Errorf("normalized size %v, want %dx%d", got, width, height)
+	}
+	if r, g, _, _ := img.At(red.X, red.Y).RGBA(); r < 0xc000 || g > 0x4000 {
+		t.Errorf("pixel %v is not red", red)
+	}
+	metadata := Extract(data, format)
+	if got := metadata != nil && metadata.Location != nil; got != location {
+		t.Errorf("normalized file has a location: %v, want %v", got, location)
+	}
+	if metadata != nil && metadata.Orientation > 1 {
+		t.Errorf("normalized file has orientation %d", metadata.Orientation)
+	}
+}
+
+func TestNormalizeJPEG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	app1 := append([]byte{0xff, markerAPP1, 0, 0}, exifHeader...)
+	app1 = append(app1, exifBlock(6)...)
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(app1)-2))
+	data := append(append([]byte{0xff, markerSOI}, app1...), encoded.Bytes()[2:]...)
+
+	if metadata := Extract(data, FormatJPEG); metadata == nil || metadata.Location == nil || metadata.Orientation != 6 {
+		t.Fatalf("Extract = %+v", metadata)
+	}
+	for _, keepLocation := range []bool{false, true} {
+		normalized, format, changed, err := Normalize(data, FormatJPEG, keepLocation)
+		if err != nil || !changed {
+			t.Fatalf("Normalize = %v, %v", changed, err)
+		}
+		checkNormalized(t, normalized, format, 16, 32, image.Pt(13, 2), keepLocation)
+	}
+}
+
+func TestNormalizePNG(t *testing.T) {
+	plain, _, err := encodePNG(testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := newChunk("tEXt", []byte("Comment\x00taken at home"))
+	upright, _, _ := encodePNG(testImage(), [][]byte{newChunk("eXIf", exifBlock(1)), text})
+	turned, _, _ := encodePNG(testImage(), [][]byte{newChunk("eXIf", exifBlock(6)), text})
+
+	if metadata := Extract(turned, FormatPNG); metadata == nil || metadata.Location == nil || metadata.Orientation != 6 {
+		t.Fatalf("Extract = %+v", metadata)
+	}
+
+	normalized, format, changed, err := Normalize(upright, FormatPNG, false)
+	if err != nil || !changed {
+		t.Fatalf("Normalize = %v, %v", changed, err)
+	}
+	if !bytes.Equal(normalized, plain) {
+		t.Error("upright PNG not stripped down to its pixels")
+	}
+	checkNormalized(t, normalized, format, 32, 16, image.Pt(2, 2), false)
+
+	if normalized, _, changed, _ := Normalize(upright, FormatPNG, true); changed || !bytes.Equal(normalized, upright) {
+		t.Error("upright PNG changed although its location was kept")
+	}
+	if _, _, changed, _ := Normalize(plain, FormatPNG, false); changed {
+		t.Error("PNG without metadata changed")
+	}
+
+	for _, keepLocation := range []bool{false, true} {
+		normalized, format, changed, err := Normalize(turned, FormatPNG, keepLocation)
+		if err != nil || !changed {
+			t.Fatalf("Normalize = %v, %v", changed, err)
+		}
+		checkNormalized(t, normalized, format, 16, 32, image.Pt(13, 2), keepLocation)
+	}
+}
+
+// extendedWebP wraps a simple WebP file in the extended format with the
+// given EXIF block and an XMP packet.
+func extendedWebP(t *testing.T, simple []byte, tiff []byte) []byte {
+	t.Helper()
+	config, err := webp.DecodeConfig(bytes.NewReader(simple))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(kind string, payload []byte) []byte {
+		out := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+		out = append(out, payload...)
+		if len(payload)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+	vp8x := []byte{vp8xFlagEXIF | vp8xFlagXMP, 0, 0, 0}
+	vp8x = append(vp8x, byte(config.Width-1), byte((config.Width-1)>>8), byte((config.Width-1)>>16))
+	vp8x = append(vp8x, byte(config.Height-1), byte((config.Height-1)>>8), byte((config.Height-1)>>16))
+
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", vp8x)...)
+	body = append(body, simple[12:]...)
+	body = append(body, chunk("EXIF", tiff)...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta>home</x:xmpmeta>"))...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestNormalizeWebP(t *testing.T) {
+	simple, err := os.ReadFile("testdata/gopher.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := webp.DecodeConfig(bytes.NewReader(simple))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upright := extendedWebP(t, simple, exifBlock(1))
+	turned := extendedWebP(t, simple, exifBlock(6))
+
+	if metadata := Extract(turned, FormatWebP); metadata == nil || metadata.Location == nil || metadata.Orientation != 6 {
+		t.Fatalf("Extract = %+v", metadata)
+	}
+
+	normalized, format, changed, err := Normalize(upright, FormatWebP, false)
+	if err != nil || !changed || format != FormatWebP {
+		t.Fatalf("Normalize = %s, %v, %v", format, changed, err)
+	}
+	chunks, err := webpChunks(normalized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if c.kind == "EXIF" || c.kind == "XMP " {
+			t.Errorf("%s chunk kept", c.kind)
+		}
+		if c.kind == "VP8X" && c.payload()[0]&(vp8xFlagEXIF|vp8xFlagXMP) != 0 {
+			t.Error("VP8X still announces metadata")
+		}
+	}
+	if size := binary.LittleEndian.Uint32(normalized[4:]); int(size) != len(normalized)-8 {
+		t.Errorf("RIFF size %d, file has %d bytes", size, len(normalized)-8)
+	}
+	img, err := webp.Decode(bytes.NewReader(normalized))
+	if err != nil || img.Bounds().Dx() != config.Width {
+		t.Errorf("stripped WebP does not decode: %v", err)
+	}
+	if Extract(normalized, FormatWebP) != nil {
+		t.Error("stripped WebP has EXIF")
+	}
+
+	if _, _, changed, _ := Normalize(upright, FormatWebP, true); changed {
+		t.Error("upright WebP changed although its location was kept")
+	}
+
+	for _, keepLocation := range []bool{false, true} {
+		normalized, format, changed, err := Normalize(turned, FormatWebP, keepLocation)
+		if err != nil || !changed || format != FormatPNG {
+			t.Fatalf("Normalize = %s, %v, %v", format, changed, err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(normalized))
+		if err != nil {
+			t.Fatalf("decode turned WebP: %v", err)
+		}
+		if got := img.Bounds().Size(); got != image.Pt(config.Height, config.Width) {
+			t.Errorf("turned size %v, want %dx%d", got, config.Height, config.Width)
+		}
+		metadata := Extract(normalized, format)
+		if got := metadata != nil && metadata.Location != nil; got != keepLocation {
+			t.Errorf("turned WebP has a location: %v, want %v", got, keepLocation)
+		}
+	}
+}
+
+// rgbTIFF is a TIFF file of testImage with the given orientation and a
+// location.
+func rgbTIFF(orientation uint16) []byte {
+	img := testImage()
+	var pixels []byte
+	for i := 0; i < len(img.Pix); i += 4 {
+		pixels = append(pixels, img.Pix[i:i+3]...)
+	}
+	return buildTIFF([]tiffEntry{
+		longEntry(0x0100, 32),
+		longEntry(0x0101, 16),
+		tiffEntry{0x0102, 3, 3, []byte{8, 0, 8, 0, 8, 0}},
+		shortEntry(0x0103, 1),
+		shortEntry(0x0106, 2),
+		asciiEntry(0x010f, "Camera"),
+		shortEntry(0x0112, orientation),
+		shortEntry(0x0115, 3),
+		longEntry(0x0116, 16),
+	}, gpsEntries, pixels)
+}
+
+func TestNormalizeTIFF(t *testing.T) {
+	upright := rgbTIFF(1)
+	turned := rgbTIFF(6)
+
+	if metadata := Extract(turned, FormatTIFF); metadata == nil || metadata.Location == nil || metadata.Orientation != 6 {
+		t.Fatalf("Extract = %+v", metadata)
+	}
+
+	normalized, format, changed, err := Normalize(upright, FormatTIFF, false)
+	if err != nil || !changed {
+		t.Fatalf("Normalize = %v, %v", changed, err)
+	}
+	checkNormalized(t, normalized, format, 32, 16, image.Pt(2, 2), false)
+
+	if normalized, _, changed, _ := Normalize(upright, FormatTIFF, true); changed || !bytes.Equal(normalized, upright) {
+		t.Error("upright TIFF changed although its location was kept")
+	}
+	if _, _, changed, _ := Normalize(normalized, FormatTIFF, false); changed {
+		t.Error("TIFF without metadata changed")
+	}
+
+	// Turning a TIFF file writes it again without its metadata, so even
+	// with keepLocation the location is gone.
+	for _, keepLocation := range []bool{false, true} {
+		normalized, format, changed, err := Normalize(turned, FormatTIFF, keepLocation)
+		if err != nil || !changed {
+			t.Fatalf("Normalize = %v, %v", changed, err)
+		}
+		checkNormalized(t, normalized, format, 16, 32, image.Pt(13, 2), false)
+	}
+}
@@ -0,0 +1,45 @@
+package imagemeta
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Orient returns img turned and mirrored the way an EXIF orientation of 2
+// to 8 says it is displayed. Other orientations return img unchanged.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	// source maps a pixel of the result to the pixel of img it shows.
+	source := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return y, h - 1 - x },
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		8: func(x, y int) (int, int) { return w - 1 - y, x },
+	}[orientation]
+
+	for y := 0; y < dh; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(row[4*x:4*x+4], src.Pix[sy*src.Stride+4*sx:])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,140 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+)
+
+var errNotPNG = errors.New("imagemeta: not a PNG file")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// chunk is one chunk of a PNG file, from its length to its CRC.
+type chunk struct {
+	kind string
+	data []byte
+}
+
+func (c chunk) payload() []byte {
+	return c.data[8 : len(c.data)-4]
+}
+
+// pngMetadataChunks can hold the camera's location: eXIf holds an EXIF
+// block, and the text chunks XMP packets and raw EXIF profiles.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true}
+
+// pngColourChunks describe the colours of the image and are carried over
+// when it is encoded again.
+var pngColourChunks = map[string]bool{"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true}
+
+// pngChunks splits data into its chunks.
+func pngChunks(data []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errNotPNG
+	}
+	var chunks []chunk
+	for pos := len(pngSignature); pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, errNotPNG
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errNotPNG
+		}
+		chunks = append(chunks, chunk{kind: string(data[pos+4 : pos+8]), data: data[pos:end]})
+		pos = end
+	}
+	return chunks, nil
+}
+
+// newChunk builds a chunk of the given type around payload.
+func newChunk(kind string, payload []byte) []byte {
+	out := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(len(payload)))
+	copy(out[4:], kind)
+	out = append(out, payload...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[4:]))
+}
+
+// pngExif returns the TIFF structure of a PNG file's eXIf chunk, or nil.
+func pngExif(data []byte) []byte {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, c := range chunks {
+		if c.kind == "eXIf" {
+			return c.payload()
+		}
+	}
+	return nil
+}
+
+// normalizePNG prepares a PNG file for storage the way normalizeJPEG does a
+// JPEG file, going by the orientation in its eXIf chunk. Without
+// keepLocation the eXIf and text chunks are left out.
+func normalizePNG(data []byte, keepLocation bool) ([]byte, bool, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, false, err
+	}
+	tiff := pngExif(data)
+	orientation := 1
+	if tiff != nil {
+		orientation = exifOrientation(tiff)
+	}
+
+	if orientation < 2 || orientation > 8 {
+		if keepLocation {
+			return data, false, nil
+		}
+		var out bytes.Buffer
+		out.Write(pngSignature)
+		for _, c := range chunks {
+			if !pngMetadataChunks[c.kind] {
+				out.Write(c.data)
+			}
+		}
+		if out.Len() == len(data) {
+			return data, false, nil
+		}
+		return out.Bytes(), true, nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	var kept [][]byte
+	for _, c := range chunks {
+		if pngColourChunks[c.kind] {
+			kept = append(kept, c.data)
+		}
+	}
+	if keepLocation {
+		kept = append(kept, newChunk("eXIf", uprightTIFF(tiff)))
+	}
+	return encodePNG(Orient(img, orientation), kept)
+}
+
+// encodePNG encodes img with the given chunks inserted after its header.
+func encodePNG(img image.Image, kept [][]byte) ([]byte, bool, error) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return nil, false, err
+	}
+	// The encoder starts with the signature and the 25-byte IHDR chunk.
+	header := len(pngSignature) + 25
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:header])
+	for _, c := range kept {
+		out.Write(c)
+	}
+	out.Write(encoded.Bytes()[header:])
+	return out.Bytes(), true, nil
+}
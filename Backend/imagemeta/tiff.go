@@ -0,0 +1,54 @@
+package imagemeta
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/image/tiff"
+)
+
+var errNotTIFF = errors.New("imagemeta: not a TIFF file")
+
+// Tags of a TIFF file's first directory that point at metadata which can
+// hold the camera's location: the GPS directory, XMP, IPTC and Photoshop
+// resources.
+var tiffLocationTags = []uint16{0x8825, 0x02bc, 0x83bb, 0x8649}
+
+// normalizeTIFF prepares a TIFF file for storage. The orientation and any
+// metadata live in the file's own directory, so a file that has to be
+// turned, or has metadata to drop, is decoded and encoded again, which
+// writes only the tags describing the pixels. With keepLocation an upright
+// file is stored as it is, but a turned one still loses its metadata.
+func normalizeTIFF(data []byte, keepLocation bool) ([]byte, bool, error) {
+	order, ifd, ok := ifd0(data)
+	if !ok {
+		return nil, false, errNotTIFF
+	}
+	orientation := exifOrientation(data)
+	upright := orientation < 2 || orientation > 8
+
+	if upright {
+		if keepLocation {
+			return data, false, nil
+		}
+		hasLocation := false
+		for _, tag := range tiffLocationTags {
+			if _, _, found := findEntry(data, order, ifd, tag); found {
+				hasLocation = true
+			}
+		}
+		if !hasLocation {
+			return data, false, nil
+		}
+	}
+
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	var encoded bytes.Buffer
+	if err := tiff.Encode(&encoded, Orient(img, orientation), &tiff.Options{Compression: tiff.Deflate, Predictor: true}); err != nil {
+		return nil, false, err
+	}
+	return encoded.Bytes(), true, nil
+}
@@ -0,0 +1,151 @@
+package imagemeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/image/webp"
+)
+
+var errNotWebP = errors.New("imagemeta: not a WebP file")
+
+// Flags of the VP8X chunk saying the file has EXIF and XMP chunks.
+const (
+	vp8xFlagEXIF = 0x08
+	vp8xFlagXMP  = 0x04
+)
+
+// riffChunk is one chunk of a WebP file, with its padding.
+type riffChunk struct {
+	kind string
+	data []byte
+}
+
+func (c riffChunk) payload() []byte {
+	size := int(binary.LittleEndian.Uint32(c.data[4:]))
+	return c.data[8 : 8+size]
+}
+
+// webpChunks splits data into the chunks inside its RIFF header.
+func webpChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errNotWebP
+	}
+	var chunks []riffChunk
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errNotWebP
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || pos+8+size > len(data) {
+			return nil, errNotWebP
+		}
+		// The padding byte of a last odd-sized chunk is sometimes missing.
+		end = min(end, len(data))
+		chunks = append(chunks, riffChunk{kind: string(data[pos : pos+4]), data: data[pos:end]})
+		pos = end
+	}
+	return chunks, nil
+}
+
+// webpExif returns the TIFF structure of a WebP file's EXIF chunk, or nil.
+// Some writers put the JPEG "Exif" header in front of it.
+func webpExif(data []byte) []byte {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, c := range chunks {
+		if c.kind == "EXIF" {
+			return bytes.TrimPrefix(c.payload(), exifHeader)
+		}
+	}
+	return nil
+}
+
+// normalizeWebP prepares a WebP file for storage. Without keepLocation its
+// EXIF and XMP chunks are left out. A file whose EXIF orientation is not
+// upright is decoded, turned and stored as PNG, keeping its colour profile
+// and, with keepLocation, its EXIF block marked upright.
+func normalizeWebP(data []byte, keepLocation bool) ([]byte, string, bool, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, "", false, err
+	}
+	tiff := webpExif(data)
+	orientation := 1
+	if tiff != nil {
+		orientation = exifOrientation(tiff)
+	}
+
+	if orientation < 2 || orientation > 8 {
+		if keepLocation {
+			return data, FormatWebP, false, nil
+		}
+		stripped, changed := webpWithoutMetadata(chunks)
+		if !changed {
+			return data, FormatWebP, false, nil
+		}
+		return stripped, FormatWebP, true, nil
+	}
+
+	img, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false, err
+	}
+	var kept [][]byte
+	for _, c := range chunks {
+		if c.kind == "ICCP" {
+			kept = append(kept, iccpChunk(c.payload()))
+		}
+	}
+	if keepLocation {
+		kept = append(kept, newChunk("eXIf", uprightTIFF(tiff)))
+	}
+	normalized, changed, err := encodePNG(Orient(img, orientation), kept)
+	return normalized, FormatPNG, changed, err
+}
+
+// webpWithoutMetadata reassembles a WebP file from its chunks, leaving out
+// the EXIF and XMP chunks and clearing the flags announcing them. It
+// reports false when there were none.
+func webpWithoutMetadata(chunks []riffChunk) ([]byte, bool) {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	removed := false
+	for _, c := range chunks {
+		switch c.kind {
+		case "EXIF", "XMP ":
+			removed = true
+		case "VP8X":
+			vp8x := append([]byte(nil), c.data...)
+			if len(vp8x) > 8 {
+				vp8x[8] &^= vp8xFlagEXIF | vp8xFlagXMP
+			}
+			body.Write(vp8x)
+		default:
+			body.Write(c.data)
+		}
+	}
+	if !removed {
+		return nil, false
+	}
+
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(body.Len()))
+	return append(out, body.Bytes()...), true
+}
+
+// iccpChunk carries a raw ICC profile over into a PNG iCCP chunk.
+func iccpChunk(profile []byte) []byte {
+	var payload bytes.Buffer
+	payload.WriteString("ICC Profile\x00\x00")
+	w := zlib.NewWriter(&payload)
+	w.Write(profile)
+	w.Close()
+	return newChunk("iCCP", payload.Bytes())
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BatchProgress counts the images of a batch by status.
+func BatchProgress(ctx context.Context, batchID primitive.ObjectID) (models.BatchProgress, error) {
+	progress := models.BatchProgress{Batch: batchID}
+
+	cursor, err := imageCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"batch": batchID}},
+		bson.M{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return progress, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return progress, err
+	}
+
+	for _, count := range counts {
+		progress.Total += count.Count
+		switch count.Status {
+		case models.StatusPending:
+			progress.Pending = count.Count
+		case models.StatusProcessing:
+			progress.Processing = count.Count
+		case models.StatusSuccess:
+			progress.Success = count.Count
+		case models.StatusFail:
+			progress.Fail = count.Count
+		}
+	}
+	finished := progress.Success + progress.Fail
+	progress.Done = finished == progress.Total
+	if progress.Total > 0 {
+		progress.Percent = math.Round(float64(finished)*1000/float64(progress.Total)) / 10
+	}
+	return progress, nil
+}
+
+// publishBatchProgress tells the owner of a batch how far it has got.
+func publishBatchProgress(user primitive.ObjectID, batchID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	progress, err := BatchProgress(ctx, batchID)
+	if err != nil {
+		log.Printf("jobs: failed to count progress of batch %s: %v", batchID.Hex(), err)
+		return
+	}
+	events.Publish(user, events.TypeBatchProgress, progress)
+}
@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func generateImagePath(originalFilename string) string {
+	timestamp := time.Now().UnixNano()
+	ext := filepath.Ext(originalFilename)
+	return fmt.Sprintf("public/images/%d%s", timestamp, ext)
+}
+
+// modelWeights returns the weights of the registered model the image was
+// uploaded with, or "" for the detector's configured weights.
+func modelWeights(ctx context.Context, image models.Image) (string, error) {
+	if image.ModelID.IsZero() {
+		return "", nil
+	}
+
+	var model models.DetectionModel
+	err := detectionModelCollection.FindOne(ctx, bson.M{"_id": image.ModelID}).Decode(&model)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("model %s:%s is no longer registered", image.Model, image.ModelVersion)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find model: %w", err)
+	}
+	return model.WeightsPath, nil
+}
+
+// runDetection runs the configured detector on the stored image and returns
+// its result together with the path of the saved annotated image.
+func runDetection(ctx context.Context, image models.Image) (*detector.Result, string, error) {
+	weightsPath, err := modelWeights(ctx, image)
+	if err != nil {
+		return nil, "", err
+	}
+
+	object, _, err := storage.Default.Get(ctx, image.ImagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open image file: %w", err)
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image file: %w", err)
+	}
+
+	result, err := imageDetector.Detect(ctx, detector.Input{
+		Data:        data,
+		Filename:    filepath.Base(image.ImagePath),
+		JobID:       image.ID.Hex(),
+		WeightsPath: weightsPath,
+		Params:      image.Params,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	detectedImageSavePath := generateImagePath("detected" + result.AnnotatedImageExt)
+
+	err = storage.Default.Put(ctx, detectedImageSavePath, bytes.NewReader(result.AnnotatedImage),
+		int64(len(result.AnnotatedImage)), storage.ContentType(detectedImageSavePath))
+	if err != nil {
+		return nil, "", errors.New("failed to save detected image file")
+	}
+
+	return result, detectedImageSavePath, nil
+}
@@ -0,0 +1,282 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/events"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var imageCollection *mongo.Collection = configs.GetCollection(configs.DB, "images")
+var detectionModelCollection *mongo.Collection = configs.GetCollection(configs.DB, "models")
+var detectionRunCollection *mongo.Collection = configs.GetCollection(configs.DB, "detection_runs")
+
+var ErrQueueFull = errors.New("detection queue is full")
+
+// How often images still marked as pending are swept back into the queue.
+// This covers uploads that arrived while the queue was full.
+const pendingSweepInterval = time.Minute
+
+var detectionQueue chan primitive.ObjectID
+
+var imageDetector detector.Detector
+
+// StartDetectionWorkers starts a fixed pool of workers running d and
+// re-enqueues every image left in the pending state by a previous run.
+func StartDetectionWorkers(d detector.Detector, workers int, queueSize int) {
+	imageDetector = d
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	detectionQueue = make(chan primitive.ObjectID, queueSize)
+
+	resetInterruptedJobs()
+
+	for i := 0; i < workers; i++ {
+		go detectionWorker()
+	}
+
+	go func() {
+		for {
+			enqueuePendingImages()
+			time.Sleep(pendingSweepInterval)
+		}
+	}()
+}
+
+// EnqueueDetection schedules detection for an image already stored with the
+// pending status. It never blocks; when the queue is full the image stays
+// pending and is picked up by the next sweep.
+func EnqueueDetection(imageID primitive.ObjectID) error {
+	select {
+	case detectionQueue <- imageID:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// resetInterruptedJobs moves images that were processing when the server
+// stopped back to pending so they are detected again. The runs they were in
+// the middle of are marked as failed.
+func resetInterruptedJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := detectionRunCollection.UpdateMany(ctx, bson.M{"status": models.StatusProcessing}, bson.M{
+		"$set": bson.M{
+			"status":     models.StatusFail,
+			"error":      "detection was interrupted by a server restart",
+			"finishedAt": time.Now(),
+		},
+	})
+	if err != nil {
+		log.Printf("jobs: failed to close interrupted detection runs: %v", err)
+	}
+
+	result, err := imageCollection.UpdateMany(ctx, bson.M{"status": models.StatusProcessing}, bson.M{
+		"$set": bson.M{"status": models.StatusPending},
+	})
+	if err != nil {
+		log.Printf("jobs: failed to reset interrupted detections: %v", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("jobs: reset %d interrupted detections to pending", result.ModifiedCount)
+	}
+}
+
+func enqueuePendingImages() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"createdAt": 1})
+	cursor, err := imageCollection.Find(ctx, bson.M{"status": models.StatusPending}, opts)
+	if err != nil {
+		log.Printf("jobs: failed to load pending images: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var image models.Image
+		if err := cursor.Decode(&image); err != nil {
+			log.Printf("jobs: failed to decode pending image: %v", err)
+			continue
+		}
+		if err := EnqueueDetection(image.ID); err != nil {
+			return
+		}
+	}
+}
+
+func detectionWorker() {
+	for imageID := range detectionQueue {
+		processImage(imageID)
+	}
+}
+
+// processImage claims the image by moving it from pending to processing, so
+// an image that was enqueued twice is only ever detected once. Every attempt
+// is recorded as a DetectionRun, which becomes the image's current run once
+// it finishes.
+func processImage(imageID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var image models.Image
+	err := imageCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": imageID, "status": models.StatusPending},
+		bson.M{"$set": bson.M{"status": models.StatusProcessing}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&image)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("jobs: failed to claim image %s: %v", imageID.Hex(), err)
+		}
+		return
+	}
+	events.PublishImageStatus(image)
+
+	run := models.DetectionRun{
+		ID:           primitive.NewObjectID(),
+		Image:        image.ID,
+		User:         image.User,
+		ModelID:      image.ModelID,
+		Model:        image.Model,
+		ModelVersion: image.ModelVersion,
+		Params:       image.Params,
+		Status:       models.StatusProcessing,
+		Result:       []models.DetectedObject{},
+		StartedAt:    time.Now(),
+	}
+	if _, err := detectionRunCollection.InsertOne(ctx, run); err != nil {
+		log.Printf("jobs: failed to record detection run for image %s: %v", imageID.Hex(), err)
+		failImage(image, "failed to record detection run")
+		return
+	}
+
+	detectCtx, detectCancel := context.WithTimeout(context.Background(), time.Duration(configs.DetectionTimeout())*time.Second)
+	defer detectCancel()
+
+	result, detectedImagePath, err := runDetection(detectCtx, image)
+
+	run.FinishedAt = time.Now()
+	run.DurationMs = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	if err != nil {
+		log.Printf("jobs: detection failed for image %s: %v", imageID.Hex(), err)
+		run.Status = models.StatusFail
+		run.Error = err.Error()
+		run.DetectedImagePath = bson.TypeNull.String()
+	} else {
+		run.Status = models.StatusSuccess
+		run.Result = result.Objects
+		run.RawOutput = string(result.RawOutput)
+		run.DetectedImagePath = detectedImagePath
+
+		thumbnails, err := thumbnail.GenerateFrom(detectCtx, storage.Default, detectedImagePath, bytes.NewReader(result.AnnotatedImage))
+		if err != nil {
+			log.Printf("jobs: failed to generate thumbnails of %s: %v", detectedImagePath, err)
+		}
+		run.DetectedThumbnailPath = thumbnails.Thumbnail
+		run.DetectedPreviewPath = thumbnails.Preview
+	}
+
+	updateCtx, updateCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer updateCancel()
+
+	if _, err := detectionRunCollection.ReplaceOne(updateCtx, bson.M{"_id": run.ID}, run); err != nil {
+		log.Printf("jobs: failed to store detection run for image %s: %v", imageID.Hex(), err)
+		removeDetectedImage(detectedImagePath)
+		failImage(image, "failed to store detection run")
+		return
+	}
+
+	set := bson.M{
+		"result":                run.Result,
+		"status":                run.Status,
+		"detectedImagePath":     run.DetectedImagePath,
+		"detectedThumbnailPath": run.DetectedThumbnailPath,
+		"detectedPreviewPath":   run.DetectedPreviewPath,
+		"detectedAt":            run.FinishedAt,
+		"currentRun":            run.ID,
+	}
+	update := bson.M{"$set": set}
+	if run.Error != "" {
+		set["error"] = run.Error
+	} else {
+		update["$unset"] = bson.M{"error": ""}
+	}
+	if _, err := imageCollection.UpdateOne(updateCtx, bson.M{"_id": imageID}, update); err != nil {
+		log.Printf("jobs: failed to store detection result for image %s: %v", imageID.Hex(), err)
+		return
+	}
+
+	image.Status = run.Status
+	image.Result = run.Result
+	image.Error = run.Error
+	image.DetectedImagePath = run.DetectedImagePath
+	image.DetectedThumbnailPath = run.DetectedThumbnailPath
+	image.DetectedPreviewPath = run.DetectedPreviewPath
+	image.DetectedAt = run.FinishedAt
+	image.CurrentRun = run.ID
+	events.PublishImageStatus(image)
+	if !image.Batch.IsZero() {
+		publishBatchProgress(image.User, image.Batch)
+	}
+}
+
+// failImage marks a claimed image as failed when its run could not be
+// stored, so it is not left processing until the next restart.
+func failImage(image models.Image, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := imageCollection.UpdateOne(ctx,
+		bson.M{"_id": image.ID, "status": models.StatusProcessing},
+		bson.M{"$set": bson.M{"status": models.StatusFail, "error": message}},
+	)
+	if err != nil {
+		log.Printf("jobs: failed to mark image %s as failed: %v", image.ID.Hex(), err)
+		return
+	}
+
+	image.Status = models.StatusFail
+	image.Error = message
+	events.PublishImageStatus(image)
+	if !image.Batch.IsZero() {
+		publishBatchProgress(image.User, image.Batch)
+	}
+}
+
+// removeDetectedImage deletes an annotated image whose run could not be
+// stored.
+func removeDetectedImage(path string) {
+	if path == "" || path == bson.TypeNull.String() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := storage.Default.Delete(ctx, path); err != nil {
+		log.Printf("jobs: failed to remove detected image %s: %v", path, err)
+	}
+	if err := thumbnail.Remove(ctx, storage.Default, path); err != nil {
+		log.Printf("jobs: failed to remove thumbnails of %s: %v", path, err)
+	}
+}
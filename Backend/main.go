@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
 	"time"
 
 	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/detector"
+	"github.com/TenJit/SE/Backend/jobs"
+	"github.com/TenJit/SE/Backend/reconcile"
 	"github.com/TenJit/SE/Backend/routes"
+	"github.com/TenJit/SE/Backend/signedurl"
+	"github.com/TenJit/SE/Backend/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -13,19 +23,109 @@ func init() {
 	configs.Connect_to_mongodb()
 }
 
+func newStore() (storage.BlobStore, error) {
+	return storage.New(configs.StorageBackend(), storage.Options{
+		Root:        configs.StorageLocalRoot(),
+		S3Endpoint:  configs.S3Endpoint(),
+		S3AccessKey: configs.S3AccessKey(),
+		S3SecretKey: configs.S3SecretKey(),
+		S3Bucket:    configs.S3Bucket(),
+		S3Region:    configs.S3Region(),
+		S3UseSSL:    configs.S3UseSSL(),
+	})
+}
+
+func reconcileOptions(apply bool) reconcile.Options {
+	return reconcile.Options{
+		Apply:  apply,
+		Grace:  time.Duration(configs.ReconcileGrace()) * time.Minute,
+		Settle: 30 * time.Second,
+	}
+}
+
+// runReconcile is the reconcile subcommand: it checks storage against the
+// database once, prints the report as JSON and, with -apply, repairs what
+// it found.
+func runReconcile(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	apply := flags.Bool("apply", false, "repair what is found instead of only reporting it")
+	grace := flags.Duration("grace", time.Duration(configs.ReconcileGrace())*time.Minute, "ignore unreferenced files younger than this")
+	flags.Parse(args)
+
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage.Default = store
+
+	options := reconcileOptions(*apply)
+	options.Grace = *grace
+	report, err := reconcile.Run(context.Background(), options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	log.Print(report.Summary())
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcile(os.Args[2:])
+		return
+	}
+
 	app := gin.Default()
-	app.Static("/public", "./public")
 	corsConfig := cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
 	app.Use(cors.New(corsConfig))
 	routes.UserRoute(app)
 	routes.ImageRoute(app)
+	routes.ModelRoute(app)
+	routes.FileRoute(app)
+
+	imageDetector, err := detector.New(configs.DetectorBackend(), detector.Options{
+		PythonPath:       configs.YoloV5PythonPath(),
+		ScriptPath:       configs.YoloV5ScriptPath(),
+		ServerScriptPath: configs.YoloV5ServerScriptPath(),
+		WeightsPath:      configs.YoloV5WeightsPath(),
+		OutputDir:        configs.YoloV5OutputDir(),
+		MaxPending:       configs.SidecarMaxPending(),
+		MaxModels:        configs.SidecarMaxModels(),
+		ONNXModelPath:    configs.ONNXModelPath(),
+		ONNXRuntimePath:  configs.ONNXRuntimePath(),
+		ClassNames:       configs.ONNXClassNames(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage.Default, err = newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signingKey := configs.URLSigningKey()
+	if signingKey == nil {
+		log.Fatal("URL_SIGNING_KEY or JWT_SECRET must be set to sign file links")
+	}
+	signedurl.Default = signedurl.New(signingKey, time.Duration(configs.SignedURLExpiry())*time.Second)
+
+	jobs.StartDetectionWorkers(imageDetector, configs.DetectionWorkers(), configs.DetectionQueueSize())
+	go func() {
+		if err := reconcile.BackfillUsage(context.Background(), reconcileOptions(true)); err != nil {
+			log.Printf("usage backfill failed: %v", err)
+		}
+	}()
+	if interval := configs.ReconcileInterval(); interval > 0 {
+		reconcile.Start(time.Duration(interval)*time.Minute, reconcileOptions(configs.ReconcileApply()))
+	}
+
 	app.Run(":8080")
 }
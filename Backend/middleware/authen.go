@@ -7,6 +7,7 @@ import (
 
 	"github.com/TenJit/SE/Backend/configs"
 	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/signedurl"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -39,6 +40,33 @@ func Protect(c *gin.Context) {
 		return
 	}
 
+	setUser(c, userID)
+}
+
+// ProtectToken lets a request in with a short-lived token made by
+// signedurl for purpose in the token query parameter, for clients such as
+// EventSource that cannot set headers, and otherwise works as Protect.
+func ProtectToken(purpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			Protect(c)
+			return
+		}
+
+		userID, err := signedurl.Default.For(purpose, 0).VerifyToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to access this route"})
+			c.Abort()
+			return
+		}
+		setUser(c, userID)
+	}
+}
+
+// setUser loads the user a verified token was issued to into the context
+// and continues, or rejects the request when there is no such user.
+func setUser(c *gin.Context, userID string) {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Not authorized to access this route"})
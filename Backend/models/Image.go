@@ -6,15 +6,65 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusSuccess    = "success"
+	StatusFail       = "fail"
+)
+
+// Where a box came from: the detection model, or a person who drew or
+// corrected it. Boxes detected before origins were recorded have none and
+// came from the model.
+const (
+	OriginModel = "model"
+	OriginHuman = "human"
+)
+
 type Image struct {
-	ID                primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	User              primitive.ObjectID `json:"user,omitempty" bsom:"user,omitempty"`
-	ImageName         string             `json:"imageName,omitempty" bson:"imageName,omitempty"`
-	ImagePath         string             `json:"imagePath,omitempty" bson:"imagePath,omitempty" validate:"required"`
-	DetectedImagePath string             `json:"detectedImagePath,omitempty" bson:"detectedImagePath,omitempty"`
-	Status            string             `json:"status,omitempty" bson:"status,omitempty"`
-	Result            []DetectedObject   `json:"result" bson:"result"`
-	CreatedAt         time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	ID                    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User                  primitive.ObjectID `json:"user,omitempty" bsom:"user,omitempty"`
+	Batch                 primitive.ObjectID `json:"batch,omitempty" bson:"batch,omitempty"`
+	ImageName             string             `json:"imageName,omitempty" bson:"imageName,omitempty"`
+	ImagePath             string             `json:"imagePath,omitempty" bson:"imagePath,omitempty" validate:"required"`
+	Digest                string             `json:"digest,omitempty" bson:"digest,omitempty"`
+	Size                  int64              `json:"size,omitempty" bson:"size,omitempty"`
+	Metadata              *ImageMetadata     `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	DetectedImagePath     string             `json:"detectedImagePath,omitempty" bson:"detectedImagePath,omitempty"`
+	ThumbnailPath         string             `json:"thumbnailPath,omitempty" bson:"thumbnailPath,omitempty"`
+	PreviewPath           string             `json:"previewPath,omitempty" bson:"previewPath,omitempty"`
+	DetectedThumbnailPath string             `json:"detectedThumbnailPath,omitempty" bson:"detectedThumbnailPath,omitempty"`
+	DetectedPreviewPath   string             `json:"detectedPreviewPath,omitempty" bson:"detectedPreviewPath,omitempty"`
+	Status                string             `json:"status,omitempty" bson:"status,omitempty"`
+	ModelID               primitive.ObjectID `json:"modelId,omitempty" bson:"modelId,omitempty"`
+	Model                 string             `json:"model,omitempty" bson:"model,omitempty"`
+	ModelVersion          string             `json:"modelVersion,omitempty" bson:"modelVersion,omitempty"`
+	Params                InferenceParams    `json:"params" bson:"params"`
+	Result                []DetectedObject   `json:"result" bson:"result"`
+	Error                 string             `json:"error,omitempty" bson:"error,omitempty"`
+	DetectedAt            time.Time          `json:"detectedAt,omitempty" bson:"detectedAt,omitempty"`
+	EditedAt              time.Time          `json:"editedAt,omitempty" bson:"editedAt,omitempty"`
+	CurrentRun            primitive.ObjectID `json:"currentRun,omitempty" bson:"currentRun,omitempty"`
+	CreatedAt             time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+
+	// Signed links to the image's files, filled in for responses and never
+	// stored.
+	ImageURL             string `json:"imageUrl,omitempty" bson:"-"`
+	DetectedImageURL     string `json:"detectedImageUrl,omitempty" bson:"-"`
+	ThumbnailURL         string `json:"thumbnailUrl,omitempty" bson:"-"`
+	PreviewURL           string `json:"previewUrl,omitempty" bson:"-"`
+	DetectedThumbnailURL string `json:"detectedThumbnailUrl,omitempty" bson:"-"`
+	DetectedPreviewURL   string `json:"detectedPreviewUrl,omitempty" bson:"-"`
+}
+
+// InferenceParams are the settings a detection ran with. Classes limits the
+// result to those class names; empty means every class.
+type InferenceParams struct {
+	ConfThreshold float32  `json:"confThreshold" bson:"confThreshold"`
+	IoUThreshold  float32  `json:"iouThreshold" bson:"iouThreshold"`
+	ImageSize     int      `json:"imageSize" bson:"imageSize"`
+	MaxDetections int      `json:"maxDetections" bson:"maxDetections"`
+	Classes       []string `json:"classes,omitempty" bson:"classes,omitempty"`
 }
 
 type DetectedObject struct {
@@ -29,4 +79,5 @@ type Coordinate struct {
 	X_max       int                `json:"x_max,omitempty" bson:"x_max,omitempty"`
 	Y_min       int                `json:"y_min,omitempty" bson:"y_min,omitempty"`
 	Y_max       int                `json:"y_max,omitempty" bson:"y_max,omitempty"`
+	Origin      string             `json:"origin,omitempty" bson:"origin,omitempty"`
 }
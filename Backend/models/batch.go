@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Batch groups the images of one multi-file upload so their detection can
+// be followed together. Files lists every file the upload contained,
+// including the ones that were rejected.
+type Batch struct {
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User         primitive.ObjectID `json:"user,omitempty" bson:"user,omitempty"`
+	Name         string             `json:"name,omitempty" bson:"name,omitempty"`
+	ModelID      primitive.ObjectID `json:"modelId,omitempty" bson:"modelId,omitempty"`
+	Model        string             `json:"model,omitempty" bson:"model,omitempty"`
+	ModelVersion string             `json:"modelVersion,omitempty" bson:"modelVersion,omitempty"`
+	Params       InferenceParams    `json:"params" bson:"params"`
+	Files        []BatchFile        `json:"files" bson:"files"`
+	Accepted     int                `json:"accepted" bson:"accepted"`
+	Rejected     int                `json:"rejected" bson:"rejected"`
+	CreatedAt    time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+}
+
+// BatchFile is the outcome of one file in a batch upload. Image is set when
+// the file was accepted, Error, Code and Details describe why it was not,
+// in the same form as a rejected single upload. DuplicateOf names an earlier
+// image of the user with the same bytes.
+type BatchFile struct {
+	Filename    string             `json:"filename" bson:"filename"`
+	Accepted    bool               `json:"accepted" bson:"accepted"`
+	Image       primitive.ObjectID `json:"image,omitempty" bson:"image,omitempty"`
+	DuplicateOf primitive.ObjectID `json:"duplicateOf,omitempty" bson:"duplicateOf,omitempty"`
+	Reused      bool               `json:"reused,omitempty" bson:"reused,omitempty"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Code        string             `json:"code,omitempty" bson:"code,omitempty"`
+	Details     map[string]any     `json:"details,omitempty" bson:"details,omitempty"`
+}
+
+// BatchProgress counts a batch's images by detection status.
+type BatchProgress struct {
+	Batch      primitive.ObjectID `json:"batch"`
+	Total      int                `json:"total"`
+	Pending    int                `json:"pending"`
+	Processing int                `json:"processing"`
+	Success    int                `json:"success"`
+	Fail       int                `json:"fail"`
+	Done       bool               `json:"done"`
+	Percent    float64            `json:"percent"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Blob is an uploaded file stored once under its SHA-256 digest. RefCount is
+// the number of images using it; the file is deleted when it drops to zero.
+// A blob being deleted is marked Deleting until its file is gone, and is
+// not taken by new uploads meanwhile.
+type Blob struct {
+	Digest      string    `json:"digest" bson:"_id"`
+	Key         string    `json:"key" bson:"key"`
+	Size        int64     `json:"size" bson:"size"`
+	ContentType string    `json:"contentType,omitempty" bson:"contentType,omitempty"`
+	RefCount    int       `json:"refCount" bson:"refCount"`
+	CreatedAt   time.Time `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	Deleting    bool      `json:"deleting,omitempty" bson:"deleting,omitempty"`
+	DeletingAt  time.Time `json:"deletingAt,omitempty" bson:"deletingAt,omitempty"`
+}
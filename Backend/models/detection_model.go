@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DetectionModel is a registered set of weights that uploads can be detected
+// with. Name and Version together identify a model.
+type DetectionModel struct {
+	ID            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name          string             `json:"name,omitempty" bson:"name,omitempty" validate:"required"`
+	Version       string             `json:"version,omitempty" bson:"version,omitempty" validate:"required"`
+	WeightsPath   string             `json:"weightsPath,omitempty" bson:"weightsPath,omitempty" validate:"required"`
+	Classes       []string           `json:"classes" bson:"classes"`
+	ConfThreshold float32            `json:"confThreshold,omitempty" bson:"confThreshold,omitempty" validate:"gte=0,lte=1"`
+	IoUThreshold  float32            `json:"iouThreshold,omitempty" bson:"iouThreshold,omitempty" validate:"gte=0,lte=1"`
+	IsDefault     bool               `json:"isDefault" bson:"isDefault"`
+	CreatedAt     time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+}
+
+type DetectionModelUpdate struct {
+	Classes       []string `json:"classes,omitempty" bson:"classes,omitempty"`
+	ConfThreshold float32  `json:"confThreshold,omitempty" bson:"confThreshold,omitempty" validate:"gte=0,lte=1"`
+	IoUThreshold  float32  `json:"iouThreshold,omitempty" bson:"iouThreshold,omitempty" validate:"gte=0,lte=1"`
+	IsDefault     *bool    `json:"isDefault,omitempty" bson:"isDefault,omitempty"`
+}
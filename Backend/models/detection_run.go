@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DetectionRun is one detection of an image. Every run is kept so results
+// from different models and parameters can be compared; the image points at
+// the run whose result it currently shows. ReusedFrom is set on runs copied
+// from an earlier upload of the same bytes instead of running the model.
+// Runs with origin human hold boxes a person edited; EditedFrom is the run
+// whose result they started from, and the model and parameters are that
+// run's. Runs recorded before origins were have none and came from the
+// model.
+type DetectionRun struct {
+	ID                    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Image                 primitive.ObjectID `json:"image,omitempty" bson:"image,omitempty"`
+	User                  primitive.ObjectID `json:"user,omitempty" bson:"user,omitempty"`
+	ModelID               primitive.ObjectID `json:"modelId,omitempty" bson:"modelId,omitempty"`
+	Model                 string             `json:"model,omitempty" bson:"model,omitempty"`
+	ModelVersion          string             `json:"modelVersion,omitempty" bson:"modelVersion,omitempty"`
+	Params                InferenceParams    `json:"params" bson:"params"`
+	Status                string             `json:"status,omitempty" bson:"status,omitempty"`
+	Result                []DetectedObject   `json:"result" bson:"result"`
+	DetectedImagePath     string             `json:"detectedImagePath,omitempty" bson:"detectedImagePath,omitempty"`
+	DetectedThumbnailPath string             `json:"detectedThumbnailPath,omitempty" bson:"detectedThumbnailPath,omitempty"`
+	DetectedPreviewPath   string             `json:"detectedPreviewPath,omitempty" bson:"detectedPreviewPath,omitempty"`
+	RawOutput             string             `json:"rawOutput,omitempty" bson:"rawOutput,omitempty"`
+	Error                 string             `json:"error,omitempty" bson:"error,omitempty"`
+	ReusedFrom            primitive.ObjectID `json:"reusedFrom,omitempty" bson:"reusedFrom,omitempty"`
+	Origin                string             `json:"origin,omitempty" bson:"origin,omitempty"`
+	EditedFrom            primitive.ObjectID `json:"editedFrom,omitempty" bson:"editedFrom,omitempty"`
+	StartedAt             time.Time          `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt            time.Time          `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	DurationMs            int64              `json:"durationMs,omitempty" bson:"durationMs,omitempty"`
+
+	// Signed links to the run's files, filled in for responses and never
+	// stored.
+	DetectedImageURL     string `json:"detectedImageUrl,omitempty" bson:"-"`
+	DetectedThumbnailURL string `json:"detectedThumbnailUrl,omitempty" bson:"-"`
+	DetectedPreviewURL   string `json:"detectedPreviewUrl,omitempty" bson:"-"`
+}
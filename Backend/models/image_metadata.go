@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// ImageMetadata is what an upload's EXIF block said about the photo. The
+// stored file keeps its location only when LocationShared is set.
+type ImageMetadata struct {
+	CapturedAt     *time.Time   `json:"capturedAt,omitempty" bson:"capturedAt,omitempty"`
+	CameraMake     string       `json:"cameraMake,omitempty" bson:"cameraMake,omitempty"`
+	CameraModel    string       `json:"cameraModel,omitempty" bson:"cameraModel,omitempty"`
+	LensModel      string       `json:"lensModel,omitempty" bson:"lensModel,omitempty"`
+	Orientation    int          `json:"orientation,omitempty" bson:"orientation,omitempty"`
+	Location       *GeoLocation `json:"location,omitempty" bson:"location,omitempty"`
+	LocationShared bool         `json:"locationShared" bson:"locationShared"`
+}
+
+// GeoLocation is a GPS position in decimal degrees, with the altitude in
+// metres above sea level when the camera recorded one.
+type GeoLocation struct {
+	Latitude  float64  `json:"latitude" bson:"latitude"`
+	Longitude float64  `json:"longitude" bson:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty" bson:"altitude,omitempty"`
+}
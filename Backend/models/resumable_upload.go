@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResumableUpload is an image being sent in parts over the tus protocol.
+// Offset counts the bytes received so far; once it reaches Length the file
+// becomes Image, or Error and Code say why it was rejected. Metadata holds
+// the Upload-Metadata pairs the client created it with.
+type ResumableUpload struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User      primitive.ObjectID `json:"user,omitempty" bson:"user,omitempty"`
+	Length    int64              `json:"length" bson:"length"`
+	Offset    int64              `json:"offset" bson:"offset"`
+	Metadata  map[string]string  `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Image     primitive.ObjectID `json:"image,omitempty" bson:"image,omitempty"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	Code      string             `json:"code,omitempty" bson:"code,omitempty"`
+	CreatedAt time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	ExpiresAt time.Time          `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+}
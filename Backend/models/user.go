@@ -15,9 +15,24 @@ type User struct {
 	Password            string             `json:"password,omitempty" bson:"password,omitempty" validate:"required"`
 	ResetPasswordToken  string             `json:"resetPasswordToken,omitempty" bson:"resetPasswordToken,omitempty"`
 	ResetPasswordExpire time.Time          `json:"resetPasswordExpire,omitempty" bson:"resetPasswordExpire,omitempty"`
+	Usage               StorageUsage       `json:"usage" bson:"usage"`
+	QuotaOverride       *Quota             `json:"quotaOverride,omitempty" bson:"quotaOverride,omitempty"`
 	CreatedAt           time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
 }
 
+// StorageUsage is what a user's images take up: the bytes of the uploaded
+// files and the number of images.
+type StorageUsage struct {
+	Bytes  int64 `json:"bytes" bson:"bytes"`
+	Images int64 `json:"images" bson:"images"`
+}
+
+// Quota caps a user's StorageUsage. Zero means no limit.
+type Quota struct {
+	MaxBytes  int64 `json:"maxBytes" bson:"maxBytes" validate:"gte=0"`
+	MaxImages int64 `json:"maxImages" bson:"maxImages" validate:"gte=0"`
+}
+
 type UserLogIn struct {
 	Email    string `json:"email,omitempty" bson:"email,omitempty" validate:"required"`
 	Password string `json:"password,omitempty" bson:"password,omitempty" validate:"required"`
@@ -30,6 +45,10 @@ type UserResponse struct {
 	Email     string             `json:"email,omitempty" bson:"email,omitempty" validate:"required"`
 	Role      string             `json:"role,omitempty" bson:"role,omitempty" validate:"required"`
 	CreatedAt time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+
+	Usage         StorageUsage `json:"usage" bson:"usage"`
+	Quota         *Quota       `json:"quota,omitempty" bson:"-"`
+	QuotaOverride *Quota       `json:"quotaOverride,omitempty" bson:"quotaOverride,omitempty"`
 }
 
 type UserUpdate struct {
@@ -0,0 +1,192 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checkRefCounts compares every blob's reference count with the images
+// using it. Before repairing, it waits and counts again, and only fixes
+// what came out the same both times, so references taken by uploads still
+// in flight are left alone.
+func checkRefCounts(ctx context.Context, options Options, report *Report) error {
+	drifts, err := findRefCountDrift(ctx)
+	if err != nil {
+		return err
+	}
+	if !options.Apply || len(drifts) == 0 {
+		report.RefCounts = append(report.RefCounts, drifts...)
+		return nil
+	}
+
+	select {
+	case <-time.After(options.Settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	again, err := findRefCountDrift(ctx)
+	if err != nil {
+		return err
+	}
+	persisted := make(map[string]RefCountDrift, len(again))
+	for _, drift := range again {
+		persisted[drift.Digest] = drift
+	}
+
+	for _, drift := range drifts {
+		if persisted[drift.Digest] != drift {
+			continue
+		}
+		report.RefCounts = append(report.RefCounts, drift)
+		if err := fixRefCount(ctx, drift); err != nil {
+			report.fail("blob %s: %v", drift.Digest, err)
+			continue
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+// findRefCountDrift lists the blobs whose reference count is not the number
+// of images with their digest, including digests with no blob at all.
+func findRefCountDrift(ctx context.Context) ([]RefCountDrift, error) {
+	cursor, err := imageCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"digest": bson.M{"$exists": true, "$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$digest",
+			"count": bson.M{"$sum": 1},
+			"key":   bson.M{"$first": "$imagePath"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var used []struct {
+		Digest string `bson:"_id"`
+		Count  int    `bson:"count"`
+		Key    string `bson:"key"`
+	}
+	if err := cursor.All(ctx, &used); err != nil {
+		return nil, err
+	}
+	actual := make(map[string]int, len(used))
+	for _, digest := range used {
+		actual[digest.Digest] = digest.Count
+	}
+
+	cursor, err = blobCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var blobs []models.Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, err
+	}
+
+	drifts := []RefCountDrift{}
+	recorded := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		recorded[blob.Digest] = true
+		if blob.Deleting {
+			// Left to checkDeletions.
+			continue
+		}
+		if blob.RefCount != actual[blob.Digest] {
+			drifts = append(drifts, RefCountDrift{Digest: blob.Digest, Key: blob.Key, Recorded: blob.RefCount, Actual: actual[blob.Digest]})
+		}
+	}
+	for _, digest := range used {
+		if !recorded[digest.Digest] {
+			drifts = append(drifts, RefCountDrift{Digest: digest.Digest, Key: digest.Key, Recorded: -1, Actual: digest.Count})
+		}
+	}
+	return drifts, nil
+}
+
+// fixRefCount sets a blob's count to the number of images using it,
+// creating or deleting the blob as needed. Every change is conditional on
+// the count it saw, so an upload or delete racing it wins.
+func fixRefCount(ctx context.Context, drift RefCountDrift) error {
+	if drift.Recorded < 0 {
+		info, err := storage.Default.Stat(ctx, drift.Key)
+		if err != nil {
+			return err
+		}
+		_, err = blobCollection.InsertOne(ctx, models.Blob{
+			Digest:      drift.Digest,
+			Key:         drift.Key,
+			Size:        info.Size,
+			ContentType: storage.ContentType(drift.Key),
+			RefCount:    drift.Actual,
+			CreatedAt:   time.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+
+	filter := bson.M{"_id": drift.Digest, "refCount": drift.Recorded}
+	if drift.Actual > 0 {
+		_, err := blobCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"refCount": drift.Actual}})
+		return err
+	}
+
+	// Marked the way the server deletes blobs, so an upload of the same
+	// bytes waits for the file to be gone instead of reusing it.
+	filter["deleting"] = bson.M{"$ne": true}
+	result, err := blobCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deleting": true, "deletingAt": time.Now()}})
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+	return finishDeletion(ctx, drift.Digest, drift.Key)
+}
+
+// checkDeletions finishes deleting blobs whose deletion was cut short,
+// which uploads of the same bytes would otherwise wait on forever. Blobs
+// marked less than Grace ago are left to the deletion in progress.
+func checkDeletions(ctx context.Context, options Options, report *Report) error {
+	cursor, err := blobCollection.Find(ctx, bson.M{
+		"deleting":   true,
+		"deletingAt": bson.M{"$lt": time.Now().Add(-options.Grace)},
+	})
+	if err != nil {
+		return err
+	}
+	var blobs []models.Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		report.StaleDeletions = append(report.StaleDeletions, blob.Digest)
+		if !options.Apply {
+			continue
+		}
+		if err := finishDeletion(ctx, blob.Digest, blob.Key); err != nil {
+			report.fail("blob %s: %v", blob.Digest, err)
+			continue
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+// finishDeletion removes the files of a blob marked as being deleted, then
+// the blob.
+func finishDeletion(ctx context.Context, digest string, key string) error {
+	if err := thumbnail.Remove(ctx, storage.Default, key); err != nil {
+		return err
+	}
+	if err := storage.Default.Delete(ctx, key); err != nil {
+		return err
+	}
+	_, err := blobCollection.DeleteOne(ctx, bson.M{"_id": digest, "deleting": true})
+	return err
+}
@@ -0,0 +1,239 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// storedPrefix is where every uploaded and generated file is kept.
+const storedPrefix = "public/"
+
+// Repairs applied to a missing file, as reported.
+const (
+	repairMarkFailed = "mark image failed"
+	repairClear      = "clear field"
+	repairRegenerate = "regenerate"
+	repairNone       = "none"
+)
+
+// fileRef is a document field naming a stored file. Source is the file a
+// thumbnail or preview is made from, empty for other fields.
+type fileRef struct {
+	collection *mongo.Collection
+	id         any
+	field      string
+	key        string
+	source     string
+}
+
+func (r fileRef) missing(repair string) MissingFile {
+	id := fmt.Sprint(r.id)
+	if objectID, ok := r.id.(primitive.ObjectID); ok {
+		id = objectID.Hex()
+	}
+	return MissingFile{Collection: r.collection.Name(), ID: id, Field: r.field, Key: r.key, Repair: repair}
+}
+
+// hasFile tells stored keys from the "null" and empty values documents use
+// for no file.
+func hasFile(key string) bool {
+	return key != "" && key != bson.TypeNull.String()
+}
+
+// collectRefs returns every file documents name, plus the thumbnails of
+// blobs, which belong to the blob even when no image names them.
+func collectRefs(ctx context.Context) (refs []fileRef, kept map[string]bool, err error) {
+	kept = map[string]bool{}
+	add := func(ref fileRef) {
+		if hasFile(ref.key) {
+			refs = append(refs, ref)
+			kept[ref.key] = true
+		}
+	}
+
+	cursor, err := imageCollection.Find(ctx, bson.M{}, findFields("imagePath", "detectedImagePath", "thumbnailPath",
+		"previewPath", "detectedThumbnailPath", "detectedPreviewPath"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var images []models.Image
+	if err := cursor.All(ctx, &images); err != nil {
+		return nil, nil, err
+	}
+	for _, image := range images {
+		add(fileRef{imageCollection, image.ID, "imagePath", image.ImagePath, ""})
+		add(fileRef{imageCollection, image.ID, "detectedImagePath", image.DetectedImagePath, ""})
+		add(fileRef{imageCollection, image.ID, "thumbnailPath", image.ThumbnailPath, image.ImagePath})
+		add(fileRef{imageCollection, image.ID, "previewPath", image.PreviewPath, image.ImagePath})
+		add(fileRef{imageCollection, image.ID, "detectedThumbnailPath", image.DetectedThumbnailPath, image.DetectedImagePath})
+		add(fileRef{imageCollection, image.ID, "detectedPreviewPath", image.DetectedPreviewPath, image.DetectedImagePath})
+	}
+
+	cursor, err = detectionRunCollection.Find(ctx, bson.M{}, findFields("detectedImagePath", "detectedThumbnailPath", "detectedPreviewPath"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var runs []models.DetectionRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, nil, err
+	}
+	for _, run := range runs {
+		add(fileRef{detectionRunCollection, run.ID, "detectedImagePath", run.DetectedImagePath, ""})
+		add(fileRef{detectionRunCollection, run.ID, "detectedThumbnailPath", run.DetectedThumbnailPath, run.DetectedImagePath})
+		add(fileRef{detectionRunCollection, run.ID, "detectedPreviewPath", run.DetectedPreviewPath, run.DetectedImagePath})
+	}
+
+	cursor, err = blobCollection.Find(ctx, bson.M{}, findFields("key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var blobs []models.Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, nil, err
+	}
+	for _, blob := range blobs {
+		add(fileRef{blobCollection, blob.Digest, "key", blob.Key, ""})
+		paths := thumbnail.KeysFor(blob.Key)
+		kept[paths.Thumbnail], kept[paths.Preview] = true, true
+	}
+	return refs, kept, nil
+}
+
+func findFields(fields ...string) *options.FindOptions {
+	projection := bson.M{}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	return options.Find().SetProjection(projection)
+}
+
+// checkFiles compares the stored files with the ones documents name.
+// Unnamed files older than the grace period are orphans and get deleted;
+// named files that are gone get their documents repaired.
+func checkFiles(ctx context.Context, options Options, report *Report) error {
+	refs, kept, err := collectRefs(ctx)
+	if err != nil {
+		return err
+	}
+	objects, err := storage.Default.List(ctx, storedPrefix)
+	if err != nil {
+		return err
+	}
+	report.FilesChecked = len(objects)
+
+	stored := make(map[string]bool, len(objects))
+	cutoff := time.Now().Add(-options.Grace)
+	for _, object := range objects {
+		stored[object.Key] = true
+		if kept[object.Key] || strings.HasPrefix(path.Base(object.Key), ".") || object.ModTime.After(cutoff) {
+			continue
+		}
+		report.OrphanFiles = append(report.OrphanFiles, object.Key)
+		if options.Apply {
+			if err := storage.Default.Delete(ctx, object.Key); err != nil {
+				report.fail("delete %s: %v", object.Key, err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+
+	regenerated := map[string]bool{}
+	for _, ref := range refs {
+		if stored[ref.key] {
+			continue
+		}
+		repair := plannedRepair(ref, stored)
+		report.MissingFiles = append(report.MissingFiles, ref.missing(repair))
+		if !options.Apply || repair == repairNone {
+			continue
+		}
+		if err := repairMissing(ctx, ref, repair, regenerated); err != nil {
+			report.fail("%s %v %s: %v", ref.collection.Name(), ref.id, ref.field, err)
+			continue
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+// plannedRepair decides what to do about a document naming a missing file.
+// Originals cannot be recovered, so their image is marked failed;
+// thumbnails and previews are made again when their source is still there.
+func plannedRepair(ref fileRef, stored map[string]bool) string {
+	switch {
+	case ref.collection == blobCollection:
+		// The next upload of the same bytes stores the file again.
+		return repairNone
+	case ref.field == "imagePath":
+		return repairMarkFailed
+	case ref.source != "" && stored[ref.source]:
+		return repairRegenerate
+	default:
+		return repairClear
+	}
+}
+
+func repairMissing(ctx context.Context, ref fileRef, repair string, regenerated map[string]bool) error {
+	filter := bson.M{"_id": ref.id, ref.field: ref.key}
+	switch repair {
+	case repairMarkFailed:
+		_, err := ref.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"status": models.StatusFail,
+			"error":  "Image file is missing",
+		}})
+		return err
+
+	case repairRegenerate:
+		if !regenerated[ref.source] {
+			if err := regenerate(ctx, ref.source); err != nil {
+				return err
+			}
+			regenerated[ref.source] = true
+		}
+		paths := thumbnail.KeysFor(ref.source)
+		key := paths.Preview
+		if ref.field == "thumbnailPath" || ref.field == "detectedThumbnailPath" {
+			key = paths.Thumbnail
+		}
+		_, err := ref.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{ref.field: key}})
+		return err
+
+	case repairClear:
+		// An annotated image takes its thumbnails with it.
+		update := bson.M{"$unset": bson.M{ref.field: ""}}
+		if ref.field == "detectedImagePath" {
+			unset := bson.M{"detectedThumbnailPath": "", "detectedPreviewPath": ""}
+			update = bson.M{"$unset": unset}
+			if ref.collection == imageCollection {
+				update["$set"] = bson.M{ref.field: bson.TypeNull.String()}
+			} else {
+				unset[ref.field] = ""
+			}
+		}
+		_, err := ref.collection.UpdateOne(ctx, filter, update)
+		return err
+	}
+	return nil
+}
+
+func regenerate(ctx context.Context, source string) error {
+	object, _, err := storage.Default.Get(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	_, err = thumbnail.GenerateFrom(ctx, storage.Default, source, object)
+	return err
+}
@@ -0,0 +1,315 @@
+//go:build integration
+
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"github.com/TenJit/SE/Backend/thumbnail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testGrace = time.Hour
+
+// fileFixture is a local store whose files can be backdated past the grace
+// period.
+type fileFixture struct {
+	t    *testing.T
+	root string
+}
+
+func newFileFixture(t *testing.T) *fileFixture {
+	t.Helper()
+	root := t.TempDir()
+	storage.Default = storage.NewLocal(root)
+	return &fileFixture{t: t, root: root}
+}
+
+// put stores a small PNG under key, last modified age ago.
+func (f *fileFixture) put(key string, age time.Duration) {
+	f.t.Helper()
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := storage.Default.Put(context.Background(), key, &data, int64(data.Len()), "image/png"); err != nil {
+		f.t.Fatalf("put %s: %v", key, err)
+	}
+	modified := time.Now().Add(-age)
+	if err := os.Chtimes(filepath.Join(f.root, filepath.FromSlash(key)), modified, modified); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fileFixture) stored(key string) bool {
+	_, err := storage.Default.Stat(context.Background(), key)
+	return err == nil
+}
+
+func uniqueKey(dir string, suffix string) string {
+	return "public/" + dir + "/" + primitive.NewObjectID().Hex() + suffix
+}
+
+func findImage(t *testing.T, id primitive.ObjectID) models.Image {
+	t.Helper()
+	var image models.Image
+	if err := imageCollection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&image); err != nil {
+		t.Fatalf("find image: %v", err)
+	}
+	return image
+}
+
+func missingRepair(report Report, id primitive.ObjectID, field string) string {
+	for _, missing := range report.MissingFiles {
+		if missing.ID == id.Hex() && missing.Field == field {
+			return missing.Repair
+		}
+	}
+	return ""
+}
+
+func TestCheckFilesOrphans(t *testing.T) {
+	ctx := context.Background()
+	f := newFileFixture(t)
+
+	young := uniqueKey("uploads", ".png")
+	old := uniqueKey("uploads", ".png")
+	f.put(young, time.Minute)
+	f.put(old, 2*testGrace)
+
+	// A blob's thumbnails belong to it even with no image naming them.
+	digest := primitive.NewObjectID().Hex()
+	blobKey := uniqueKey("uploads", ".png")
+	paths := thumbnail.KeysFor(blobKey)
+	for _, key := range []string{blobKey, paths.Thumbnail, paths.Preview} {
+		f.put(key, 2*testGrace)
+	}
+	if _, err := blobCollection.InsertOne(ctx, models.Blob{Digest: digest, Key: blobKey, RefCount: 0}); err != nil {
+		t.Fatalf("insert blob: %v", err)
+	}
+	t.Cleanup(func() { blobCollection.DeleteOne(ctx, bson.M{"_id": digest}) })
+
+	for _, apply := range []bool{false, true} {
+		report := Report{}
+		if err := checkFiles(ctx, Options{Apply: apply, Grace: testGrace}, &report); err != nil {
+			t.Fatalf("checkFiles: %v", err)
+		}
+		if !slices.Contains(report.OrphanFiles, old) {
+			t.Errorf("apply %v: old orphan %s not reported in %v", apply, old, report.OrphanFiles)
+		}
+		for _, key := range []string{young, blobKey, paths.Thumbnail, paths.Preview} {
+			if slices.Contains(report.OrphanFiles, key) {
+				t.Errorf("apply %v: %s reported as an orphan", apply, key)
+			}
+		}
+		if f.stored(old) == apply {
+			t.Errorf("apply %v: old orphan stored %v", apply, f.stored(old))
+		}
+		for _, key := range []string{young, blobKey, paths.Thumbnail, paths.Preview} {
+			if !f.stored(key) {
+				t.Errorf("apply %v: %s deleted", apply, key)
+			}
+		}
+	}
+}
+
+func TestCheckFilesMissing(t *testing.T) {
+	ctx := context.Background()
+	f := newFileFixture(t)
+	user := primitive.NewObjectID()
+	t.Cleanup(func() {
+		imageCollection.DeleteMany(ctx, bson.M{"user": user})
+		detectionRunCollection.DeleteMany(ctx, bson.M{"user": user})
+	})
+
+	// lost has no original any more.
+	lost := models.Image{ID: primitive.NewObjectID(), User: user, Status: models.StatusSuccess,
+		ImagePath: uniqueKey("uploads", ".png"), DetectedImagePath: bson.TypeNull.String()}
+
+	// unthumbed has its original but not its thumbnail.
+	unthumbed := models.Image{ID: primitive.NewObjectID(), User: user, Status: models.StatusSuccess,
+		ImagePath: uniqueKey("uploads", ".png"), DetectedImagePath: bson.TypeNull.String(),
+		ThumbnailPath: uniqueKey("thumbnails", "_thumb.jpg")}
+	f.put(unthumbed.ImagePath, 0)
+
+	// undetected lost its annotated image, though not its thumbnails.
+	undetected := models.Image{ID: primitive.NewObjectID(), User: user, Status: models.StatusSuccess,
+		ImagePath: uniqueKey("uploads", ".png"), DetectedImagePath: uniqueKey("detected", ".png"),
+		DetectedThumbnailPath: uniqueKey("thumbnails", "_thumb.jpg"), DetectedPreviewPath: uniqueKey("thumbnails", "_preview.jpg")}
+	for _, key := range []string{undetected.ImagePath, undetected.DetectedThumbnailPath, undetected.DetectedPreviewPath} {
+		f.put(key, 0)
+	}
+	run := models.DetectionRun{ID: primitive.NewObjectID(), Image: undetected.ID, User: user,
+		DetectedImagePath: undetected.DetectedImagePath, DetectedThumbnailPath: undetected.DetectedThumbnailPath}
+
+	if _, err := imageCollection.InsertMany(ctx, []any{lost, unthumbed, undetected}); err != nil {
+		t.Fatalf("insert images: %v", err)
+	}
+	if _, err := detectionRunCollection.InsertOne(ctx, run); err != nil {
+		t.Fatalf("insert run: %v", err)
+	}
+
+	report := Report{}
+	if err := checkFiles(ctx, Options{Grace: testGrace}, &report); err != nil {
+		t.Fatalf("checkFiles: %v", err)
+	}
+	for _, want := range []struct {
+		id     primitive.ObjectID
+		field  string
+		repair string
+	}{
+		{lost.ID, "imagePath", repairMarkFailed},
+		{unthumbed.ID, "thumbnailPath", repairRegenerate},
+		{undetected.ID, "detectedImagePath", repairClear},
+		{run.ID, "detectedImagePath", repairClear},
+	} {
+		if got := missingRepair(report, want.id, want.field); got != want.repair {
+			t.Errorf("%s %s: repair %q, want %q", want.id.Hex(), want.field, got, want.repair)
+		}
+	}
+	if got := findImage(t, lost.ID); got.Status != models.StatusSuccess {
+		t.Errorf("dry run marked the image %s", got.Status)
+	}
+
+	report = Report{}
+	if err := checkFiles(ctx, Options{Apply: true, Grace: testGrace}, &report); err != nil {
+		t.Fatalf("checkFiles: %v", err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors: %v", report.Errors)
+	}
+
+	if got := findImage(t, lost.ID); got.Status != models.StatusFail || got.Error == "" {
+		t.Errorf("image without its original is %s, error %q", got.Status, got.Error)
+	}
+
+	got := findImage(t, unthumbed.ID)
+	if want := thumbnail.KeysFor(unthumbed.ImagePath).Thumbnail; got.ThumbnailPath != want || !f.stored(want) {
+		t.Errorf("thumbnail %s, stored %v, want %s regenerated", got.ThumbnailPath, f.stored(want), want)
+	}
+
+	got = findImage(t, undetected.ID)
+	if got.DetectedImagePath != bson.TypeNull.String() || got.DetectedThumbnailPath != "" || got.DetectedPreviewPath != "" {
+		t.Errorf("annotated image %q, thumbnail %q, preview %q left", got.DetectedImagePath, got.DetectedThumbnailPath, got.DetectedPreviewPath)
+	}
+	var gotRun models.DetectionRun
+	if err := detectionRunCollection.FindOne(ctx, bson.M{"_id": run.ID}).Decode(&gotRun); err != nil {
+		t.Fatalf("find run: %v", err)
+	}
+	if gotRun.DetectedImagePath != "" || gotRun.DetectedThumbnailPath != "" {
+		t.Errorf("run kept annotated image %q, thumbnail %q", gotRun.DetectedImagePath, gotRun.DetectedThumbnailPath)
+	}
+}
+
+func findBlob(t *testing.T, digest string) *models.Blob {
+	t.Helper()
+	var blob models.Blob
+	if err := blobCollection.FindOne(context.Background(), bson.M{"_id": digest}).Decode(&blob); err != nil {
+		return nil
+	}
+	return &blob
+}
+
+func refCountDrift(report Report, digest string) *RefCountDrift {
+	for _, drift := range report.RefCounts {
+		if drift.Digest == digest {
+			return &drift
+		}
+	}
+	return nil
+}
+
+func TestCheckRefCounts(t *testing.T) {
+	ctx := context.Background()
+	f := newFileFixture(t)
+	user := primitive.NewObjectID()
+
+	// overcounted is used once but counts three; unused counts one but no
+	// image uses it; unrecorded is used with no blob at all.
+	overcounted := models.Blob{Digest: primitive.NewObjectID().Hex(), Key: uniqueKey("uploads", ".png"), RefCount: 3}
+	unused := models.Blob{Digest: primitive.NewObjectID().Hex(), Key: uniqueKey("uploads", ".png"), RefCount: 1}
+	unrecorded := models.Blob{Digest: primitive.NewObjectID().Hex(), Key: uniqueKey("uploads", ".png")}
+	unusedPaths := thumbnail.KeysFor(unused.Key)
+	for _, key := range []string{overcounted.Key, unused.Key, unusedPaths.Thumbnail, unusedPaths.Preview, unrecorded.Key} {
+		f.put(key, 0)
+	}
+	t.Cleanup(func() {
+		imageCollection.DeleteMany(ctx, bson.M{"user": user})
+		blobCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{overcounted.Digest, unused.Digest, unrecorded.Digest}}})
+	})
+
+	if _, err := blobCollection.InsertMany(ctx, []any{overcounted, unused}); err != nil {
+		t.Fatalf("insert blobs: %v", err)
+	}
+	_, err := imageCollection.InsertMany(ctx, []any{
+		models.Image{ID: primitive.NewObjectID(), User: user, ImagePath: overcounted.Key, Digest: overcounted.Digest},
+		models.Image{ID: primitive.NewObjectID(), User: user, ImagePath: unrecorded.Key, Digest: unrecorded.Digest},
+		models.Image{ID: primitive.NewObjectID(), User: user, ImagePath: unrecorded.Key, Digest: unrecorded.Digest},
+	})
+	if err != nil {
+		t.Fatalf("insert images: %v", err)
+	}
+
+	wantDrifts := []RefCountDrift{
+		{Digest: overcounted.Digest, Key: overcounted.Key, Recorded: 3, Actual: 1},
+		{Digest: unused.Digest, Key: unused.Key, Recorded: 1, Actual: 0},
+		{Digest: unrecorded.Digest, Key: unrecorded.Key, Recorded: -1, Actual: 2},
+	}
+	for _, apply := range []bool{false, true} {
+		report := Report{}
+		if err := checkRefCounts(ctx, Options{Apply: apply, Settle: 10 * time.Millisecond}, &report); err != nil {
+			t.Fatalf("checkRefCounts: %v", err)
+		}
+		if len(report.Errors) > 0 {
+			t.Fatalf("errors: %v", report.Errors)
+		}
+		for _, want := range wantDrifts {
+			if got := refCountDrift(report, want.Digest); got == nil || *got != want {
+				t.Errorf("apply %v: drift %+v, want %+v", apply, got, want)
+			}
+		}
+		if apply {
+			break
+		}
+		if blob := findBlob(t, overcounted.Digest); blob == nil || blob.RefCount != 3 {
+			t.Errorf("dry run changed blob to %+v", blob)
+		}
+	}
+
+	if blob := findBlob(t, overcounted.Digest); blob == nil || blob.RefCount != 1 {
+		t.Errorf("overcounted blob %+v, want a count of 1", blob)
+	}
+	if blob := findBlob(t, unused.Digest); blob != nil {
+		t.Errorf("unused blob kept: %+v", blob)
+	}
+	for _, key := range []string{unused.Key, unusedPaths.Thumbnail, unusedPaths.Preview} {
+		if f.stored(key) {
+			t.Errorf("file %s of the unused blob kept", key)
+		}
+	}
+	blob := findBlob(t, unrecorded.Digest)
+	if blob == nil || blob.RefCount != 2 || blob.Key != unrecorded.Key || blob.Size == 0 {
+		t.Errorf("unrecorded blob %+v, want a count of 2 and its file's size", blob)
+	}
+
+	report := Report{}
+	if err := checkRefCounts(ctx, Options{Apply: true}, &report); err != nil {
+		t.Fatalf("checkRefCounts: %v", err)
+	}
+	for _, want := range wantDrifts {
+		if drift := refCountDrift(report, want.Digest); drift != nil {
+			t.Errorf("drift %+v left after repair", drift)
+		}
+	}
+}
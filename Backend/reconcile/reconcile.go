@@ -0,0 +1,157 @@
+// Package reconcile brings stored files and the documents that point at them
+// back in line. It finds files nothing refers to, documents referring to
+// files that are gone, blob reference counts that drifted, blob deletions
+// cut short, resumable upload parts nobody will finish, images with no
+// recorded size and storage usage that drifted, reports them and, when
+// asked to, repairs them.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	imageCollection           *mongo.Collection = configs.GetCollection(configs.DB, "images")
+	detectionRunCollection    *mongo.Collection = configs.GetCollection(configs.DB, "detection_runs")
+	blobCollection            *mongo.Collection = configs.GetCollection(configs.DB, "blobs")
+	resumableUploadCollection *mongo.Collection = configs.GetCollection(configs.DB, "uploads")
+)
+
+// Options control a reconcile run. Without Apply nothing is changed and the
+// report only says what would be.
+type Options struct {
+	Apply bool
+	// Grace leaves files younger than this alone: uploads and detections
+	// store their files before the documents pointing at them exist.
+	Grace time.Duration
+	// Settle is how long to wait before recounting a blob whose reference
+	// count looks wrong, so uploads caught between taking a reference and
+	// inserting their image are not mistaken for drift.
+	Settle time.Duration
+}
+
+// Report lists what a run found. Repaired counts the problems fixed when
+// applying; Errors holds the ones that could not be.
+type Report struct {
+	Apply          bool            `json:"apply"`
+	StartedAt      time.Time       `json:"startedAt"`
+	FinishedAt     time.Time       `json:"finishedAt"`
+	FilesChecked   int             `json:"filesChecked"`
+	OrphanFiles    []string        `json:"orphanFiles"`
+	MissingFiles   []MissingFile   `json:"missingFiles"`
+	RefCounts      []RefCountDrift `json:"refCounts"`
+	StaleDeletions []string        `json:"staleDeletions"`
+	StaleUploads   []string        `json:"staleUploads"`
+	MissingSizes   []string        `json:"missingSizes"`
+	Usage          []UsageDrift    `json:"usage"`
+	Repaired       int             `json:"repaired"`
+	Errors         []string        `json:"errors,omitempty"`
+}
+
+// MissingFile is a document field naming a file that is not stored.
+// Repair says what applying does about it.
+type MissingFile struct {
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+	Field      string `json:"field"`
+	Key        string `json:"key"`
+	Repair     string `json:"repair"`
+}
+
+// RefCountDrift is a blob whose recorded reference count differs from the
+// number of images using it. Recorded is -1 when there is no blob document.
+type RefCountDrift struct {
+	Digest   string `json:"digest"`
+	Key      string `json:"key"`
+	Recorded int    `json:"recorded"`
+	Actual   int    `json:"actual"`
+}
+
+// UsageDrift is a user whose recorded storage usage differs from what their
+// images take up.
+type UsageDrift struct {
+	User     primitive.ObjectID  `json:"user"`
+	Recorded models.StorageUsage `json:"recorded"`
+	Actual   models.StorageUsage `json:"actual"`
+}
+
+func (r *Report) fail(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Run checks everything once. Reference counts and blob deletions go
+// first, since fixing them can leave files with no blob that the file
+// check then collects. Image sizes are filled in before usage is counted
+// from them.
+func Run(ctx context.Context, options Options) (Report, error) {
+	report := Report{
+		Apply:          options.Apply,
+		StartedAt:      time.Now(),
+		OrphanFiles:    []string{},
+		MissingFiles:   []MissingFile{},
+		RefCounts:      []RefCountDrift{},
+		StaleDeletions: []string{},
+		StaleUploads:   []string{},
+		MissingSizes:   []string{},
+		Usage:          []UsageDrift{},
+	}
+
+	if err := checkRefCounts(ctx, options, &report); err != nil {
+		return report, err
+	}
+	if err := checkDeletions(ctx, options, &report); err != nil {
+		return report, err
+	}
+	if err := checkFiles(ctx, options, &report); err != nil {
+		return report, err
+	}
+	if err := checkUploads(ctx, options, &report); err != nil {
+		return report, err
+	}
+	if err := checkSizes(ctx, options, &report); err != nil {
+		return report, err
+	}
+	if err := checkUsage(ctx, options, &report); err != nil {
+		return report, err
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// Start runs the reconciler every interval in the background, logging what
+// each run found.
+func Start(interval time.Duration, options Options) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			report, err := Run(ctx, options)
+			cancel()
+			if err != nil {
+				log.Printf("reconcile failed: %v", err)
+				continue
+			}
+			log.Print(report.Summary())
+		}
+	}()
+}
+
+// Summary is a one-line account of the report for logs.
+func (r Report) Summary() string {
+	mode := "dry run"
+	if r.Apply {
+		mode = "applied"
+	}
+	return fmt.Sprintf("reconcile (%s): %d files checked, %d orphaned, %d missing, %d reference counts off, %d stale blob deletions, %d stale uploads, %d images without a size, %d users' usage off, %d repaired, %d errors",
+		mode, r.FilesChecked, len(r.OrphanFiles), len(r.MissingFiles), len(r.RefCounts), len(r.StaleDeletions), len(r.StaleUploads), len(r.MissingSizes), len(r.Usage), r.Repaired, len(r.Errors))
+}
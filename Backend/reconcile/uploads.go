@@ -0,0 +1,71 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checkUploads finds the parts of resumable uploads that can never be
+// finished: files with no upload, or whose upload expired or already
+// became an image. Expired uploads are deleted along with their parts.
+func checkUploads(ctx context.Context, options Options, report *Report) error {
+	dir := configs.ResumableUploadDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	cutoff := time.Now().Add(-options.Grace)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		if !staleUpload(ctx, entry.Name()) {
+			continue
+		}
+		report.StaleUploads = append(report.StaleUploads, file)
+		if options.Apply {
+			if err := os.Remove(file); err != nil {
+				report.fail("remove %s: %v", file, err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+
+	if options.Apply {
+		if _, err := resumableUploadCollection.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": time.Now()}}); err != nil {
+			report.fail("delete expired uploads: %v", err)
+		}
+	}
+	return nil
+}
+
+// staleUpload reports whether the part named name belongs to no upload
+// that is still being sent.
+func staleUpload(ctx context.Context, name string) bool {
+	id, err := primitive.ObjectIDFromHex(name)
+	if err != nil {
+		return true
+	}
+	var upload models.ResumableUpload
+	err = resumableUploadCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	return upload.ExpiresAt.Before(time.Now()) || !upload.Image.IsZero() || upload.Error != ""
+}
@@ -0,0 +1,231 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TenJit/SE/Backend/configs"
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	userCollection      *mongo.Collection = configs.GetCollection(configs.DB, "users")
+	migrationCollection *mongo.Collection = configs.GetCollection(configs.DB, "migrations")
+)
+
+// usageBackfill names the migration marking that BackfillUsage has run.
+const usageBackfill = "usage-backfill"
+
+// checkSizes finds images with no recorded size, uploaded before sizes
+// were kept, and fills it in from their blob or else their stored file, so
+// their bytes count towards their owner's usage.
+func checkSizes(ctx context.Context, options Options, report *Report) error {
+	cursor, err := imageCollection.Find(ctx,
+		bson.M{"$or": bson.A{bson.M{"size": bson.M{"$exists": false}}, bson.M{"size": 0}}},
+		findFields("digest", "imagePath"))
+	if err != nil {
+		return err
+	}
+	var images []models.Image
+	if err := cursor.All(ctx, &images); err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		report.MissingSizes = append(report.MissingSizes, image.ID.Hex())
+		if !options.Apply {
+			continue
+		}
+		if err := fillSize(ctx, image); err != nil {
+			report.fail("image %s: %v", image.ID.Hex(), err)
+			continue
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+// fillSize records the size of an image's file, which is its blob's size
+// when it has one.
+func fillSize(ctx context.Context, image models.Image) error {
+	size, err := storedSize(ctx, image)
+	if err != nil {
+		return err
+	}
+	_, err = imageCollection.UpdateOne(ctx,
+		bson.M{"_id": image.ID, "$or": bson.A{bson.M{"size": bson.M{"$exists": false}}, bson.M{"size": 0}}},
+		bson.M{"$set": bson.M{"size": size}})
+	return err
+}
+
+func storedSize(ctx context.Context, image models.Image) (int64, error) {
+	if image.Digest != "" {
+		var blob models.Blob
+		err := blobCollection.FindOne(ctx, bson.M{"_id": image.Digest}).Decode(&blob)
+		if err == nil && blob.Size > 0 {
+			return blob.Size, nil
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+	}
+	info, err := storage.Default.Stat(ctx, image.ImagePath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// checkUsage compares every user's recorded storage usage with what their
+// images take up. Like checkRefCounts it counts twice, Settle apart, before
+// repairing, so uploads and deletes in flight are not mistaken for drift.
+func checkUsage(ctx context.Context, options Options, report *Report) error {
+	drifts, err := findUsageDrift(ctx)
+	if err != nil {
+		return err
+	}
+	if !options.Apply || len(drifts) == 0 {
+		report.Usage = append(report.Usage, drifts...)
+		return nil
+	}
+
+	select {
+	case <-time.After(options.Settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	again, err := findUsageDrift(ctx)
+	if err != nil {
+		return err
+	}
+	persisted := make(map[primitive.ObjectID]UsageDrift, len(again))
+	for _, drift := range again {
+		persisted[drift.User] = drift
+	}
+
+	for _, drift := range drifts {
+		if persisted[drift.User] != drift {
+			continue
+		}
+		report.Usage = append(report.Usage, drift)
+		if err := fixUsage(ctx, drift); err != nil {
+			report.fail("user %s: %v", drift.User.Hex(), err)
+			continue
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+// findUsageDrift lists the users whose recorded usage is not the sum of
+// their images' sizes and the number of their images. Images with no
+// recorded size count as empty until checkSizes fills it in.
+func findUsageDrift(ctx context.Context) ([]UsageDrift, error) {
+	actual, err := imageUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := userCollection.Find(ctx, bson.M{}, findFields("usage"))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	drifts := []UsageDrift{}
+	for _, user := range users {
+		if user.Usage != actual[user.ID] {
+			drifts = append(drifts, UsageDrift{User: user.ID, Recorded: user.Usage, Actual: actual[user.ID]})
+		}
+	}
+	return drifts, nil
+}
+
+// imageUsage adds up the sizes and number of each user's images.
+func imageUsage(ctx context.Context) (map[primitive.ObjectID]models.StorageUsage, error) {
+	cursor, err := imageCollection.Find(ctx, bson.M{}, findFields("user", "size"))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	usage := map[primitive.ObjectID]models.StorageUsage{}
+	for cursor.Next(ctx) {
+		var image models.Image
+		if err := cursor.Decode(&image); err != nil {
+			return nil, err
+		}
+		used := usage[image.User]
+		used.Bytes += image.Size
+		used.Images++
+		usage[image.User] = used
+	}
+	return usage, cursor.Err()
+}
+
+// fixUsage sets a user's usage to what their images take up, provided it
+// is still what was recorded, so an upload or delete racing it wins.
+func fixUsage(ctx context.Context, drift UsageDrift) error {
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":          drift.User,
+			"usage.bytes":  recordedUsage(drift.Recorded.Bytes),
+			"usage.images": recordedUsage(drift.Recorded.Images),
+		},
+		bson.M{"$set": bson.M{"usage": drift.Actual}})
+	return err
+}
+
+// recordedUsage matches a usage counter holding value. Users created before
+// usage was counted have no counters, which read as zero.
+func recordedUsage(value int64) any {
+	if value == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return value
+}
+
+// BackfillUsage fills in missing image sizes and sets every user's usage
+// from their images, once per database: images uploaded before usage was
+// counted were never added to it, so their owners' quotas would not see
+// them. A marker in the migrations collection records that it is done; a
+// run that hit errors leaves no marker and is tried again next start.
+func BackfillUsage(ctx context.Context, options Options) error {
+	err := migrationCollection.FindOne(ctx, bson.M{"_id": usageBackfill}).Err()
+	if err == nil {
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	options.Apply = true
+	report := Report{Apply: true, StartedAt: time.Now(), MissingSizes: []string{}, Usage: []UsageDrift{}}
+	if err := checkSizes(ctx, options, &report); err != nil {
+		return err
+	}
+	if err := checkUsage(ctx, options, &report); err != nil {
+		return err
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("usage backfill: %d image sizes filled in, %d users' usage recounted, %d errors",
+		len(report.MissingSizes), len(report.Usage), len(report.Errors))
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("usage backfill: %s", report.Errors[0])
+	}
+
+	_, err = migrationCollection.InsertOne(ctx, bson.M{"_id": usageBackfill, "doneAt": report.FinishedAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+//go:build integration
+
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+	"github.com/TenJit/SE/Backend/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newUsageFixture stores a user whose recorded usage is wrong and three of
+// their images: one with its size, one without whose blob knows it, and
+// one without and with no blob. Together they take up 147 bytes.
+func newUsageFixture(t *testing.T, usage any) primitive.ObjectID {
+	t.Helper()
+	ctx := context.Background()
+	storage.Default = storage.NewLocal(t.TempDir())
+
+	user := primitive.NewObjectID()
+	digest := primitive.NewObjectID().Hex()
+	key := "uploads/" + primitive.NewObjectID().Hex() + ".png"
+	t.Cleanup(func() {
+		userCollection.DeleteOne(ctx, bson.M{"_id": user})
+		imageCollection.DeleteMany(ctx, bson.M{"user": user})
+		blobCollection.DeleteOne(ctx, bson.M{"_id": digest})
+	})
+
+	userDoc := bson.M{"_id": user, "name": "usage", "email": user.Hex() + "@example.com", "role": "user"}
+	if usage != nil {
+		userDoc["usage"] = usage
+	}
+	if _, err := userCollection.InsertOne(ctx, userDoc); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if _, err := blobCollection.InsertOne(ctx, models.Blob{Digest: digest, Key: "uploads/blob.png", Size: 40, RefCount: 1}); err != nil {
+		t.Fatalf("insert blob: %v", err)
+	}
+	if err := storage.Default.Put(ctx, key, strings.NewReader("seven b"), 7, "image/png"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	_, err := imageCollection.InsertMany(ctx, []any{
+		bson.M{"user": user, "imagePath": "uploads/sized.png", "size": 100},
+		bson.M{"user": user, "imagePath": "uploads/blob.png", "digest": digest},
+		bson.M{"user": user, "imagePath": key},
+	})
+	if err != nil {
+		t.Fatalf("insert images: %v", err)
+	}
+	return user
+}
+
+func usageOf(t *testing.T, user primitive.ObjectID) models.StorageUsage {
+	t.Helper()
+	var found models.User
+	if err := userCollection.FindOne(context.Background(), bson.M{"_id": user}).Decode(&found); err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	return found.Usage
+}
+
+func driftOf(report Report, user primitive.ObjectID) *UsageDrift {
+	for _, drift := range report.Usage {
+		if drift.User == user {
+			return &drift
+		}
+	}
+	return nil
+}
+
+func TestCheckUsage(t *testing.T) {
+	ctx := context.Background()
+	user := newUsageFixture(t, bson.M{"bytes": 5, "images": 9})
+
+	report := Report{}
+	if err := checkSizes(ctx, Options{}, &report); err != nil {
+		t.Fatalf("checkSizes: %v", err)
+	}
+	if err := checkUsage(ctx, Options{}, &report); err != nil {
+		t.Fatalf("checkUsage: %v", err)
+	}
+	if len(report.MissingSizes) < 2 {
+		t.Errorf("missing sizes %v, want the two images without one", report.MissingSizes)
+	}
+	drift := driftOf(report, user)
+	want := UsageDrift{User: user, Recorded: models.StorageUsage{Bytes: 5, Images: 9}, Actual: models.StorageUsage{Bytes: 100, Images: 3}}
+	if drift == nil || *drift != want {
+		t.Errorf("drift %+v, want %+v", drift, want)
+	}
+	if got := usageOf(t, user); got != want.Recorded {
+		t.Errorf("dry run changed usage to %+v", got)
+	}
+
+	report = Report{}
+	if err := checkSizes(ctx, Options{Apply: true}, &report); err != nil {
+		t.Fatalf("checkSizes: %v", err)
+	}
+	if err := checkUsage(ctx, Options{Apply: true}, &report); err != nil {
+		t.Fatalf("checkUsage: %v", err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors: %v", report.Errors)
+	}
+	if got, want := usageOf(t, user), (models.StorageUsage{Bytes: 147, Images: 3}); got != want {
+		t.Errorf("usage %+v, want %+v", got, want)
+	}
+}
+
+func TestBackfillUsage(t *testing.T) {
+	ctx := context.Background()
+	user := newUsageFixture(t, nil)
+	migrationCollection.DeleteOne(ctx, bson.M{"_id": usageBackfill})
+	t.Cleanup(func() { migrationCollection.DeleteOne(ctx, bson.M{"_id": usageBackfill}) })
+
+	if err := BackfillUsage(ctx, Options{}); err != nil {
+		t.Fatalf("BackfillUsage: %v", err)
+	}
+	if got, want := usageOf(t, user), (models.StorageUsage{Bytes: 147, Images: 3}); got != want {
+		t.Errorf("usage %+v, want %+v", got, want)
+	}
+
+	// Once done, it is not done again.
+	userCollection.UpdateOne(ctx, bson.M{"_id": user}, bson.M{"$set": bson.M{"usage.bytes": 1}})
+	if err := BackfillUsage(ctx, Options{}); err != nil {
+		t.Fatalf("BackfillUsage: %v", err)
+	}
+	if got := usageOf(t, user); got.Bytes != 1 {
+		t.Errorf("second backfill changed usage to %+v", got)
+	}
+}
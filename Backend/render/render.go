@@ -0,0 +1,180 @@
+// Package render draws detection results onto images, so annotated images
+// can be made again from boxes that were edited after detection and drawn
+// differently on request.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"slices"
+
+	"github.com/TenJit/SE/Backend/models"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Formats an annotated image can be encoded in.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+const jpegQuality = 95
+
+// Options control what is drawn and how. Classes limits the boxes to those
+// class names, empty meaning every class; MinConfidence drops model boxes
+// scoring below it, while boxes people drew have no score and are always
+// kept. A LineWidth of 0 scales the line with the image, as detect.py does.
+type Options struct {
+	Classes       []string
+	MinConfidence float32
+	LineWidth     int
+	Labels        bool
+}
+
+// Default is how annotated images are stored: every box, labelled.
+var Default = Options{Labels: true}
+
+// palette is yolov5's utils/plots.Colors palette.
+var palette = []color.RGBA{
+	{0xFF, 0x38, 0x38, 0xFF}, {0xFF, 0x9D, 0x97, 0xFF}, {0xFF, 0x70, 0x1F, 0xFF}, {0xFF, 0xB2, 0x1D, 0xFF},
+	{0xCF, 0xD2, 0x31, 0xFF}, {0x48, 0xF9, 0x0A, 0xFF}, {0x92, 0xCC, 0x17, 0xFF}, {0x3D, 0xDB, 0x86, 0xFF},
+	{0x1A, 0x93, 0x34, 0xFF}, {0x00, 0xD4, 0xBB, 0xFF}, {0x2C, 0x99, 0xA8, 0xFF}, {0x00, 0xC2, 0xFF, 0xFF},
+	{0x34, 0x45, 0x93, 0xFF}, {0x64, 0x73, 0xFF, 0xFF}, {0x00, 0x18, 0xEC, 0xFF}, {0x84, 0x38, 0xFF, 0xFF},
+	{0x52, 0x00, 0x85, 0xFF}, {0xCB, 0x38, 0xFF, 0xFF}, {0xFF, 0x95, 0xC8, 0xFF}, {0xFF, 0x37, 0xC7, 0xFF},
+}
+
+// ClassColor picks the palette colour of a class by its name, so a class
+// keeps its colour whatever else is in the image.
+func ClassColor(name string) color.RGBA {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return palette[hash.Sum32()%uint32(len(palette))]
+}
+
+// Draw returns a copy of img with the boxes of objects options selects
+// outlined in their class colour and, with Labels, captioned with their
+// class and confidence.
+func Draw(img image.Image, objects []models.DetectedObject, options Options) *image.RGBA {
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Src)
+
+	lineWidth := options.LineWidth
+	if lineWidth <= 0 {
+		lineWidth = max(int(math.Round(float64(bounds.Dx()+bounds.Dy())/2*0.003)), 2)
+	}
+
+	for _, object := range objects {
+		if len(options.Classes) > 0 && !slices.Contains(options.Classes, object.Name) {
+			continue
+		}
+		c := ClassColor(object.Name)
+		for _, box := range object.Coordinates {
+			if box.Origin != models.OriginHuman && box.Confidence < options.MinConfidence {
+				continue
+			}
+			rect := image.Rect(box.X_min, box.Y_min, box.X_max, box.Y_max)
+			outline(canvas, rect, lineWidth, c)
+			if options.Labels {
+				label(canvas, rect, Caption(object.Name, box), lineWidth, c)
+			}
+		}
+	}
+	return canvas
+}
+
+// Caption is the text a box is labelled with: its class, and the model's
+// confidence when it has one.
+func Caption(name string, box models.Coordinate) string {
+	if box.Origin == models.OriginHuman || box.Confidence == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s %.2f", name, box.Confidence)
+}
+
+// outline draws the border of rect, lineWidth pixels thick and inside it.
+func outline(canvas *image.RGBA, rect image.Rectangle, lineWidth int, c color.RGBA) {
+	lineWidth = min(lineWidth, rect.Dx(), rect.Dy())
+	for _, edge := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+lineWidth),
+		image.Rect(rect.Min.X, rect.Max.Y-lineWidth, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+lineWidth, rect.Max.Y),
+		image.Rect(rect.Max.X-lineWidth, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	} {
+		draw.Draw(canvas, edge.Intersect(canvas.Bounds()), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+}
+
+// label writes text on a filled tag in the box colour above the top left
+// corner of rect, or just inside it when there is no room above. The
+// bitmap font is scaled up with the line width so labels stay readable on
+// large images.
+func label(canvas *image.RGBA, rect image.Rectangle, text string, lineWidth int, c color.RGBA) {
+	face := basicfont.Face7x13
+	padding := 2
+	width := font.MeasureString(face, text).Ceil() + 2*padding
+	height := face.Height + 2*padding
+
+	tag := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(tag, tag.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	drawer := font.Drawer{
+		Dst:  tag,
+		Src:  image.NewUniform(textColor(c)),
+		Face: face,
+		Dot:  fixed.P(padding, padding+face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	scale := max(1, lineWidth/3)
+	size := image.Pt(width*scale, height*scale)
+	at := image.Pt(rect.Min.X, rect.Min.Y-size.Y)
+	if at.Y < 0 {
+		at.Y = rect.Min.Y
+	}
+	target := image.Rectangle{Min: at, Max: at.Add(size)}
+	draw.NearestNeighbor.Scale(canvas, target, tag, tag.Bounds(), draw.Src, nil)
+}
+
+// textColor is black or white, whichever reads better on background.
+func textColor(background color.RGBA) color.Color {
+	luma := 0.299*float64(background.R) + 0.587*float64(background.G) + 0.114*float64(background.B)
+	if luma > 150 {
+		return color.Black
+	}
+	return color.White
+}
+
+// ContentType is the media type of format.
+func ContentType(format string) string {
+	if format == FormatPNG {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// Encode writes an annotated image in format, FormatJPEG or FormatPNG.
+func Encode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
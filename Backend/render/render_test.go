@@ -0,0 +1,132 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/TenJit/SE/Backend/models"
+)
+
+var white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+
+func blank(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func object(name string, boxes ...models.Coordinate) models.DetectedObject {
+	return models.DetectedObject{Name: name, Coordinates: boxes}
+}
+
+func TestDrawOutline(t *testing.T) {
+	img := blank(100, 80)
+	box := models.Coordinate{X_min: 10, Y_min: 20, X_max: 60, Y_max: 70, Confidence: 0.9}
+	canvas := Draw(img, []models.DetectedObject{object("cat", box)}, Options{LineWidth: 3})
+
+	want := ClassColor("cat")
+	for _, p := range []image.Point{
+		{10, 20}, {35, 20}, {59, 22}, // top edge
+		{10, 45}, {12, 45}, // left edge
+		{57, 45}, {59, 45}, // right edge
+		{35, 67}, {59, 69}, // bottom edge
+	} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != want {
+			t.Errorf("outline pixel %v = %v, want %v", p, got, want)
+		}
+	}
+	for _, p := range []image.Point{
+		{35, 45}, {13, 45}, {56, 45}, {35, 23}, {35, 66}, // inside
+		{9, 45}, {60, 45}, {35, 19}, {35, 70}, // outside
+	} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != white {
+			t.Errorf("pixel %v = %v, want it left white", p, got)
+		}
+	}
+	if img.RGBAAt(10, 20) != white {
+		t.Error("Draw changed its input")
+	}
+}
+
+func TestDrawLabel(t *testing.T) {
+	box := models.Coordinate{X_min: 10, Y_min: 40, X_max: 60, Y_max: 70, Confidence: 0.9}
+	for _, labels := range []bool{false, true} {
+		canvas := Draw(blank(100, 80), []models.DetectedObject{object("cat", box)}, Options{LineWidth: 2, Labels: labels})
+		// The tag sits above the box, its padding in the class colour.
+		if got := canvas.RGBAAt(11, 40-2); (got == ClassColor("cat")) != labels {
+			t.Errorf("labels %v: pixel above the box is %v", labels, got)
+		}
+	}
+}
+
+func TestDrawFilters(t *testing.T) {
+	model := func(confidence float32) models.Coordinate {
+		return models.Coordinate{X_min: 10, Y_min: 10, X_max: 40, Y_max: 40, Confidence: confidence}
+	}
+	human := models.Coordinate{X_min: 10, Y_min: 10, X_max: 40, Y_max: 40, Origin: models.OriginHuman}
+
+	for _, test := range []struct {
+		name    string
+		object  models.DetectedObject
+		options Options
+		drawn   bool
+	}{
+		{name: "every class", object: object("cat", model(0.5)), drawn: true},
+		{name: "class asked for", object: object("cat", model(0.5)), options: Options{Classes: []string{"dog", "cat"}}, drawn: true},
+		{name: "class left out", object: object("cat", model(0.5)), options: Options{Classes: []string{"dog"}}},
+		{name: "above min confidence", object: object("cat", model(0.6)), options: Options{MinConfidence: 0.5}, drawn: true},
+		{name: "at min confidence", object: object("cat", model(0.5)), options: Options{MinConfidence: 0.5}, drawn: true},
+		{name: "below min confidence", object: object("cat", model(0.4)), options: Options{MinConfidence: 0.5}},
+		{name: "human box has no score", object: object("cat", human), options: Options{MinConfidence: 0.9}, drawn: true},
+		{name: "human box of a class left out", object: object("cat", human), options: Options{Classes: []string{"dog"}}},
+	} {
+		test.options.LineWidth = 2
+		canvas := Draw(blank(50, 50), []models.DetectedObject{test.object}, test.options)
+		if drawn := canvas.RGBAAt(10, 10) != white; drawn != test.drawn {
+			t.Errorf("%s: drawn %v, want %v", test.name, drawn, test.drawn)
+		}
+	}
+}
+
+func TestCaption(t *testing.T) {
+	for _, test := range []struct {
+		box  models.Coordinate
+		want string
+	}{
+		{models.Coordinate{Confidence: 0.876}, "cat 0.88"},
+		{models.Coordinate{}, "cat"},
+		{models.Coordinate{Confidence: 0.5, Origin: models.OriginHuman}, "cat"},
+	} {
+		if got := Caption("cat", test.box); got != test.want {
+			t.Errorf("Caption(%+v) = %q, want %q", test.box, got, test.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := Draw(blank(20, 10), []models.DetectedObject{object("cat", models.Coordinate{X_max: 10, Y_max: 10})}, Options{LineWidth: 1})
+
+	data, err := Encode(img, FormatPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := color.RGBAModel.Convert(decoded.At(0, 0)); got != ClassColor("cat") {
+		t.Errorf("decoded pixel %v, want %v", got, ClassColor("cat"))
+	}
+
+	if _, err := Encode(img, FormatJPEG); err != nil {
+		t.Errorf("jpeg: %v", err)
+	}
+	if _, err := Encode(img, "gif"); err == nil {
+		t.Error("Encode accepted gif")
+	}
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/TenJit/SE/Backend/controllers"
+	"github.com/TenJit/SE/Backend/signedurl"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileRoute serves stored files through signed links. The signature is the
+// authorization, so the route is not behind Protect.
+func FileRoute(app *gin.Engine) {
+	app.GET(signedurl.Prefix+"*key", controllers.ServeSignedFile)
+}
@@ -10,16 +10,39 @@ import (
 func ImageRoute(app *gin.Engine) {
 	imagesRoutes := app.Group("/images")
 	{
+		imagesRoutes.OPTIONS("/uploads", controllers.ResumableUploadOptions)
+		imagesRoutes.GET("/events", middleware.ProtectToken(controllers.EventTokenPurpose), controllers.StreamImageEvents)
 		protectedRoutes := imagesRoutes.Group("", middleware.Protect)
 		{
 			protectedRoutes.POST("", controllers.CreateImage)
 			protectedRoutes.GET("", controllers.GetAllImages)
+			protectedRoutes.POST("/events/token", controllers.CreateEventToken)
 			protectedRoutes.GET("/:id", controllers.GetImageByID)
 			protectedRoutes.PUT("/:id", controllers.RenameImage)
+			protectedRoutes.GET("/:id/file", controllers.StreamImageFile)
+			protectedRoutes.GET("/:id/render", controllers.RenderImage)
+			protectedRoutes.POST("/:id/detect", controllers.RedetectImage)
+			protectedRoutes.POST("/:id/boxes", controllers.AddBox)
+			protectedRoutes.PATCH("/:id/boxes/:boxId", controllers.UpdateBox)
+			protectedRoutes.DELETE("/:id/boxes/:boxId", controllers.DeleteBox)
+			protectedRoutes.POST("/compare", controllers.CompareModels)
+			protectedRoutes.POST("/uploads", controllers.CreateResumableUpload)
+			protectedRoutes.HEAD("/uploads/:uploadId", controllers.GetResumableUploadOffset)
+			protectedRoutes.GET("/uploads/:uploadId", controllers.GetResumableUpload)
+			protectedRoutes.PATCH("/uploads/:uploadId", controllers.PatchResumableUpload)
+			protectedRoutes.DELETE("/uploads/:uploadId", controllers.DeleteResumableUpload)
+			protectedRoutes.POST("/batches", controllers.CreateBatch)
+			protectedRoutes.GET("/batches", controllers.GetAllBatches)
+			protectedRoutes.GET("/batches/:id", controllers.GetBatchByID)
+			protectedRoutes.GET("/:id/runs", controllers.GetImageRuns)
+			protectedRoutes.GET("/:id/runs/compare", controllers.CompareRuns)
+			protectedRoutes.GET("/:id/runs/:runId", controllers.GetImageRun)
+			protectedRoutes.PUT("/:id/runs/:runId/current", controllers.SetCurrentRun)
 			protectedRoutes.DELETE("/:id", controllers.DeleteImage)
 			protectedRoutes.DELETE("", controllers.DeleteManyImages)
 			protectedRoutes.GET("/download/:id", controllers.DownloadImage)
 			protectedRoutes.POST("/downloadManyImages", controllers.DownloadManyImages)
+			protectedRoutes.POST("/export", controllers.ExportAnnotations)
 		}
 	}
 }
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/TenJit/SE/Backend/controllers"
+	"github.com/TenJit/SE/Backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ModelRoute(app *gin.Engine) {
+	modelsRoutes := app.Group("/models", middleware.Protect)
+	{
+		modelsRoutes.GET("", controllers.GetAllModels)
+		modelsRoutes.GET("/:id", controllers.GetModelByID)
+
+		adminRoutes := modelsRoutes.Group("", middleware.Authorize("admin"))
+		{
+			adminRoutes.POST("", controllers.CreateModel)
+			adminRoutes.PUT("/:id", controllers.UpdateModel)
+			adminRoutes.DELETE("/:id", controllers.DeleteModel)
+		}
+	}
+}
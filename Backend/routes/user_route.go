@@ -13,6 +13,8 @@ func UserRoute(app *gin.Engine) {
 	app.GET("/getme", middleware.Protect, controllers.GetMe)
 	app.GET("/logout", controllers.LogOut)
 	app.GET("/users", middleware.Protect, middleware.Authorize("admin"), controllers.GetAllUser)
+	app.PUT("/users/:id/quota", middleware.Protect, middleware.Authorize("admin"), controllers.SetUserQuota)
+	app.DELETE("/users/:id/quota", middleware.Protect, middleware.Authorize("admin"), controllers.ClearUserQuota)
 	app.PUT("/updateuser/:id", middleware.Protect, controllers.UpdateUser)
 	app.DELETE("/deleteuser/:id", middleware.Protect, controllers.DeleteUser)
 }
@@ -0,0 +1,128 @@
+// Package signedurl issues the expiring links stored files are served at and
+// checks them when they come back. A link names a storage key and carries
+// an HMAC-SHA256 signature over the key and its expiry, so it can be neither
+// altered nor made up without the server's secret.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix is the path signed links are served under.
+const Prefix = "/files/"
+
+var (
+	ErrExpired   = errors.New("signedurl: link has expired")
+	ErrSignature = errors.New("signedurl: invalid signature")
+)
+
+// Signer signs links with one secret and lifetime.
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// Default signs the links handed out by the API. It uses a random secret
+// until main replaces it with one from the configuration, so links never
+// verify with an empty key.
+var Default = New(randomSecret(), time.Hour)
+
+func New(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// URL returns a link to the file stored under key that stays valid for at
+// least the signer's lifetime. Expiry times are rounded up to half a
+// lifetime so repeated listings hand out the same links and browsers can
+// cache what they fetched.
+func (s *Signer) URL(key string) string {
+	step := max(int64(s.ttl/time.Second)/2, 1)
+	expires := (time.Now().Add(s.ttl).Unix()/step + 1) * step
+	expiresStr := strconv.FormatInt(expires, 10)
+
+	query := url.Values{}
+	query.Set("expires", expiresStr)
+	query.Set("signature", s.signature(key, expiresStr))
+	return Prefix + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode()
+}
+
+// Verify checks a link to key with the given expires and signature query
+// values.
+func (s *Signer) Verify(key string, expires string, signature string) error {
+	expected := s.signature(key, expires)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	if time.Now().Unix() > unix {
+		return ErrExpired
+	}
+	return nil
+}
+
+// For returns a signer for another purpose with its own lifetime. Its
+// secret is derived from s's, so what one signs never verifies with the
+// other.
+func (s *Signer) For(purpose string, ttl time.Duration) *Signer {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("signedurl purpose\x00" + purpose))
+	return New(mac.Sum(nil), ttl)
+}
+
+// Token returns a token vouching for subject that expires after the
+// signer's lifetime, for clients that cannot send an Authorization header.
+// subject must not contain a dot.
+func (s *Signer) Token(subject string) (string, time.Time) {
+	expires := time.Now().Add(s.ttl).Unix()
+	expiresStr := strconv.FormatInt(expires, 10)
+	return subject + "." + expiresStr + "." + s.signature(subject, expiresStr), time.Unix(expires, 0)
+}
+
+// VerifyToken checks a token made by Token and returns its subject.
+func (s *Signer) VerifyToken(token string) (string, error) {
+	subject, expires, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrSignature
+	}
+	expires, signature, ok := strings.Cut(expires, ".")
+	if !ok {
+		return "", ErrSignature
+	}
+	if err := s.Verify(subject, expires, signature); err != nil {
+		return "", err
+	}
+	return subject, nil
+}
+
+// Expires returns when a link with the given expires value stops working.
+func Expires(expires string) time.Time {
+	unix, _ := strconv.ParseInt(expires, 10, 64)
+	return time.Unix(unix, 0)
+}
+
+func (s *Signer) signature(key string, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.TrimPrefix(key, "/")))
+	mac.Write([]byte{0})
+	mac.Write([]byte(expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
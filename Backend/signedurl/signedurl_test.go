@@ -0,0 +1,48 @@
+package signedurl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	signer := New([]byte("secret"), time.Hour).For("events", time.Minute)
+
+	token, expires := signer.Token("user")
+	if until := time.Until(expires); until <= 0 || until > time.Minute {
+		t.Errorf("token expires in %v, want within a minute", until)
+	}
+	subject, err := signer.VerifyToken(token)
+	if err != nil || subject != "user" {
+		t.Fatalf("VerifyToken = %q, %v", subject, err)
+	}
+
+	for name, token := range map[string]string{
+		"altered subject": "other" + strings.TrimPrefix(token, "user"),
+		"no signature":    "user.1",
+		"garbage":         "user",
+	} {
+		if _, err := signer.VerifyToken(token); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: got %v, want ErrSignature", name, err)
+		}
+	}
+
+	expired, _ := New([]byte("secret"), time.Hour).For("events", -time.Minute).Token("user")
+	if _, err := signer.VerifyToken(expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token: got %v, want ErrExpired", err)
+	}
+}
+
+func TestForSeparatesPurposes(t *testing.T) {
+	parent := New([]byte("secret"), time.Hour)
+	token, _ := parent.For("events", time.Minute).Token("user")
+
+	if _, err := parent.VerifyToken(token); !errors.Is(err, ErrSignature) {
+		t.Errorf("parent verified an events token: %v", err)
+	}
+	if _, err := parent.For("other", time.Minute).VerifyToken(token); !errors.Is(err, ErrSignature) {
+		t.Errorf("other purpose verified an events token: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 stores objects in a bucket of any S3-compatible service, such as AWS S3
+// or a MinIO server. The bucket is created when it does not exist.
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(options Options) (*S3, error) {
+	if options.S3Endpoint == "" || options.S3Bucket == "" {
+		return nil, errors.New("s3 storage needs an endpoint and a bucket")
+	}
+
+	client, err := minio.New(options.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.S3AccessKey, options.S3SecretKey, ""),
+		Secure: options.S3UseSSL,
+		Region: options.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, options.S3Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %q: %w", options.S3Bucket, err)
+	}
+	if !exists {
+		err := client.MakeBucket(ctx, options.S3Bucket, minio.MakeBucketOptions{Region: options.S3Region})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bucket %q: %w", options.S3Bucket, err)
+		}
+	}
+
+	return &S3{client: client, bucket: options.S3Bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, s.err(err)
+	}
+	// GetObject does not contact the server until the object is used.
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, ObjectInfo{}, s.err(err)
+	}
+	return object, objectInfo(info), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, s.err(err)
+	}
+	return objectInfo(info), nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, objectInfo(info))
+	}
+	return objects, nil
+}
+
+// err maps a missing object to ErrNotFound.
+func (s *S3) err(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
+
+func objectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:         info.Key,
+		Size:        info.Size,
+		ModTime:     info.LastModified,
+		ContentType: info.ContentType,
+	}
+}
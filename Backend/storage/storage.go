@@ -0,0 +1,84 @@
+// Package storage keeps uploaded and generated image files behind the
+// BlobStore interface so they can live on local disk or in an S3-compatible
+// bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Default is the store the server keeps its images in. main replaces it
+// with the configured store at startup.
+var Default BlobStore = NewLocal(".")
+
+// ObjectInfo describes a stored object. Keys always use forward slashes.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// BlobStore stores objects by key. Deleting a missing key is not an error;
+// Get and Stat return ErrNotFound for it.
+type BlobStore interface {
+	// Put stores r under key, replacing any existing object. size may be -1
+	// when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// Options holds the settings used to build a store. Root only applies to
+// the local store, the S3 fields only to the s3 store.
+type Options struct {
+	Root        string
+	S3Endpoint  string
+	S3AccessKey string
+	S3SecretKey string
+	S3Bucket    string
+	S3Region    string
+	S3UseSSL    bool
+}
+
+// New returns the store registered under name.
+func New(name string, options Options) (BlobStore, error) {
+	switch name {
+	case "", "local":
+		return NewLocal(options.Root), nil
+	case "s3":
+		return NewS3(options)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", name)
+	}
+}
+
+// ContentType guesses the content type of key from its extension.
+func ContentType(key string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// cleanKey rejects keys that would escape the store.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(key, "\\", "/"))
+	if key == "" || path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return cleaned, nil
+}
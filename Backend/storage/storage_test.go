@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBlobStore checks that store behaves as BlobStore documents. Every key
+// it writes starts with prefix, which it cleans up afterwards.
+func testBlobStore(t *testing.T, store BlobStore, prefix string) {
+	ctx := context.Background()
+	key := func(name string) string { return prefix + name }
+	put := func(t *testing.T, name string, data string, size int64) {
+		t.Helper()
+		if err := store.Put(ctx, key(name), strings.NewReader(data), size, "image/jpeg"); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+		t.Cleanup(func() { store.Delete(ctx, key(name)) })
+	}
+	read := func(t *testing.T, name string) (string, ObjectInfo) {
+		t.Helper()
+		r, info, err := store.Get(ctx, key(name))
+		if err != nil {
+			t.Fatalf("Get %s: %v", name, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(data), info
+	}
+
+	t.Run("PutGet", func(t *testing.T) {
+		put(t, "images/a.jpg", "first", 5)
+		data, info := read(t, "images/a.jpg")
+		if data != "first" {
+			t.Errorf("got %q, want %q", data, "first")
+		}
+		if info.Key != key("images/a.jpg") || info.Size != 5 {
+			t.Errorf("got info %+v", info)
+		}
+		if info.ContentType != "image/jpeg" {
+			t.Errorf("got content type %q", info.ContentType)
+		}
+	})
+
+	t.Run("PutReplaces", func(t *testing.T) {
+		put(t, "images/b.jpg", "first", 5)
+		put(t, "images/b.jpg", "second version", -1)
+		if data, info := read(t, "images/b.jpg"); data != "second version" || info.Size != 14 {
+			t.Errorf("got %q of size %d", data, info.Size)
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		put(t, "images/c.jpg", "stat me", 7)
+		info, err := store.Stat(ctx, key("images/c.jpg"))
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Key != key("images/c.jpg") || info.Size != 7 {
+			t.Errorf("got info %+v", info)
+		}
+		if age := time.Since(info.ModTime); age < -time.Minute || age > time.Hour {
+			t.Errorf("got mod time %v", info.ModTime)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, _, err := store.Get(ctx, key("missing.jpg")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.Stat(ctx, key("missing.jpg")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat: got %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, key("missing.jpg")); err != nil {
+			t.Errorf("Delete: got %v, want nil", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		put(t, "images/d.jpg", "delete me", 9)
+		if err := store.Delete(ctx, key("images/d.jpg")); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Stat(ctx, key("images/d.jpg")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat after Delete: got %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		put(t, "list/a.jpg", "a", 1)
+		put(t, "list/sub/b.jpg", "b", 1)
+		put(t, "list/sub/c.png", "c", 1)
+		put(t, "listed.jpg", "d", 1)
+
+		for _, test := range []struct {
+			prefix string
+			want   []string
+		}{
+			{"list/", []string{"list/a.jpg", "list/sub/b.jpg", "list/sub/c.png"}},
+			{"list/sub/", []string{"list/sub/b.jpg", "list/sub/c.png"}},
+			{"list/sub/b", []string{"list/sub/b.jpg"}},
+			{"list", []string{"list/a.jpg", "list/sub/b.jpg", "list/sub/c.png", "listed.jpg"}},
+			{"nothing/", nil},
+		} {
+			objects, err := store.List(ctx, key(test.prefix))
+			if err != nil {
+				t.Errorf("List %q: %v", test.prefix, err)
+				continue
+			}
+			var got []string
+			for _, object := range objects {
+				got = append(got, strings.TrimPrefix(object.Key, prefix))
+				if object.Size != 1 {
+					t.Errorf("List %q: %s has size %d", test.prefix, object.Key, object.Size)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("List %q: got %v, want %v", test.prefix, got, test.want)
+			}
+		}
+	})
+
+	t.Run("InvalidKeys", func(t *testing.T) {
+		for _, bad := range []string{"", "../escape.jpg", "/absolute.jpg", "a/../../escape.jpg"} {
+			if err := store.Put(ctx, bad, strings.NewReader("x"), 1, "image/jpeg"); err == nil {
+				store.Delete(ctx, bad)
+				t.Errorf("Put %q: got nil error", bad)
+			}
+		}
+	})
+}
+
+func TestLocal(t *testing.T) {
+	testBlobStore(t, NewLocal(t.TempDir()), "")
+}
+
+// TestS3 runs against an S3-compatible server, such as MinIO, when
+// STORAGE_TEST_S3_ENDPOINT is set, e.g. after
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	STORAGE_TEST_S3_ENDPOINT=localhost:9000 STORAGE_TEST_S3_ACCESS_KEY=minioadmin \
+//	STORAGE_TEST_S3_SECRET_KEY=minioadmin go test ./storage/
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_S3_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("STORAGE_TEST_S3_BUCKET")
+	if bucket == "" {
+		bucket = "storage-test"
+	}
+
+	store, err := NewS3(Options{
+		S3Endpoint:  endpoint,
+		S3AccessKey: os.Getenv("STORAGE_TEST_S3_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("STORAGE_TEST_S3_SECRET_KEY"),
+		S3Bucket:    bucket,
+		S3UseSSL:    os.Getenv("STORAGE_TEST_S3_USE_SSL") == "true",
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	// A prefix of its own keeps the run apart from anything else in the
+	// bucket.
+	testBlobStore(t, store, "storage-test-"+strconv.FormatInt(time.Now().UnixNano(), 36)+"/")
+}
@@ -0,0 +1,130 @@
+// Package thumbnail makes the small JPEG copies of images shown in list and
+// detail views, so the gallery never has to load full-size files.
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/TenJit/SE/Backend/storage"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Longest side of each generated size, in pixels.
+const (
+	ThumbnailSize = 256
+	PreviewSize   = 1024
+
+	jpegQuality = 85
+)
+
+// Paths are the keys the generated copies of an image are stored under.
+type Paths struct {
+	Thumbnail string
+	Preview   string
+}
+
+// KeysFor returns where the copies of the image stored under key go. They
+// are derived from key so they can always be found and removed with it.
+func KeysFor(key string) Paths {
+	base := strings.TrimSuffix(path.Base(key), path.Ext(key))
+	return Paths{
+		Thumbnail: "public/thumbnails/" + base + "_thumb.jpg",
+		Preview:   "public/thumbnails/" + base + "_preview.jpg",
+	}
+}
+
+// Generate stores a thumbnail and a preview of img, the image stored under
+// key.
+func Generate(ctx context.Context, store storage.BlobStore, key string, img image.Image) (Paths, error) {
+	paths := KeysFor(key)
+	preview := Resize(img, PreviewSize)
+	for _, size := range []struct {
+		key string
+		img image.Image
+	}{
+		{paths.Preview, preview},
+		{paths.Thumbnail, Resize(preview, ThumbnailSize)},
+	} {
+		data, err := Encode(size.img)
+		if err != nil {
+			return Paths{}, err
+		}
+		if err := store.Put(ctx, size.key, bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+			return Paths{}, fmt.Errorf("failed to store %s: %w", size.key, err)
+		}
+	}
+	return paths, nil
+}
+
+// GenerateFrom decodes the image read from r and stores its copies as
+// Generate does.
+func GenerateFrom(ctx context.Context, store storage.BlobStore, key string, r io.Reader) (Paths, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return Paths{}, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return Generate(ctx, store, key, img)
+}
+
+// Remove deletes the copies of the image stored under key.
+func Remove(ctx context.Context, store storage.BlobStore, key string) error {
+	paths := KeysFor(key)
+	if err := store.Delete(ctx, paths.Thumbnail); err != nil {
+		return err
+	}
+	return store.Delete(ctx, paths.Preview)
+}
+
+// Resize scales img down to fit in a maxSize x maxSize square, keeping its
+// aspect ratio. Images that already fit are returned unchanged.
+func Resize(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+
+	// Halve with a cheap filter first so the final pass, which looks at
+	// more source pixels the more it shrinks, stays fast on large photos.
+	for width > 4*maxSize || height > 4*maxSize {
+		width, height = max(1, width/2), max(1, height/2)
+		img = scale(img, width, height, draw.ApproxBiLinear)
+	}
+
+	ratio := float64(maxSize) / float64(max(width, height))
+	width = max(1, int(math.Round(float64(width)*ratio)))
+	height = max(1, int(math.Round(float64(height)*ratio)))
+	return scale(img, width, height, draw.CatmullRom)
+}
+
+func scale(img image.Image, width int, height int, scaler draw.Scaler) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Encode writes img as a JPEG, flattening any transparency onto white.
+func Encode(img image.Image) ([]byte, error) {
+	flattened := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(flattened, flattened.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flattened, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}